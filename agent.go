@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cricket-collector/internal/collectors"
+	"cricket-collector/internal/sender"
+)
+
+// agent ties the collectors to the sinks: every cycle the registry gathers
+// a payload and each sink gets a copy of it.
+type agent struct {
+	config   Config
+	registry *collectors.Registry
+	api      *sender.API
+	sinks    []sender.Sink
+	exporter *sender.PrometheusExporter
+	health   *health
+	// Intervals set by the API, for the main loop to switch to
+	reschedule     chan time.Duration
+	collectTimeout time.Duration
+	// Held for the duration of a cycle, so ticks can't start overlapping ones
+	cycle sync.Mutex
+	// Ticks skipped since startup because the previous cycle was still running
+	skipped atomic.Uint64
+	// How long the previous cycle took to hand its payload to the sinks
+	sendDuration time.Duration
+	// The settings the API sent that were applied, kept over reloads
+	remote sender.RemoteConfig
+}
+
+func newAgent(config Config) (*agent, error) {
+	a := &agent{
+		config:         config,
+		reschedule:     make(chan time.Duration, 1),
+		collectTimeout: config.CollectTimeout,
+		health:         newHealth(time.Duration(config.CollectInterval) * time.Second),
+	}
+	// The registry and the sinks keep pointers into a.config, so settings
+	// the API changes there apply to them from the next cycle
+	registry, err := collectors.New(&a.config.Collectors)
+	if err != nil {
+		return nil, err
+	}
+	a.registry = registry
+
+	output := &a.config.Sender
+	if output.HTTPSink {
+		api, err := sender.NewAPI(output)
+		if err != nil {
+			return nil, err
+		}
+		api.OnConfig = a.applyRemoteConfig
+		a.api = api
+		a.sinks = append(a.sinks, api)
+	}
+	if output.InfluxURL != "" {
+		a.sinks = append(a.sinks, sender.NewInfluxSink(*output))
+	}
+	if output.StatsdAddr != "" {
+		statsd, err := sender.NewStatsdSink(*output)
+		if err != nil {
+			return nil, err
+		}
+		a.sinks = append(a.sinks, statsd)
+	}
+	if output.OTLPEndpoint != "" {
+		a.sinks = append(a.sinks, sender.NewOTLPSink(*output))
+	}
+	if output.FileSink != "" {
+		a.sinks = append(a.sinks, sender.NewFileSink(*output))
+	}
+	return a, nil
+}
+
+var (
+	// errCycleBusy is returned when a tick is skipped because the previous
+	// cycle is still running.
+	errCycleBusy = errors.New("previous collection still running")
+	// errCollectionFailed and errSendFailed tell run-once mode which part of
+	// a cycle failed, for its exit code.
+	errCollectionFailed = errors.New("collection failed")
+	errSendFailed       = errors.New("send failed")
+)
+
+// collectAndSendMetrics runs one collection cycle, or skips it if the
+// previous one hasn't finished (a slow disk or a send retrying), rather than
+// piling up overlapping cycles. The returned error is only used by run-once
+// mode; failures are logged (and spooled) here already. It wraps
+// errCollectionFailed when collectors failed and errSendFailed when a sink
+// did.
+func (a *agent) collectAndSendMetrics(ctx context.Context) error {
+	if !a.cycle.TryLock() {
+		skipped := a.skipped.Add(1)
+		slog.Warn("Previous collection is still running, skipping this interval", "skipped_cycles", skipped)
+		return errCycleBusy
+	}
+	defer a.cycle.Unlock()
+	// A single run has no later cycle to fill up a batch
+	return a.runCycle(ctx, a.config.RunOnce)
+}
+
+// collectAndSendFinal waits for a running cycle to finish, then runs one more
+// and sends whatever is left in the batch.
+func (a *agent) collectAndSendFinal(ctx context.Context) error {
+	a.cycle.Lock()
+	defer a.cycle.Unlock()
+	return a.runCycle(ctx, true)
+}
+
+// runCycle collects a payload and hands it to every sink. flush makes sinks
+// that batch payloads send what they hold.
+func (a *agent) runCycle(ctx context.Context, flush bool) error {
+	config := a.config
+
+	collectCtx, cancel := context.WithTimeout(ctx, config.CollectTimeout)
+	collectStart := time.Now()
+	payload := a.registry.Collect(collectCtx)
+	collectDuration := time.Since(collectStart)
+	if collectCtx.Err() != nil {
+		slog.Warn("Collection did not finish in time, sending the metrics gathered so far", "collect_timeout", config.CollectTimeout)
+	}
+	cancel()
+	a.health.collected()
+
+	if payload.Collector != nil {
+		payload.Collector.SkippedCycles = a.skipped.Load()
+		payload.Collector.CollectionDurationMs = collectDuration.Milliseconds()
+		// This cycle's send hasn't happened yet
+		if a.sendDuration > 0 {
+			sendMs := a.sendDuration.Milliseconds()
+			payload.Collector.SendDurationMs = &sendMs
+		}
+	}
+
+	if a.exporter != nil {
+		a.exporter.Update(payload)
+	}
+
+	if config.Debug {
+		slog.Debug("Collected metrics", "duration", collectDuration.Round(time.Millisecond),
+			"cpu", formatPercent(payload.CPUUsagePercent), "memory", formatPercent(payload.MemoryUsagePercent), "disk", formatPercent(payload.DiskUsagePercent),
+			"skipped_cycles", a.skipped.Load())
+		slog.Debug("Uptime", "uptime", time.Duration(payload.UptimeSeconds)*time.Second,
+			"boot_time", payload.BootTime, "rebooted", payload.Rebooted)
+		if payload.MemoryUsedBytes != nil && payload.MemoryTotalBytes != nil && payload.MemoryAvailableBytes != nil {
+			attrs := []any{"used_bytes", *payload.MemoryUsedBytes, "total_bytes", *payload.MemoryTotalBytes,
+				"available_bytes", *payload.MemoryAvailableBytes}
+			for _, detail := range []struct {
+				key   string
+				value *uint64
+			}{
+				{"cached_bytes", payload.MemoryCachedBytes},
+				{"buffers_bytes", payload.MemoryBuffersBytes},
+				{"shared_bytes", payload.MemorySharedBytes},
+				{"slab_bytes", payload.MemorySlabBytes},
+				{"dirty_bytes", payload.MemoryDirtyBytes},
+			} {
+				if detail.value != nil {
+					attrs = append(attrs, detail.key, *detail.value)
+				}
+			}
+			slog.Debug("Memory details", attrs...)
+		}
+		if payload.SwapUsedBytes != nil && payload.SwapTotalBytes != nil {
+			slog.Debug("Swap details", "used_bytes", *payload.SwapUsedBytes, "total_bytes", *payload.SwapTotalBytes)
+		}
+		if payload.IntervalSeconds != nil && payload.DiskReadBytesPerSec != nil && payload.NetworkRXBytesPerSec != nil {
+			slog.Debug("I/O rates", "interval_seconds", *payload.IntervalSeconds,
+				"disk_read_bytes_per_sec", *payload.DiskReadBytesPerSec, "disk_write_bytes_per_sec", *payload.DiskWriteBytesPerSec,
+				"network_rx_bytes_per_sec", *payload.NetworkRXBytesPerSec, "network_tx_bytes_per_sec", *payload.NetworkTXBytesPerSec,
+				"counter_reset", payload.CounterReset)
+		}
+		if len(payload.CPUPerCore) > 0 {
+			coreUsage := make([]string, len(payload.CPUPerCore))
+			for i, core := range payload.CPUPerCore {
+				coreUsage[i] = fmt.Sprintf("cpu%d=%.1f%%", core.Core, core.UsagePercent)
+			}
+			slog.Debug("Per-core CPU", "cores", strings.Join(coreUsage, " "),
+				"max_percent", *payload.CPUCoreMaxPercent, "min_percent", *payload.CPUCoreMinPercent)
+		}
+	}
+
+	var collectErr error
+	if len(payload.CollectionErrors) > 0 {
+		collectErr = fmt.Errorf("%w: %s", errCollectionFailed, strings.Join(payload.CollectionErrors, ", "))
+	}
+
+	if config.DryRun {
+		jsonData, err := json.MarshalIndent(payload, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal metrics: %w", err)
+		}
+		fmt.Println(string(jsonData))
+		return collectErr
+	}
+
+	if a.api != nil {
+		// As of the end of the previous cycle
+		payload.Spool = a.api.SpoolStats()
+	}
+
+	// Every sink gets the payload at the same time, so one that is slow or
+	// failing can't hold up or starve the others
+	errs := make([]error, len(a.sinks))
+	sendStart := time.Now()
+	var wg sync.WaitGroup
+	for i, sink := range a.sinks {
+		wg.Add(1)
+		go func(i int, sink sender.Sink) {
+			defer wg.Done()
+			err := sink.Send(ctx, payload, flush)
+			if err != nil && !errors.Is(err, sender.ErrThrottled) {
+				slog.Error("Error sending metrics", sinkErrorAttrs(sink.Name(), err)...)
+			}
+			errs[i] = err
+		}(i, sink)
+	}
+	wg.Wait()
+	a.sendDuration = time.Since(sendStart)
+	slog.Debug("Cycle finished", "collect_ms", collectDuration.Milliseconds(),
+		"send_ms", a.sendDuration.Milliseconds(), "collection_errors", len(payload.CollectionErrors))
+
+	sendErr := errors.Join(errs...)
+	// Throttled payloads weren't sent, but didn't fail either
+	if len(a.sinks) > 0 && !errors.Is(sendErr, sender.ErrThrottled) {
+		a.health.sent(sendErr)
+	}
+	if sendErr != nil {
+		sendErr = fmt.Errorf("%w: %w", errSendFailed, sendErr)
+	}
+	return errors.Join(sendErr, collectErr)
+}
+
+// formatPercent formats a percentage for the logs, which may be unknown
+// when its collector failed or is disabled.
+func formatPercent(value *float64) string {
+	if value == nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("%.2f%%", *value)
+}
+
+// sinkErrorAttrs are the attributes of a failed send, with the endpoint and
+// status code when the sink reported them, so failures can be grouped by
+// reason across hosts.
+func sinkErrorAttrs(sink string, err error) []any {
+	attrs := []any{"sink", sink, "error", err}
+	var sendErr *sender.SendError
+	if errors.As(err, &sendErr) {
+		attrs = append(attrs, "endpoint", sendErr.Endpoint, "attempts", sendErr.Attempts)
+		if sendErr.StatusCode != 0 {
+			attrs = append(attrs, "status", sendErr.StatusCode)
+		}
+	}
+	return attrs
+}
+
+// apiHostPort returns the host:port the API URL points at.
+func apiHostPort(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// applyRemoteConfig validates and applies settings sent by the API. It runs
+// while the cycle is held, so collection picks them up from the next cycle.
+func (a *agent) applyRemoteConfig(rc sender.RemoteConfig) {
+	config := &a.config
+
+	if rc.CollectInterval != nil && *rc.CollectInterval != config.CollectInterval {
+		if *rc.CollectInterval < 1 {
+			slog.Warn("Ignoring collect_interval from the API, it must be at least 1 second", "value", *rc.CollectInterval)
+		} else {
+			slog.Info("API changed a setting", "setting", "collect_interval", "from", config.CollectInterval, "to", *rc.CollectInterval)
+			a.remote.CollectInterval = rc.CollectInterval
+			config.CollectInterval = *rc.CollectInterval
+			config.Sender.CollectInterval = *rc.CollectInterval
+			interval := time.Duration(config.CollectInterval) * time.Second
+			// Collection must still finish well within the interval
+			config.CollectTimeout = min(a.collectTimeout, interval/2)
+			// Only the latest interval matters if the main loop hasn't
+			// picked up the previous one yet
+			select {
+			case <-a.reschedule:
+			default:
+			}
+			a.reschedule <- interval
+			a.health.setInterval(interval)
+		}
+	}
+	collect := &config.Collectors
+	if rc.TopProcesses != nil && *rc.TopProcesses != collect.TopProcesses {
+		if *rc.TopProcesses < 0 {
+			slog.Warn("Ignoring top_processes from the API, it can't be negative", "value", *rc.TopProcesses)
+		} else {
+			slog.Info("API changed a setting", "setting", "top_processes", "from", collect.TopProcesses, "to", *rc.TopProcesses)
+			a.remote.TopProcesses = rc.TopProcesses
+			collect.TopProcesses = *rc.TopProcesses
+		}
+	}
+	applyRemoteBool("collect_percpu", rc.CollectPerCPU, &collect.CollectPerCPU, &a.remote.CollectPerCPU)
+	applyRemoteBool("tcp_stats", rc.TCPStats, &collect.TCPStats, &a.remote.TCPStats)
+	applyRemoteBool("collect_temps", rc.CollectTemps, &collect.CollectTemps, &a.remote.CollectTemps)
+	applyRemoteBool("psi", rc.PSI, &collect.PSI, &a.remote.PSI)
+}
+
+func applyRemoteBool(name string, value *bool, setting *bool, remote **bool) {
+	if value != nil && *value != *setting {
+		slog.Info("API changed a setting", "setting", name, "from", *setting, "to", *value)
+		*setting = *value
+		*remote = value
+	}
+}
+
+// withRemoteConfig puts the settings the API sent, which were checked when
+// they arrived, back into a configuration read again.
+func withRemoteConfig(config *Config, rc sender.RemoteConfig) {
+	if rc.CollectInterval != nil {
+		config.CollectInterval = *rc.CollectInterval
+		config.Sender.CollectInterval = *rc.CollectInterval
+		config.CollectTimeout = min(config.CollectTimeout, time.Duration(*rc.CollectInterval)*time.Second/2)
+	}
+	collect := &config.Collectors
+	for _, setting := range []struct {
+		value *int
+		field *int
+	}{
+		{rc.TopProcesses, &collect.TopProcesses},
+	} {
+		if setting.value != nil {
+			*setting.field = *setting.value
+		}
+	}
+	for _, setting := range []struct {
+		value *bool
+		field *bool
+	}{
+		{rc.CollectPerCPU, &collect.CollectPerCPU},
+		{rc.TCPStats, &collect.TCPStats},
+		{rc.CollectTemps, &collect.CollectTemps},
+		{rc.PSI, &collect.PSI},
+	} {
+		if setting.value != nil {
+			*setting.field = *setting.value
+		}
+	}
+}
+
+// restartSettings are the Config fields only read at startup, by the
+// listeners, the main loop, the registry's setup and the sinks other than
+// the Cricket API. Reloads keep their running value.
+var restartSettings = map[string]bool{
+	"IntervalJitter":         true,
+	"StartupJitter":          true,
+	"ShutdownTimeout":        true,
+	"PrometheusAddr":         true,
+	"HealthAddr":             true,
+	"PprofAddr":              true,
+	"CloudMetadata":          true,
+	"Collectors.CgroupAware": true,
+	"Collectors.Systemd":     true,
+	"Collectors.SMART":       true,
+	"Collectors.ZFS":         true,
+	"Collectors.SelfMetrics": true,
+	"Collectors.Enabled":     true,
+	"Collectors.Disabled":    true,
+	"Sender.SpoolDir":        true,
+	"Sender.SpoolMaxBytes":   true,
+	"Sender.SpoolMaxAge":     true,
+	"Sender.HTTPSink":        true,
+	"Sender.InfluxURL":       true,
+	"Sender.InfluxToken":     true,
+	"Sender.InfluxOrg":       true,
+	"Sender.InfluxBucket":    true,
+	"Sender.StatsdAddr":      true,
+	"Sender.StatsdFlavor":    true,
+	"Sender.StatsdPrefix":    true,
+	"Sender.OTLPEndpoint":    true,
+	"Sender.OTLPHeaders":     true,
+	"Sender.FileSink":        true,
+	"Sender.FileMaxBytes":    true,
+	"Sender.FileKeep":        true,
+	"Sender.FileSync":        true,
+}
+
+// clientSettings are the Config fields the Cricket API's HTTP client is
+// built from, which is rebuilt when one of them changes.
+var clientSettings = map[string]bool{
+	"Sender.HTTPTimeout": true,
+	"Sender.TLSCAFile":   true,
+	"Sender.TLSCertFile": true,
+	"Sender.TLSKeyFile":  true,
+	"Sender.TLSInsecure": true,
+	"Sender.ProxyURL":    true,
+	"Sender.NoProxy":     true,
+}
+
+// reload applies the configuration read returns, on SIGHUP. It waits for a
+// running cycle to finish before reading, as reading rewrites the
+// environment, so every cycle sees either the old settings or the new ones.
+// The collectors and the Cricket API read the config on every cycle, so
+// assigning it applies most changes; restartSettings keep their running
+// value, with a warning. Settings the API sent stay as it set them.
+func (a *agent) reload(read func() (Config, error)) {
+	a.cycle.Lock()
+	defer a.cycle.Unlock()
+
+	next, err := read()
+	if err != nil {
+		slog.Warn("Configuration not reloaded, keeping the running one", "error", err)
+		return
+	}
+	// The timeout as configured, before the API's interval caps it
+	collectTimeout := next.CollectTimeout
+	withRemoteConfig(&next, a.remote)
+
+	changed := configChanges("", reflect.ValueOf(a.config), reflect.ValueOf(next))
+	if len(changed) == 0 {
+		slog.Info("Configuration reloaded, nothing changed")
+		return
+	}
+
+	var applied, restart []string
+	rebuildClient := false
+	for _, name := range changed {
+		switch {
+		case restartSettings[name]:
+			restart = append(restart, name)
+			configField(&next, name).Set(configField(&a.config, name))
+		case clientSettings[name]:
+			rebuildClient = true
+			applied = append(applied, name)
+		default:
+			applied = append(applied, name)
+		}
+	}
+	if rebuildClient && a.api != nil {
+		if err := a.api.UpdateClient(next.Sender); err != nil {
+			slog.Warn("Keeping the HTTP client settings, the new ones don't work", "error", err)
+			var kept []string
+			for _, name := range applied {
+				if clientSettings[name] {
+					configField(&next, name).Set(configField(&a.config, name))
+				} else {
+					kept = append(kept, name)
+				}
+			}
+			applied = kept
+		}
+	}
+
+	interval := time.Duration(next.CollectInterval) * time.Second
+	intervalChanged := next.CollectInterval != a.config.CollectInterval
+	a.collectTimeout = collectTimeout
+	// The registry and the API hold pointers into a.config, so they see the
+	// new settings from the next cycle
+	a.config = next
+	if intervalChanged {
+		select {
+		case <-a.reschedule:
+		default:
+		}
+		a.reschedule <- interval
+		a.health.setInterval(interval)
+	}
+
+	if len(applied) > 0 {
+		slog.Info("Configuration reloaded", "changed", strings.Join(applied, ","))
+	}
+	if len(restart) > 0 {
+		slog.Warn("Restart the collector to apply the changed settings", "settings", strings.Join(restart, ","))
+	}
+}
+
+// configChanges lists the fields that differ between two Configs, with the
+// fields of its Collectors and Sender sections as "Collectors.TopProcesses".
+func configChanges(prefix string, old, next reflect.Value) []string {
+	var changed []string
+	for i := 0; i < old.NumField(); i++ {
+		name := prefix + old.Type().Field(i).Name
+		oldField, nextField := old.Field(i), next.Field(i)
+		if prefix == "" && oldField.Kind() == reflect.Struct {
+			changed = append(changed, configChanges(name+".", oldField, nextField)...)
+		} else if !reflect.DeepEqual(oldField.Interface(), nextField.Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// configField returns the field of config named as configChanges does.
+func configField(config *Config, name string) reflect.Value {
+	field := reflect.ValueOf(config).Elem()
+	for _, part := range strings.Split(name, ".") {
+		field = field.FieldByName(part)
+	}
+	return field
+}
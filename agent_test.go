@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cricket-collector/internal/collectors"
+	"cricket-collector/internal/sender"
+)
+
+// blockingSink holds every send until it's released, and keeps the
+// payloads it got.
+type blockingSink struct {
+	started  chan struct{}
+	release  chan struct{}
+	payloads []*collectors.MetricsPayload
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{started: make(chan struct{}, 10), release: make(chan struct{}, 10)}
+}
+
+func (s *blockingSink) Name() string {
+	return "blocking"
+}
+
+func (s *blockingSink) Send(ctx context.Context, payload *collectors.MetricsPayload, flush bool) error {
+	s.started <- struct{}{}
+	<-s.release
+	s.payloads = append(s.payloads, payload)
+	return nil
+}
+
+// newTestAgent sets up an agent that only runs the memory collector, plus
+// the self-metrics, and sends to sink.
+func newTestAgent(t *testing.T, sink sender.Sink) *agent {
+	t.Helper()
+	config := Config{
+		CollectInterval: 60,
+		CollectTimeout:  5 * time.Second,
+		Collectors: collectors.Config{
+			Enabled:     []string{"memory", "self"},
+			SelfMetrics: true,
+			Timeout:     5 * time.Second,
+		},
+	}
+	a, err := newAgent(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sinks = []sender.Sink{sink}
+	return a
+}
+
+func TestCollectAndSendSkipsOverlappingCycles(t *testing.T) {
+	sink := newBlockingSink()
+	a := newTestAgent(t, sink)
+
+	done := make(chan error)
+	go func() {
+		done <- a.collectAndSendMetrics(context.Background())
+	}()
+	<-sink.started
+
+	// The first cycle is stuck sending, so the next tick is skipped
+	// rather than run alongside it
+	if err := a.collectAndSendMetrics(context.Background()); !errors.Is(err, errCycleBusy) {
+		t.Fatalf("second cycle = %v, want errCycleBusy", err)
+	}
+	if skipped := a.skipped.Load(); skipped != 1 {
+		t.Errorf("skipped = %d, want 1", skipped)
+	}
+
+	sink.release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatalf("first cycle = %v", err)
+	}
+
+	// Once it's done the next one runs again
+	sink.release <- struct{}{}
+	if err := a.collectAndSendMetrics(context.Background()); err != nil {
+		t.Fatalf("third cycle = %v", err)
+	}
+	if len(sink.payloads) != 2 {
+		t.Errorf("sink got %d payloads, want 2", len(sink.payloads))
+	}
+}
+
+func TestSkippedCyclesInSelfMetrics(t *testing.T) {
+	sink := newBlockingSink()
+	a := newTestAgent(t, sink)
+
+	done := make(chan error)
+	go func() {
+		done <- a.collectAndSendMetrics(context.Background())
+	}()
+	<-sink.started
+	for i := 0; i < 2; i++ {
+		a.collectAndSendMetrics(context.Background())
+	}
+	sink.release <- struct{}{}
+	<-done
+
+	sink.release <- struct{}{}
+	if err := a.collectAndSendMetrics(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []uint64{0, 2} {
+		if self := sink.payloads[i].Collector; self == nil || self.SkippedCycles != want {
+			t.Errorf("payload %d: self-metrics %+v, want skipped_cycles %d", i, self, want)
+		}
+	}
+}
+
+func TestReloadKeepsRemoteConfig(t *testing.T) {
+	a := newTestAgent(t, newBlockingSink())
+	interval, top, psi := 10, 5, true
+	a.applyRemoteConfig(sender.RemoteConfig{CollectInterval: &interval, TopProcesses: &top, PSI: &psi})
+
+	next := a.config
+	next.CollectInterval = 60
+	next.CollectTimeout = 5 * time.Second
+	next.Collectors.TopProcesses = 0
+	next.Collectors.PSI = false
+	next.Collectors.CollectTemps = true
+	a.reload(func() (Config, error) { return next, nil })
+
+	if a.config.CollectInterval != interval {
+		t.Errorf("collect interval %d, want %d from the API", a.config.CollectInterval, interval)
+	}
+	if a.config.CollectTimeout != 5*time.Second {
+		t.Errorf("collect timeout %s, want 5s capped at half the interval", a.config.CollectTimeout)
+	}
+	if a.config.Collectors.TopProcesses != top || !a.config.Collectors.PSI {
+		t.Errorf("top processes %d, psi %t, want the API's %d and true", a.config.Collectors.TopProcesses, a.config.Collectors.PSI, top)
+	}
+	if !a.config.Collectors.CollectTemps {
+		t.Error("reload didn't apply the setting the API left alone")
+	}
+}
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	a := newTestAgent(t, newBlockingSink())
+	a.reload(func() (Config, error) { return Config{}, errors.New("bad config") })
+	if a.config.CollectInterval != 60 {
+		t.Errorf("collect interval %d after a failed reload, want 60", a.config.CollectInterval)
+	}
+}
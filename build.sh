@@ -6,22 +6,29 @@ set -e
 
 echo "Building Cricket Monitor Performance Collector..."
 
+# Version information embedded in the binaries (shown by --version, sent
+# in the User-Agent and the "version" tag)
+VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+LDFLAGS="-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.date=${DATE}"
+
 # Clean previous builds
 rm -f cricket-collector
 rm -f cricket-collector-*
 
 # Build for current platform
-go build -o cricket-collector main.go
+go build -ldflags "$LDFLAGS" -o cricket-collector .
 
 # Build for common Linux architectures
 echo "Building for Linux amd64..."
-GOOS=linux GOARCH=amd64 go build -o cricket-collector-linux-amd64 main.go
+GOOS=linux GOARCH=amd64 go build -ldflags "$LDFLAGS" -o cricket-collector-linux-amd64 .
 
 echo "Building for Linux arm64..."
-GOOS=linux GOARCH=arm64 go build -o cricket-collector-linux-arm64 main.go
+GOOS=linux GOARCH=arm64 go build -ldflags "$LDFLAGS" -o cricket-collector-linux-arm64 .
 
 echo "Building for Linux 386..."
-GOOS=linux GOARCH=386 go build -o cricket-collector-linux-386 main.go
+GOOS=linux GOARCH=386 go build -ldflags "$LDFLAGS" -o cricket-collector-linux-386 .
 
 echo "Build completed successfully!"
 echo ""
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// options are the parsed command line.
+type options struct {
+	configPath  string
+	version     bool
+	printConfig bool
+	// The CRICKET_* variables set by flags
+	settings map[string]string
+	args     []string
+}
+
+// settingFlags maps the flags that stand in for a setting to its CRICKET_*
+// variable.
+var settingFlags = map[string]string{
+	"api-url":      "CRICKET_API_URL",
+	"api-key-file": "CRICKET_API_KEY_FILE",
+	"interval":     "CRICKET_COLLECT_INTERVAL",
+	"debug":        "CRICKET_DEBUG",
+	"once":         "CRICKET_RUN_ONCE",
+	"dry-run":      "CRICKET_DRY_RUN",
+}
+
+// fromFlags are the variables set from command line flags.
+var fromFlags = map[string]bool{}
+
+// parseFlags parses the command line. Setting flags are kept as the
+// CRICKET_* variable they stand for, which applyFlags exports.
+func parseFlags() options {
+	opts := options{settings: map[string]string{}}
+	flag.StringVar(&opts.configPath, "config", "", "read settings from a YAML or JSON config `file` (CRICKET_CONFIG)")
+	flag.BoolVar(&opts.version, "version", false, "print the version and exit")
+	flag.BoolVar(&opts.version, "v", false, "print the version and exit")
+	flag.BoolVar(&opts.printConfig, "print-config", false, "print the effective settings, and where each came from, then exit")
+	flag.String("api-url", "", "Cricket API `url` (CRICKET_API_URL)")
+	flag.String("api-key-file", "", "read the API key from `file` instead of CRICKET_API_KEY (CRICKET_API_KEY_FILE)")
+	flag.Int("interval", 0, "collection interval in `seconds` (CRICKET_COLLECT_INTERVAL)")
+	flag.Bool("debug", false, "log the collected values (CRICKET_DEBUG)")
+	flag.Bool("once", false, "collect and send once, then exit; exits with 1 if the send failed, 3 if collectors failed (CRICKET_RUN_ONCE)")
+	flag.Bool("dry-run", false, "print the payloads as JSON instead of sending them; needs no API key (CRICKET_DRY_RUN)")
+	flag.Usage = usage
+	flag.Parse()
+	opts.args = flag.Args()
+
+	flag.Visit(func(f *flag.Flag) {
+		if name, ok := settingFlags[f.Name]; ok {
+			opts.settings[name] = f.Value.String()
+		}
+	})
+	return opts
+}
+
+// applyFlags exports the settings given as flags, replacing what the
+// environment had, so they take precedence over the environment and,
+// through it, over the config file.
+func applyFlags(opts options) error {
+	fromFlags = map[string]bool{}
+	for name, value := range opts.settings {
+		os.Setenv(name, value)
+		fromFlags[name] = true
+	}
+	return nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `Usage: cricket-collector [flags]
+       cricket-collector replay <file>
+
+Collects system metrics and sends them to Cricket Monitor every interval.
+replay sends a file written by CRICKET_FILE_SINK to the API, then exits.
+
+All settings can be given as CRICKET_* environment variables (or in .env)
+and in a config file, see the README. Flags take precedence over the
+environment, which takes precedence over the config file.
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func printVersion() {
+	fmt.Printf("Cricket Monitor Collector\n")
+	fmt.Printf("Version: %s\n", version)
+	fmt.Printf("Commit: %s\n", commit)
+	fmt.Printf("Built: %s\n", date)
+	fmt.Printf("Go Version: %s\n", runtime.Version())
+	fmt.Printf("OS/Arch: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthStaleIntervals is how many collection intervals may pass without a
+// collection before /healthz reports the collector as unhealthy.
+const healthStaleIntervals = 3
+
+// health tracks when the agent last collected and sent metrics, for the
+// CRICKET_HEALTH_ADDR liveness endpoint. Cycles update it while the endpoint
+// reads it, hence the mutex.
+type health struct {
+	mu          sync.Mutex
+	started     time.Time
+	interval    time.Duration
+	lastCollect time.Time
+	lastSend    time.Time
+	lastSendErr string
+}
+
+func newHealth(interval time.Duration) *health {
+	return &health{started: time.Now(), interval: interval}
+}
+
+func (h *health) collected() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastCollect = time.Now()
+}
+
+// sent records the outcome of handing a payload to the sinks. The last
+// successful send is kept along with the error of the latest failed one.
+func (h *health) sent(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastSendErr = err.Error()
+		return
+	}
+	h.lastSend = time.Now()
+	h.lastSendErr = ""
+}
+
+// setInterval follows interval changes from the API, so the staleness limit
+// stays a multiple of the current interval.
+func (h *health) setInterval(interval time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.interval = interval
+}
+
+// healthStatus is the JSON body of /healthz.
+type healthStatus struct {
+	Status          string     `json:"status"`
+	LastCollect     *time.Time `json:"last_collect,omitempty"`
+	LastSend        *time.Time `json:"last_send,omitempty"`
+	LastSendError   string     `json:"last_send_error,omitempty"`
+	IntervalSeconds float64    `json:"interval_seconds"`
+	UptimeSeconds   float64    `json:"uptime_seconds"`
+}
+
+// ServeHTTP answers 200 while collections keep happening, and 503 once the
+// last one (or startup, before the first) is more than healthStaleIntervals
+// intervals ago. Failed sends don't make the collector unhealthy: restarting
+// it won't bring the API back, and the body reports them.
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	status := healthStatus{
+		Status:          "ok",
+		LastSendError:   h.lastSendErr,
+		IntervalSeconds: h.interval.Seconds(),
+		UptimeSeconds:   time.Since(h.started).Seconds(),
+	}
+	since := h.started
+	if !h.lastCollect.IsZero() {
+		lastCollect := h.lastCollect
+		status.LastCollect = &lastCollect
+		since = lastCollect
+	}
+	if !h.lastSend.IsZero() {
+		lastSend := h.lastSend
+		status.LastSend = &lastSend
+	}
+	stale := time.Since(since) > healthStaleIntervals*h.interval
+	h.mu.Unlock()
+
+	code := http.StatusOK
+	if stale {
+		status.Status = "stale"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// Serve starts the /healthz endpoint on addr in the background.
+func (h *health) Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", h)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Warn("Health endpoint stopped", "error", err)
+		}
+	}()
+	return server, nil
+}
@@ -101,7 +101,7 @@ download_binary() {
         log_info "2. Manual build from source:"
         log_info "   git clone https://github.com/CricketMonitor/collector.git"
         log_info "   cd collector/collectors/linux-collector"
-        log_info "   go mod tidy && go build -o cricket-collector main.go"
+        log_info "   go mod tidy && go build -o cricket-collector ."
         exit 1
     fi
     
@@ -211,6 +211,7 @@ Restart=always
 RestartSec=5
 User=$USER_NAME
 ExecStart=$INSTALL_DIR/cricket-collector
+ExecReload=/bin/kill -HUP \$MAINPID
 WorkingDirectory=$INSTALL_DIR
 EnvironmentFile=$INSTALL_DIR/.env
 
@@ -0,0 +1,139 @@
+package collectors
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math"
+	stdnet "net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tlsCheckTimeout bounds the connection and handshake of each TLS check.
+const tlsCheckTimeout = 10 * time.Second
+
+// certificatesCollector reports the certificates in CRICKET_TLS_CHECKS.
+type certificatesCollector struct {
+	config *Config
+}
+
+func (c certificatesCollector) Name() string {
+	return "certificates"
+}
+
+func (c certificatesCollector) timeout(base time.Duration) time.Duration {
+	return max(base, tlsCheckTimeout+timeoutMargin)
+}
+
+func (c certificatesCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	payload.Certificates = checkCertificates(ctx, c.config.TLSChecks)
+	return nil
+}
+
+// checkCertificates reports the certificate of every CRICKET_TLS_CHECKS
+// entry, in parallel. An entry is a PEM file, or host[:port][#servername] to
+// connect to (port 443 by default, SNI is the host unless given).
+func checkCertificates(ctx context.Context, targets []string) []CertificateInfo {
+	results := make([]CertificateInfo, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			var cert *x509.Certificate
+			var verifyErr, err error
+			if _, statErr := os.Stat(target); statErr == nil {
+				cert, err = readCertificateFile(target)
+			} else {
+				cert, verifyErr, err = fetchCertificate(ctx, target)
+			}
+			results[i] = certificateInfo(target, cert, verifyErr, err, time.Now())
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+func certificateInfo(target string, cert *x509.Certificate, verifyErr, err error, now time.Time) CertificateInfo {
+	info := CertificateInfo{Target: target}
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Subject = cert.Subject.CommonName
+	if info.Subject == "" {
+		info.Subject = cert.Subject.String()
+	}
+	info.Issuer = cert.Issuer.CommonName
+	if info.Issuer == "" {
+		info.Issuer = cert.Issuer.String()
+	}
+	info.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+	// Whole days left, negative once expired
+	days := int(math.Floor(cert.NotAfter.Sub(now).Hours() / 24))
+	info.DaysUntilExpiry = &days
+	if verifyErr != nil {
+		info.VerifyError = verifyErr.Error()
+	}
+	return info
+}
+
+// fetchCertificate connects to host[:port][#servername] and returns the leaf
+// certificate it presents, and why it doesn't verify, if it doesn't.
+func fetchCertificate(ctx context.Context, target string) (cert *x509.Certificate, verifyErr, err error) {
+	address, serverName, _ := strings.Cut(target, "#")
+	if _, _, err := stdnet.SplitHostPort(address); err != nil {
+		address = stdnet.JoinHostPort(address, "443")
+	}
+	if serverName == "" {
+		serverName, _, _ = stdnet.SplitHostPort(address)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tlsCheckTimeout)
+	defer cancel()
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName: serverName,
+		// Verified below instead, so a bad certificate's expiry is still reported
+		InsecureSkipVerify: true,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, nil, errors.New("no certificate presented")
+	}
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range certs[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	_, verifyErr = certs[0].Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates})
+	return certs[0], verifyErr, nil
+}
+
+// readCertificateFile returns the first certificate in a PEM file, which for
+// a chain is the leaf.
+func readCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// inContainer guesses whether the collector runs in a container: Docker and
+// Podman leave marker files, Kubernetes sets KUBERNETES_SERVICE_HOST.
+func inContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// cgroupLimits is the memory and CPU limit of the collector's cgroup, nil
+// where there is none.
+type cgroupLimits struct {
+	memoryLimit *uint64
+	memoryUsage *uint64
+	cpuQuota    *float64
+}
+
+// readCgroupLimits reads the limits of the collector's own cgroup, v2
+// (memory.max, cpu.max) or v1 (memory.limit_in_bytes, cpu.cfs_quota_us).
+// Memory usage is the working set, i.e. without inactive page cache, which
+// is what the kernel reclaims before the OOM killer gets involved.
+func readCgroupLimits() cgroupLimits {
+	var limits cgroupLimits
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		dir := cgroupDir("", "")
+		if limit, ok := readCgroupUint(filepath.Join(dir, "memory.max")); ok {
+			limits.memoryLimit = &limit
+		}
+		if usage, ok := readCgroupUint(filepath.Join(dir, "memory.current")); ok {
+			usage -= min(usage, cgroupStat(filepath.Join(dir, "memory.stat"), "inactive_file"))
+			limits.memoryUsage = &usage
+		}
+		// cpu.max is "<quota> <period>", with "max" for no quota
+		if data, err := os.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+			if fields := strings.Fields(string(data)); len(fields) == 2 {
+				limits.cpuQuota = cpuQuota(fields[0], fields[1])
+			}
+		}
+		return limits
+	}
+
+	memoryDir := cgroupDir("memory", "memory")
+	if limit, ok := readCgroupUint(filepath.Join(memoryDir, "memory.limit_in_bytes")); ok {
+		// "No limit" is a huge page-aligned number rather than a keyword
+		if limit < 1<<62 {
+			limits.memoryLimit = &limit
+		}
+	}
+	if usage, ok := readCgroupUint(filepath.Join(memoryDir, "memory.usage_in_bytes")); ok {
+		usage -= min(usage, cgroupStat(filepath.Join(memoryDir, "memory.stat"), "total_inactive_file"))
+		limits.memoryUsage = &usage
+	}
+	cpuDir := cgroupDir("cpu", "cpu")
+	quota, _ := os.ReadFile(filepath.Join(cpuDir, "cpu.cfs_quota_us"))
+	period, _ := os.ReadFile(filepath.Join(cpuDir, "cpu.cfs_period_us"))
+	limits.cpuQuota = cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	return limits
+}
+
+// cgroupDir returns the directory of the collector's cgroup for a v1
+// controller (mounted at subdir), or for v2 when controller is "". With a
+// cgroup namespace, as in most containers, that is the mount itself.
+func cgroupDir(controller, subdir string) string {
+	root := filepath.Join(cgroupRoot, subdir)
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return root
+	}
+	// Lines are "<id>:<controllers>:<path>", v2 has id 0 and no controllers
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		matches := parts[1] == controller
+		for _, name := range strings.Split(parts[1], ",") {
+			matches = matches || (controller != "" && name == controller)
+		}
+		if !matches {
+			continue
+		}
+		if dir := filepath.Join(root, parts[2]); parts[2] != "/" {
+			if _, err := os.Stat(dir); err == nil {
+				return dir
+			}
+		}
+		break
+	}
+	return root
+}
+
+// readCgroupUint reads a cgroup file holding a single number; "max" (no
+// limit) and unreadable files report false.
+func readCgroupUint(path string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return value, err == nil
+}
+
+// cgroupStat returns one "<key> <value>" entry of a memory.stat file.
+func cgroupStat(path, key string) uint64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, value, ok := strings.Cut(line, " "); ok && name == key {
+			n, _ := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// cpuQuota turns a CFS quota and period (in microseconds) into a number of
+// CPUs, nil when there's no quota ("max" or -1).
+func cpuQuota(quota, period string) *float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return nil
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return nil
+	}
+	cpus := q / p
+	return &cpus
+}
+
+// applyCgroupLimits reports memory against the container's limit instead of
+// the host's memory, and the CPU quota as the CPUs the container may use.
+func applyCgroupLimits(payload *MetricsPayload, limits cgroupLimits) {
+	payload.CgroupMemoryLimitBytes = limits.memoryLimit
+	payload.CgroupCPUQuota = limits.cpuQuota
+	if limits.memoryLimit == nil || limits.memoryUsage == nil || *limits.memoryLimit == 0 {
+		return
+	}
+	limit, used := *limits.memoryLimit, *limits.memoryUsage
+	if total := payload.MemoryTotalBytes; total != nil && limit > *total && *total > 0 {
+		// A limit above the host's memory doesn't constrain anything
+		limit = *total
+	}
+	available := limit - min(used, limit)
+	payload.MemoryTotalBytes = &limit
+	payload.MemoryUsedBytes = &used
+	payload.MemoryAvailableBytes = &available
+	usage := float64(used) / float64(limit) * 100
+	payload.MemoryUsagePercent = &usage
+}
@@ -0,0 +1,174 @@
+package collectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	stdnet "net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// cloudMetadataAddr is where all three providers serve instance metadata.
+	cloudMetadataAddr = "169.254.169.254:80"
+	// cloudMetadataDialTimeout bounds connecting to the metadata address. It
+	// is link-local, so on a cloud instance it answers at once; on bare metal
+	// the connection fails or hangs, and giving up quickly keeps that from
+	// delaying startup.
+	cloudMetadataDialTimeout = 500 * time.Millisecond
+	// cloudMetadataTimeout bounds each request to a metadata endpoint.
+	cloudMetadataTimeout = 2 * time.Second
+)
+
+// cloudMetadataProviders are tried in this order by CRICKET_CLOUD_METADATA=auto.
+var cloudMetadataProviders = []struct {
+	name  string
+	probe func(client *http.Client) (map[string]string, error)
+}{
+	{"aws", probeAWSMetadata},
+	{"gcp", probeGCPMetadata},
+	{"azure", probeAzureMetadata},
+}
+
+// ProbeCloudMetadata looks up the instance id, instance type, region and
+// availability zone from the cloud provider's metadata service, for mode
+// "aws", "gcp", "azure" or "auto" (try each). It is best effort: no metadata
+// simply means no tags, with a warning only when a specific provider was
+// asked for. When nothing listens on the metadata address, none of the
+// providers is asked.
+func ProbeCloudMetadata(mode string) map[string]string {
+	if mode != "auto" && mode != "aws" && mode != "gcp" && mode != "azure" {
+		slog.Warn("Unknown CRICKET_CLOUD_METADATA value, expected auto, aws, gcp, azure or off", "value", mode)
+		return nil
+	}
+
+	dialer := &stdnet.Dialer{Timeout: cloudMetadataDialTimeout}
+	conn, err := dialer.Dial("tcp", cloudMetadataAddr)
+	if err != nil {
+		if mode != "auto" {
+			slog.Warn("Failed to read instance metadata", "cloud", mode, "error", err)
+		}
+		return nil
+	}
+	conn.Close()
+
+	// Metadata is only reachable directly, never through a proxy
+	client := &http.Client{
+		Timeout:   cloudMetadataTimeout,
+		Transport: &http.Transport{Proxy: nil, DialContext: dialer.DialContext},
+	}
+
+	for _, provider := range cloudMetadataProviders {
+		if mode != "auto" && mode != provider.name {
+			continue
+		}
+		tags, err := provider.probe(client)
+		if err != nil {
+			if mode != "auto" {
+				slog.Warn("Failed to read instance metadata", "cloud", provider.name, "error", err)
+			}
+			continue
+		}
+		// Not every instance has a zone, for one
+		for key, value := range tags {
+			if value == "" {
+				delete(tags, key)
+			}
+		}
+		tags["cloud"] = provider.name
+		return tags
+	}
+	return nil
+}
+
+// fetchMetadata requests a document from a metadata service.
+func fetchMetadata(client *http.Client, method, url string, header map[string]string) ([]byte, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+func probeAWSMetadata(client *http.Client) (map[string]string, error) {
+	// IMDSv2 needs a session token first
+	token, err := fetchMetadata(client, http.MethodPut, "http://169.254.169.254/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		InstanceID       string `json:"instanceId"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		InstanceType     string `json:"instanceType"`
+	}
+	body, err := fetchMetadata(client, http.MethodGet, "http://169.254.169.254/latest/dynamic/instance-identity/document",
+		map[string]string{"X-aws-ec2-metadata-token": string(token)})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	return map[string]string{"instance_id": doc.InstanceID, "region": doc.Region, "availability_zone": doc.AvailabilityZone, "instance_type": doc.InstanceType}, nil
+}
+
+func probeGCPMetadata(client *http.Client) (map[string]string, error) {
+	var doc struct {
+		ID          json.Number `json:"id"`
+		Zone        string      `json:"zone"`        // projects/<n>/zones/us-central1-a
+		MachineType string      `json:"machineType"` // projects/<n>/machineTypes/e2-medium
+	}
+	body, err := fetchMetadata(client, http.MethodGet, "http://169.254.169.254/computeMetadata/v1/instance/?recursive=true",
+		map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	zone := path.Base(doc.Zone)
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	return map[string]string{"instance_id": doc.ID.String(), "region": region, "availability_zone": zone, "instance_type": path.Base(doc.MachineType)}, nil
+}
+
+func probeAzureMetadata(client *http.Client) (map[string]string, error) {
+	var doc struct {
+		VMID     string `json:"vmId"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"` // "1", "2" or "3", empty outside zones
+		VMSize   string `json:"vmSize"`
+	}
+	body, err := fetchMetadata(client, http.MethodGet, "http://169.254.169.254/metadata/instance/compute?api-version=2021-02-01",
+		map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	zone := ""
+	if doc.Zone != "" {
+		zone = doc.Location + "-" + doc.Zone
+	}
+	return map[string]string{"instance_id": doc.VMID, "region": doc.Location, "availability_zone": zone, "instance_type": doc.VMSize}, nil
+}
@@ -0,0 +1,527 @@
+// Package collectors gathers the host's metrics into a MetricsPayload. Each
+// area (CPU, memory, disks, ...) is a Collector, and a Registry runs the
+// enabled ones every cycle.
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Collector gathers one area of metrics into the payload. Collectors run
+// concurrently, so each one writes only its own fields. One that fails
+// returns an error, after filling in whatever it could.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context, payload *MetricsPayload) error
+}
+
+// resetter is implemented by collectors that keep samples from earlier
+// cycles, which are meaningless once the host rebooted.
+type resetter interface {
+	Reset()
+}
+
+// timeouter is implemented by collectors that need longer than the default
+// timeout: ones waiting out a sample window, and ones bounding their work
+// with a timeout of their own, which should fire first so they can report
+// what they have.
+type timeouter interface {
+	timeout(base time.Duration) time.Duration
+}
+
+// timeoutMargin is how much longer than their own timeout collectors get.
+const timeoutMargin = time.Second
+
+// counterResetter is implemented by collectors reporting deltas of
+// cumulative counters, to say whether one was reset in the last interval.
+type counterResetter interface {
+	CounterReset() bool
+}
+
+// Config holds the settings for what is collected and how.
+type Config struct {
+	ServerName      string
+	HostnameSource  string
+	IPAddress       string
+	IPDetectTarget  string
+	CollectPerCPU   bool
+	CPUSampleWindow time.Duration
+	RawCounters     bool
+	RootDiskPath    string
+	NetInterfaces   GlobFilter
+	NetPerInterface bool
+	FSTypes         FSTypeFilter
+	SkipReadOnly    bool
+	Mounts          GlobFilter
+	SelfMetrics     bool
+	ProcessCounts   bool
+	TopProcesses    int
+	TCPStats        bool
+	CollectTemps    bool
+	Systemd         bool
+	SMART           bool
+	ZFS             string
+	ProbeTargets    []string
+	ProbeTimeout    time.Duration
+	CgroupAware     string
+	HTTPChecks      []HTTPCheck
+	TLSChecks       []string
+	SystemdUnits    []string
+	TempSensors     GlobFilter
+	PSI             bool
+	WatchProcesses  []ProcessWatch
+	Enabled         []string
+	Disabled        []string
+	Timeout         time.Duration
+	Tags            map[string]string
+	Version         string
+	UserAgent       string
+	Debug           bool
+}
+
+// Registry holds the collectors and runs them once per cycle. The config is
+// read on every cycle, so settings changed in it (by the API) apply from the
+// next one.
+type Registry struct {
+	config     *Config
+	host       *registration
+	collectors []*registration
+	prevAt     time.Time
+	// Shared by the disk and diskio collectors
+	diskIO diskIOCounters
+	// Held while merging a collector's fields into the payload
+	merging sync.Mutex
+}
+
+// registration is a collector along with whether it currently runs.
+type registration struct {
+	collector Collector
+	enabled   func() bool
+	// Held while the collector runs, which may outlast the cycle that
+	// started it when it timed out
+	busy sync.Mutex
+}
+
+// New sets up the collectors for config. What can't change while running,
+// such as whether systemd or smartctl are there, is checked once here.
+func New(config *Config) (*Registry, error) {
+	always := func() bool { return true }
+
+	cgroupAware := config.CgroupAware == "true" || (config.CgroupAware == "auto" && inContainer())
+	if cgroupAware {
+		slog.Debug("Running in a container, memory is reported against the cgroup limit")
+	}
+	// Checked once: without systemd (containers, other init systems) the
+	// collector just stays off
+	var systemd bool
+	if config.Systemd {
+		systemd = systemdRunning()
+		if !systemd {
+			slog.Debug("systemd is not running, skipping systemd unit metrics")
+		}
+	}
+	// ZFS is on by default wherever the zpool tool is installed
+	var zfs bool
+	if config.ZFS != "false" {
+		_, err := exec.LookPath("zpool")
+		zfs = err == nil
+		if err != nil && config.ZFS == "true" {
+			slog.Warn("CRICKET_ZFS is set but zpool was not found, ZFS pools will not be reported")
+		}
+	}
+	var smart bool
+	if config.SMART {
+		if _, err := exec.LookPath("smartctl"); err != nil {
+			slog.Warn("CRICKET_SMART is set but smartctl was not found, disk health will not be reported")
+		} else {
+			smart = true
+		}
+	}
+	var self *process.Process
+	if config.SelfMetrics {
+		var err error
+		self, err = process.NewProcess(int32(os.Getpid()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect own process: %w", err)
+		}
+		// The first Percent call only records a baseline
+		self.Percent(0)
+	}
+
+	smartCheck := &smartCollector{config: config}
+	smartCheck.enabled.Store(smart)
+	r := &Registry{
+		config: config,
+		host:   &registration{collector: &hostCollector{config: config}, enabled: always},
+	}
+	r.collectors = []*registration{
+		{collector: &cpuCollector{config: config}, enabled: always},
+		{collector: &loadCollector{cgroupAware: cgroupAware}, enabled: always},
+		{collector: &memoryCollector{cgroupAware: cgroupAware}, enabled: always},
+		{collector: &swapCollector{}, enabled: always},
+		{collector: &processesCollector{config: config}, enabled: always},
+		{collector: &diskCollector{config: config, io: &r.diskIO}, enabled: always},
+		{collector: &diskIOCollector{config: config, io: &r.diskIO}, enabled: always},
+		{collector: &networkCollector{config: config}, enabled: always},
+		{collector: fileDescriptorsCollector{}, enabled: always},
+		{collector: mdraidCollector{}, enabled: always},
+		{collector: probesCollector{config: config}, enabled: func() bool { return len(config.ProbeTargets) > 0 }},
+		{collector: httpChecksCollector{config: config}, enabled: func() bool { return len(config.HTTPChecks) > 0 }},
+		{collector: certificatesCollector{config: config}, enabled: func() bool { return len(config.TLSChecks) > 0 }},
+		{collector: zfsCollector{}, enabled: func() bool { return zfs }},
+		{collector: smartCheck, enabled: func() bool { return smartCheck.enabled.Load() }},
+		{collector: systemdCollector{config: config}, enabled: func() bool { return systemd }},
+		{collector: pressureCollector{}, enabled: func() bool { return config.PSI }},
+		{collector: tcpCollector{config: config}, enabled: func() bool { return config.TCPStats }},
+		{collector: temperaturesCollector{config: config}, enabled: func() bool { return config.CollectTemps }},
+		{collector: selfCollector{self: self}, enabled: func() bool { return self != nil }},
+	}
+	if err := r.applySwitches(config.Enabled, config.Disabled); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// applySwitches limits the collectors to the enabled ones, when any are
+// listed, and turns off the disabled ones. Collectors that need a setting of
+// their own to run (tcp, smart, ...) still need it when listed as enabled.
+func (r *Registry) applySwitches(enabled, disabled []string) error {
+	known := map[string]bool{}
+	for _, reg := range r.collectors {
+		known[reg.collector.Name()] = true
+	}
+	check := func(names []string) (map[string]bool, error) {
+		set := map[string]bool{}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if name == r.host.collector.Name() {
+				// It identifies the server, so it always runs
+				continue
+			}
+			if !known[name] {
+				return nil, fmt.Errorf("unknown collector %q, expected one of: %s", name, strings.Join(r.names(), ", "))
+			}
+			set[name] = true
+		}
+		return set, nil
+	}
+	only, err := check(enabled)
+	if err != nil {
+		return err
+	}
+	off, err := check(disabled)
+	if err != nil {
+		return err
+	}
+	if len(enabled) == 0 && len(off) == 0 {
+		return nil
+	}
+	for i, reg := range r.collectors {
+		name := reg.collector.Name()
+		if off[name] || (len(enabled) > 0 && !only[name]) {
+			r.collectors[i].enabled = func() bool { return false }
+		}
+	}
+	return nil
+}
+
+// names returns the names of every collector but the host one.
+func (r *Registry) names() []string {
+	names := make([]string, len(r.collectors))
+	for i, reg := range r.collectors {
+		names[i] = reg.collector.Name()
+	}
+	return names
+}
+
+// Active returns the names of the collectors that currently run, the host
+// one first.
+func (r *Registry) Active() []string {
+	active := []string{r.host.collector.Name()}
+	for _, reg := range r.collectors {
+		if reg.enabled() {
+			active = append(active, reg.collector.Name())
+		}
+	}
+	return active
+}
+
+// Collect gathers one payload. Each collector is bounded by its own timeout
+// as well as ctx, and one that doesn't finish before its deadline is left
+// out so a hung mount can't hold up the rest of the metrics. A collector
+// failing only loses its own fields.
+func (r *Registry) Collect(ctx context.Context) *MetricsPayload {
+	config := r.config
+
+	payload := &MetricsPayload{
+		// Server information for auto-registration
+		ServerName:   config.ServerName,
+		Hostname:     shortHostname(config.HostnameSource),
+		Architecture: runtime.GOARCH,
+		Tags: map[string]string{
+			"collector": "cricket-go-collector",
+			"version":   config.Version,
+		},
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	// The configured tags can override anything
+	for key, value := range config.Tags {
+		payload.Tags[key] = value
+	}
+
+	// The host collector goes first: when it finds the host rebooted, every
+	// collector's samples from before the reboot are dropped before use
+	var failed []string
+	if _, ok := r.run(ctx, r.host, payload); !ok {
+		failed = append(failed, r.host.collector.Name())
+	}
+	if payload.Rebooted {
+		r.reset()
+	}
+	now := time.Now()
+	r.diskIO.reset()
+
+	// The rest are independent, so they run concurrently and the CPU sample
+	// window and a slow disk walk overlap instead of adding up
+	var ran []*registration
+	for _, reg := range r.collectors {
+		if reg.enabled() {
+			ran = append(ran, reg)
+		}
+	}
+	finished := make([]bool, len(ran))
+	succeeded := make([]bool, len(ran))
+	var wg sync.WaitGroup
+	for i, reg := range ran {
+		wg.Add(1)
+		go func(i int, reg *registration) {
+			defer wg.Done()
+			finished[i], succeeded[i] = r.run(ctx, reg, payload)
+		}(i, reg)
+	}
+	wg.Wait()
+
+	for i, reg := range ran {
+		if !succeeded[i] {
+			failed = append(failed, reg.collector.Name())
+		}
+		// One still running in the background is left alone
+		if !finished[i] {
+			continue
+		}
+		if counters, ok := reg.collector.(counterResetter); ok && counters.CounterReset() {
+			payload.CounterReset = true
+		}
+	}
+	// Lets the backend tell a metric that is 0 from one that is unknown
+	payload.CollectionErrors = failed
+	if !r.prevAt.IsZero() {
+		elapsed := now.Sub(r.prevAt).Seconds()
+		payload.IntervalSeconds = &elapsed
+	}
+	r.prevAt = now
+
+	return payload
+}
+
+// run calls one collector under CRICKET_COLLECTOR_TIMEOUT, logging its
+// failure under its name. The collector fills in a payload of its own, which
+// is merged into payload once it returns, so one that times out is left to
+// finish in the background without touching the payload being sent. Until
+// it does, later cycles skip it. run reports whether the collector returned
+// and whether it succeeded.
+func (r *Registry) run(ctx context.Context, reg *registration, payload *MetricsPayload) (finished, ok bool) {
+	name := reg.collector.Name()
+	if !reg.busy.TryLock() {
+		slog.Warn("Collector is still running from an earlier cycle, skipping it", "collector", name)
+		return false, false
+	}
+
+	timeout := r.config.Timeout
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		if t, ok := reg.collector.(timeouter); ok {
+			timeout = t.timeout(timeout)
+		}
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	partial := &MetricsPayload{}
+	done := make(chan error, 1)
+	go func() {
+		defer reg.busy.Unlock()
+		defer cancel()
+		done <- collect(ctx, reg.collector, partial)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			slog.Warn("Collector failed", "collector", name, "error", err)
+		}
+		// A collector that failed still keeps whatever it could fill in
+		r.merging.Lock()
+		merge(payload, partial)
+		r.merging.Unlock()
+		return true, err == nil
+	case <-ctx.Done():
+		if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			slog.Warn("Collector did not finish in time, leaving its metrics out", "collector", name, "timeout", timeout)
+		} else {
+			slog.Warn("Collector did not finish before the collection deadline, leaving its metrics out", "collector", name)
+		}
+		return false, false
+	}
+}
+
+// collect calls the collector, turning a panic into an error so it only
+// loses that collector's metrics.
+func collect(ctx context.Context, collector Collector, payload *MetricsPayload) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	return collector.Collect(ctx, payload)
+}
+
+// merge copies the fields a collector filled in into payload. Collectors
+// each fill in their own fields, so none overwrites another's.
+func merge(payload, partial *MetricsPayload) {
+	dst, src := reflect.ValueOf(payload).Elem(), reflect.ValueOf(partial).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if field := src.Field(i); !field.IsZero() {
+			dst.Field(i).Set(field)
+		}
+	}
+}
+
+// reset drops the state every collector carries between cycles. A
+// collector still running from an earlier cycle keeps its state.
+func (r *Registry) reset() {
+	r.prevAt = time.Time{}
+	for _, reg := range r.collectors {
+		collector, ok := reg.collector.(resetter)
+		if !ok || !reg.busy.TryLock() {
+			continue
+		}
+		collector.Reset()
+		reg.busy.Unlock()
+	}
+}
+
+// counterHistory is embedded by collectors reporting per-interval deltas of
+// cumulative counters. It tracks when their previous sample was taken.
+type counterHistory struct {
+	at       time.Time
+	counters intervalCounters
+}
+
+// next starts a new interval and returns the counters to compute its deltas
+// and rates with.
+func (h *counterHistory) next() *intervalCounters {
+	now := time.Now()
+	h.counters = intervalCounters{}
+	if !h.at.IsZero() {
+		h.counters.elapsed = now.Sub(h.at).Seconds()
+	}
+	h.at = now
+	return &h.counters
+}
+
+func (h *counterHistory) CounterReset() bool {
+	return h.counters.reset
+}
+
+// intervalCounters computes the per-interval deltas and rates of cumulative
+// counters for one metric group, noting whether any of them was reset.
+type intervalCounters struct {
+	elapsed float64
+	reset   bool
+}
+
+func (ic *intervalCounters) delta(prevValue, currentValue uint64) *uint64 {
+	d, reset := counterDelta(prevValue, currentValue)
+	if reset {
+		ic.reset = true
+	}
+	return &d
+}
+
+// perSec returns the rate of a counter, 0 for a sample where it was reset.
+func (ic *intervalCounters) perSec(prevValue, currentValue uint64) *float64 {
+	rate := 0.0
+	if d, reset := counterDelta(prevValue, currentValue); !reset && ic.elapsed > 0 {
+		rate = float64(d) / ic.elapsed
+	}
+	return &rate
+}
+
+// counterDelta returns the increase of a cumulative counter. A counter that
+// went backwards was reset (reboot, driver reload or wraparound), in which case
+// the current value is the best estimate of the increase and reset is true.
+func counterDelta(prev, current uint64) (delta uint64, reset bool) {
+	if current < prev {
+		return current, true
+	}
+	return current - prev, false
+}
+
+// collectCall runs one gopsutil call in a goroutine and waits for it until ctx
+// is done. Calls blocked in the kernel (statfs on a hung NFS mount) can't be
+// interrupted, so on timeout the goroutine is abandoned and left to finish in
+// the background while collection moves on.
+func collectCall[T any](ctx context.Context, name string, fn func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn(ctx)
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		slog.Warn("Call did not return before the collection deadline, skipping it", "call", name)
+		return zero, ctx.Err()
+	}
+}
+
+// notImplemented reports whether err is gopsutil saying a call isn't
+// supported on this platform or build (macOS binaries built without cgo have
+// no CPU times or disk I/O counters). Those metrics are left out without
+// counting the collector as failed every cycle. The sentinel error lives in
+// an internal gopsutil package, so it is matched by its message.
+func notImplemented(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "not implemented yet")
+}
+
+// procPath returns the root of the proc filesystem, honouring HOST_PROC the
+// same way gopsutil does when the collector runs in a container.
+func procPath() string {
+	if value := os.Getenv("HOST_PROC"); value != "" {
+		return value
+	}
+	return "/proc"
+}
@@ -0,0 +1,63 @@
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubCollector fills in the payload with fill, or with hang set, blocks
+// until its context is done, like a disk walk stuck on a dead NFS mount.
+type stubCollector struct {
+	name string
+	hang bool
+	fill func(payload *MetricsPayload)
+}
+
+func (c stubCollector) Name() string {
+	return c.name
+}
+
+func (c stubCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	if c.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	c.fill(payload)
+	return nil
+}
+
+func TestCollectLeavesOutSlowCollector(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	always := func() bool { return true }
+	r := &Registry{
+		config: &Config{ServerName: "web-1", Timeout: timeout},
+		host: &registration{collector: stubCollector{name: "host", fill: func(p *MetricsPayload) {
+			p.OperatingSystem = "linux"
+		}}, enabled: always},
+		collectors: []*registration{
+			{collector: stubCollector{name: "disk", hang: true}, enabled: always},
+			{collector: stubCollector{name: "memory", fill: func(p *MetricsPayload) {
+				total := uint64(8 << 30)
+				p.MemoryTotalBytes = &total
+			}}, enabled: always},
+		},
+	}
+
+	start := time.Now()
+	payload := r.Collect(context.Background())
+	if elapsed := time.Since(start); elapsed > timeout+time.Second {
+		t.Errorf("Collect() took %s, want it back soon after the %s timeout", elapsed, timeout)
+	}
+
+	if payload.ServerName != "web-1" || payload.OperatingSystem != "linux" {
+		t.Errorf("server %q, os %q, want the host collector's fields", payload.ServerName, payload.OperatingSystem)
+	}
+	if payload.MemoryTotalBytes == nil || *payload.MemoryTotalBytes != 8<<30 {
+		t.Errorf("memory total %v, want the memory collector's fields", payload.MemoryTotalBytes)
+	}
+	if want := []string{"disk"}; !reflect.DeepEqual(payload.CollectionErrors, want) {
+		t.Errorf("collection errors %v, want %v", payload.CollectionErrors, want)
+	}
+}
@@ -0,0 +1,228 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/load"
+)
+
+// initialCPUSample is how long the first collection blocks to measure CPU
+// usage, since there is no previous cpu.Times sample to diff against yet.
+const initialCPUSample = 200 * time.Millisecond
+
+// cpuCollector gathers CPU information and usage, overall and per core.
+type cpuCollector struct {
+	config          *Config
+	prevCPUTimes    *cpu.TimesStat
+	prevPerCPUTimes []cpu.TimesStat
+}
+
+func (c *cpuCollector) Name() string {
+	return "cpu"
+}
+
+func (c *cpuCollector) timeout(base time.Duration) time.Duration {
+	return base + max(c.config.CPUSampleWindow, initialCPUSample)
+}
+
+func (c *cpuCollector) Reset() {
+	c.prevCPUTimes = nil
+	c.prevPerCPUTimes = nil
+}
+
+func (c *cpuCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	config := c.config
+	var errs []error
+	// CPU information
+	cpuInfo, err := collectCall(ctx, "cpu info", cpu.InfoWithContext)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to read CPU info: %w", err))
+	} else if len(cpuInfo) > 0 {
+		payload.CPUModel = cpuInfo[0].ModelName
+		payload.CPUCores = cpuInfo[0].Cores
+		payload.CPUThreads = int32(len(cpuInfo)) // Total logical CPUs
+	}
+
+	// CPU metrics, computed from cpu.Times deltas. A baseline is taken
+	// CRICKET_CPU_SAMPLE_DURATION (1s by default) before the current sample;
+	// with it set to 0 the baseline is the previous cycle's sample so
+	// collection doesn't block, except on the first run.
+	prevCPUTimes, prevPerCPUTimes := c.prevCPUTimes, c.prevPerCPUTimes
+	sampleWindow := config.CPUSampleWindow
+	if sampleWindow == 0 && prevCPUTimes == nil {
+		sampleWindow = initialCPUSample
+	}
+	if sampleWindow > 0 {
+		baseline, err := collectCall(ctx, "cpu times baseline", func(ctx context.Context) ([]cpu.TimesStat, error) {
+			return cpu.TimesWithContext(ctx, false)
+		})
+		if err == nil && len(baseline) > 0 {
+			prevCPUTimes = &baseline[0]
+		}
+		if config.CollectPerCPU {
+			prevPerCPUTimes, _ = collectCall(ctx, "per-cpu times baseline", func(ctx context.Context) ([]cpu.TimesStat, error) {
+				return cpu.TimesWithContext(ctx, true)
+			})
+		}
+		select {
+		case <-time.After(sampleWindow):
+		case <-ctx.Done():
+		}
+	}
+
+	cpuTimes, err := collectCall(ctx, "cpu times", func(ctx context.Context) ([]cpu.TimesStat, error) {
+		return cpu.TimesWithContext(ctx, false)
+	})
+	if err != nil {
+		if !notImplemented(err) {
+			errs = append(errs, fmt.Errorf("failed to read CPU times: %w", err))
+		}
+	} else if len(cpuTimes) > 0 {
+		current := cpuTimes[0]
+		if prevCPUTimes != nil {
+			usage := cpuBusyPercent(*prevCPUTimes, current)
+			payload.CPUUsagePercent = &usage
+			setCPUTimesBreakdown(payload, *prevCPUTimes, current)
+		}
+		c.prevCPUTimes = &current
+	}
+
+	// Per-core CPU metrics (ordered by core index as reported by gopsutil)
+	if config.CollectPerCPU {
+		var perCPUPercent []float64
+		perCPUTimes, err := collectCall(ctx, "per-cpu times", func(ctx context.Context) ([]cpu.TimesStat, error) {
+			return cpu.TimesWithContext(ctx, true)
+		})
+		if err != nil {
+			if !notImplemented(err) {
+				errs = append(errs, fmt.Errorf("failed to read per-CPU times: %w", err))
+			}
+		} else if len(perCPUTimes) > 0 {
+			// Skipped for a cycle when CPUs were hotplugged since the baseline
+			if len(prevPerCPUTimes) == len(perCPUTimes) {
+				perCPUPercent = make([]float64, len(perCPUTimes))
+				for i := range perCPUTimes {
+					perCPUPercent[i] = cpuBusyPercent(prevPerCPUTimes[i], perCPUTimes[i])
+				}
+			}
+			c.prevPerCPUTimes = perCPUTimes
+		}
+		if len(perCPUPercent) > 0 {
+			cores := make([]CPUCore, len(perCPUPercent))
+			maxPercent, minPercent := perCPUPercent[0], perCPUPercent[0]
+			for i, percent := range perCPUPercent {
+				cores[i] = CPUCore{Core: i, UsagePercent: percent}
+				if percent > maxPercent {
+					maxPercent = percent
+				}
+				if percent < minPercent {
+					minPercent = percent
+				}
+			}
+			payload.CPUPerCore = cores
+			payload.CPUCoreMaxPercent = &maxPercent
+			payload.CPUCoreMinPercent = &minPercent
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadCollector gathers the 1, 5 and 15 minute load averages, and the same
+// divided by the number of CPUs so hosts of any size compare. In a container
+// with a CPU quota below the host's CPU count, that quota is the divisor.
+type loadCollector struct {
+	cgroupAware bool
+}
+
+func (c *loadCollector) Name() string {
+	return "load"
+}
+
+func (c *loadCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	logical, err := cpu.CountsWithContext(ctx, true)
+	if err == nil {
+		payload.NumCPU = logical
+	}
+	if physical, err := cpu.CountsWithContext(ctx, false); err == nil {
+		payload.NumPhysicalCores = physical
+	}
+
+	// Load average
+	loadAvg, err := collectCall(ctx, "load average", load.AvgWithContext)
+	if err != nil {
+		return fmt.Errorf("failed to read load average: %w", err)
+	}
+	payload.CPULoad1m = &loadAvg.Load1
+	payload.CPULoad5m = &loadAvg.Load5
+	payload.CPULoad15m = &loadAvg.Load15
+
+	cpus := float64(payload.NumCPU)
+	if c.cgroupAware {
+		if quota := readCgroupLimits().cpuQuota; quota != nil && *quota < cpus {
+			cpus = *quota
+		}
+	}
+	// Leave them out rather than divide by zero when the count is unknown
+	if cpus > 0 {
+		load1, load5, load15 := loadAvg.Load1/cpus, loadAvg.Load5/cpus, loadAvg.Load15/cpus
+		payload.CPULoad1mPerCore, payload.CPULoad5mPerCore, payload.CPULoad15mPerCore = &load1, &load5, &load15
+	}
+	return nil
+}
+
+// setCPUTimesBreakdown fills the per-state CPU percentages from the delta
+// between two cpu.Times samples.
+func setCPUTimesBreakdown(payload *MetricsPayload, prev, current cpu.TimesStat) {
+	total := cpuTimesTotal(current) - cpuTimesTotal(prev)
+	if total <= 0 {
+		return
+	}
+
+	percent := func(prevValue, currentValue float64) *float64 {
+		value := (currentValue - prevValue) / total * 100
+		if value < 0 {
+			value = 0
+		}
+		return &value
+	}
+
+	payload.CPUUserPercent = percent(prev.User+prev.Nice, current.User+current.Nice)
+	payload.CPUSystemPercent = percent(prev.System, current.System)
+	payload.CPUIRQPercent = percent(prev.Irq+prev.Softirq, current.Irq+current.Softirq)
+	payload.CPUIdlePercent = percent(prev.Idle, current.Idle)
+
+	// Only Linux accounts for iowait and steal time, elsewhere they're always
+	// zero and would be indistinguishable from a genuine 0%
+	if runtime.GOOS == "linux" {
+		payload.CPUIOWaitPercent = percent(prev.Iowait, current.Iowait)
+		payload.CPUStealPercent = percent(prev.Steal, current.Steal)
+	}
+}
+
+// cpuBusyPercent returns the share of non-idle CPU time between two samples,
+// using the same accounting as gopsutil's cpu.Percent.
+func cpuBusyPercent(prev, current cpu.TimesStat) float64 {
+	total := cpuTimesTotal(current) - cpuTimesTotal(prev)
+	if total <= 0 {
+		return 0
+	}
+	idle := (current.Idle + current.Iowait) - (prev.Idle + prev.Iowait)
+	busy := (total - idle) / total * 100
+	return math.Min(100, math.Max(0, busy))
+}
+
+// cpuTimesTotal returns the total CPU time of a sample. On Linux guest time is
+// already accounted for in user/nice, so it is excluded to avoid counting it twice.
+func cpuTimesTotal(t cpu.TimesStat) float64 {
+	total := t.Total()
+	if runtime.GOOS == "linux" {
+		total -= t.Guest + t.GuestNice
+	}
+	return total
+}
@@ -0,0 +1,136 @@
+package collectors
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+)
+
+// percentOf returns *p, or -1 when it's nil.
+func percentOf(p *float64) float64 {
+	if p == nil {
+		return -1
+	}
+	return *p
+}
+
+func TestSetCPUTimesBreakdown(t *testing.T) {
+	// On Linux guest time is already in user time, elsewhere it adds to the total
+	guestUser := 50.0
+	if runtime.GOOS == "linux" {
+		guestUser = 60
+	}
+	tests := []struct {
+		name          string
+		prev, current cpu.TimesStat
+		// -1 for a field left nil
+		wantUser, wantSystem, wantIRQ, wantIdle float64
+	}{
+		{
+			name:     "delta",
+			prev:     cpu.TimesStat{User: 100, System: 50, Idle: 1000},
+			current:  cpu.TimesStat{User: 140, Nice: 10, System: 70, Irq: 5, Softirq: 5, Idle: 1020},
+			wantUser: 50, wantSystem: 20, wantIRQ: 10, wantIdle: 20,
+		},
+		{
+			name:     "no time passed",
+			prev:     cpu.TimesStat{User: 100, Idle: 1000},
+			current:  cpu.TimesStat{User: 100, Idle: 1000},
+			wantUser: -1, wantSystem: -1, wantIRQ: -1, wantIdle: -1,
+		},
+		{
+			name:     "total went backwards",
+			prev:     cpu.TimesStat{User: 100, Idle: 1000},
+			current:  cpu.TimesStat{User: 50, Idle: 500},
+			wantUser: -1, wantSystem: -1, wantIRQ: -1, wantIdle: -1,
+		},
+		{
+			name:     "counter went backwards",
+			prev:     cpu.TimesStat{User: 100, System: 50, Idle: 1000},
+			current:  cpu.TimesStat{User: 200, System: 40, Idle: 1010},
+			wantUser: 100, wantSystem: 0, wantIRQ: 0, wantIdle: 10,
+		},
+		{
+			name:     "guest time",
+			current:  cpu.TimesStat{User: 60, Idle: 40, Guest: 20},
+			wantUser: guestUser, wantSystem: 0, wantIRQ: 0, wantIdle: 100 - guestUser,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload MetricsPayload
+			setCPUTimesBreakdown(&payload, tt.prev, tt.current)
+			for _, field := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"user", percentOf(payload.CPUUserPercent), tt.wantUser},
+				{"system", percentOf(payload.CPUSystemPercent), tt.wantSystem},
+				{"irq", percentOf(payload.CPUIRQPercent), tt.wantIRQ},
+				{"idle", percentOf(payload.CPUIdlePercent), tt.wantIdle},
+			} {
+				if field.got != field.want {
+					t.Errorf("%s = %v, want %v", field.name, field.got, field.want)
+				}
+			}
+			if runtime.GOOS != "linux" && (payload.CPUIOWaitPercent != nil || payload.CPUStealPercent != nil) {
+				t.Error("iowait or steal set outside Linux")
+			}
+		})
+	}
+}
+
+func TestCPUBusyPercent(t *testing.T) {
+	guestBusy := 50.0
+	if runtime.GOOS == "linux" {
+		guestBusy = 40
+	}
+	tests := []struct {
+		name          string
+		prev, current cpu.TimesStat
+		want          float64
+	}{
+		{
+			name:    "delta",
+			prev:    cpu.TimesStat{User: 100, Idle: 1000},
+			current: cpu.TimesStat{User: 130, System: 10, Idle: 1060},
+			want:    40,
+		},
+		{
+			name:    "iowait counts as idle",
+			current: cpu.TimesStat{User: 25, Idle: 50, Iowait: 25},
+			want:    25,
+		},
+		{
+			name:    "no time passed",
+			prev:    cpu.TimesStat{User: 100, Idle: 1000},
+			current: cpu.TimesStat{User: 100, Idle: 1000},
+			want:    0,
+		},
+		{
+			name:    "total went backwards",
+			prev:    cpu.TimesStat{User: 100, Idle: 1000},
+			current: cpu.TimesStat{User: 50, Idle: 500},
+			want:    0,
+		},
+		{
+			name:    "idle went backwards",
+			prev:    cpu.TimesStat{User: 100, Idle: 1000},
+			current: cpu.TimesStat{User: 200, Idle: 990},
+			want:    100,
+		},
+		{
+			name:    "guest time",
+			current: cpu.TimesStat{User: 40, Idle: 60, Guest: 20},
+			want:    guestBusy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cpuBusyPercent(tt.prev, tt.current); got != tt.want {
+				t.Errorf("cpuBusyPercent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// diskIOCounters reads the per-device I/O counters once per cycle for both
+// the disk and diskio collectors, so the two report the same sample and
+// /proc/diskstats is read only once. The registry resets it at the start of
+// every cycle.
+type diskIOCounters struct {
+	mu    sync.Mutex
+	read  bool
+	stats map[string]disk.IOCountersStat
+	err   error
+	// Stand-in for IOCountersWithContext in tests
+	ioCounters func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error)
+}
+
+// reset makes the next get read the counters again.
+func (d *diskIOCounters) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.read, d.stats, d.err = false, nil, nil
+}
+
+// get returns this cycle's counters, reading them on the first call. A read
+// cut short by the caller's own deadline isn't kept, so the other collector
+// still gets to try.
+func (d *diskIOCounters) get(ctx context.Context) (map[string]disk.IOCountersStat, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.read {
+		return d.stats, d.err
+	}
+	ioCounters := disk.IOCountersWithContext
+	if d.ioCounters != nil {
+		ioCounters = d.ioCounters
+	}
+	stats, err := collectCall(ctx, "disk io", func(ctx context.Context) (map[string]disk.IOCountersStat, error) {
+		return ioCounters(ctx)
+	})
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+	d.read, d.stats, d.err = true, stats, err
+	return stats, err
+}
+
+// diskCollector gathers usage of the root and per-device filesystems, and
+// the I/O of each device.
+type diskCollector struct {
+	config *Config
+	io     *diskIOCounters
+	counterHistory
+	prev map[string]disk.IOCountersStat
+}
+
+func (c *diskCollector) Name() string {
+	return "disk"
+}
+
+func (c *diskCollector) Reset() {
+	c.prev = nil
+}
+
+func (c *diskCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	config := c.config
+	ic := c.next()
+	prev := c.prev
+	var errs []error
+
+	// Disk metrics (root filesystem, or whichever volume is configured as the main one)
+	diskInfo, err := collectCall(ctx, "disk usage", func(ctx context.Context) (*disk.UsageStat, error) {
+		return disk.UsageWithContext(ctx, config.RootDiskPath)
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get disk usage for %s: %w", config.RootDiskPath, err))
+	} else {
+		payload.DiskUsagePercent = &diskInfo.UsedPercent
+		payload.DiskUsedBytes = &diskInfo.Used
+		payload.DiskTotalBytes = &diskInfo.Total
+		payload.DiskAvailableBytes = &diskInfo.Free
+		payload.DiskInodesUsedPercent, payload.DiskInodesUsed, payload.DiskInodesTotal = inodeUsage(diskInfo)
+	}
+
+	// Per-disk information
+	diskDevices := []DiskDevice{}
+	// I/O stats, matched to the devices below
+	diskIOStats, _ := c.io.get(ctx)
+
+	partitions, err := collectCall(ctx, "partitions", func(ctx context.Context) ([]disk.PartitionStat, error) {
+		return disk.PartitionsWithContext(ctx, false) // false = only physical devices
+	})
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list partitions: %w", err))
+	} else {
+
+		slog.Debug("Found partitions", "count", len(partitions))
+
+		partitions, skippedMounts := filterPartitions(partitions, config)
+		mounts := primaryMounts(partitions, func(mountpoint string) (*disk.UsageStat, error) {
+			return collectCall(ctx, "disk usage of "+mountpoint, func(ctx context.Context) (*disk.UsageStat, error) {
+				return disk.UsageWithContext(ctx, mountpoint)
+			})
+		})
+
+		for _, mount := range mounts {
+			partition, usage := mount.partition, mount.usage
+			device := DiskDevice{
+				Device:         partition.Device,
+				Mountpoint:     partition.Mountpoint,
+				Filesystem:     partition.Fstype,
+				UsagePercent:   usage.UsedPercent,
+				UsedBytes:      usage.Used,
+				TotalBytes:     usage.Total,
+				AvailableBytes: usage.Free,
+			}
+			device.InodesUsedPercent, device.InodesUsed, device.InodesTotal = inodeUsage(usage)
+
+			// Try to match with I/O stats, by partition first and then by
+			// the disk it belongs to
+			for _, name := range ioCounterNames(partition.Device) {
+				if ioStat, exists := diskIOStats[name]; exists {
+					if config.RawCounters {
+						device.ReadBytes = ioStat.ReadBytes
+						device.WriteBytes = ioStat.WriteBytes
+						device.ReadOps = ioStat.ReadCount
+						device.WriteOps = ioStat.WriteCount
+					}
+					if prev != nil {
+						if prevStat, ok := prev[name]; ok {
+							device.ReadBytesDelta = ic.delta(prevStat.ReadBytes, ioStat.ReadBytes)
+							device.WriteBytesDelta = ic.delta(prevStat.WriteBytes, ioStat.WriteBytes)
+							device.ReadOpsDelta = ic.delta(prevStat.ReadCount, ioStat.ReadCount)
+							device.WriteOpsDelta = ic.delta(prevStat.WriteCount, ioStat.WriteCount)
+							device.ReadBytesPerSec = ic.perSec(prevStat.ReadBytes, ioStat.ReadBytes)
+							device.WriteBytesPerSec = ic.perSec(prevStat.WriteBytes, ioStat.WriteBytes)
+						}
+					}
+					break
+				}
+			}
+
+			diskDevices = append(diskDevices, device)
+
+			if config.Debug {
+				inodes := "n/a"
+				if device.InodesUsedPercent != nil {
+					inodes = fmt.Sprintf("%.1f%%", *device.InodesUsedPercent)
+				}
+				slog.Debug("Added disk", "device", device.Device, "filesystem", device.Filesystem, "mountpoint", device.Mountpoint,
+					"usage_percent", device.UsagePercent, "inodes_used_percent", inodes)
+			}
+		}
+
+		slog.Debug("Collected disk devices", "count", len(diskDevices), "skipped_mounts", skippedMounts)
+	}
+	payload.DiskDevices = diskDevices
+	c.prev = diskIOStats
+	return errors.Join(errs...)
+}
+
+// diskIOCollector gathers the disk I/O counters summed over all devices.
+type diskIOCollector struct {
+	config *Config
+	io     *diskIOCounters
+	counterHistory
+	prev map[string]disk.IOCountersStat
+}
+
+func (c *diskIOCollector) Name() string {
+	return "diskio"
+}
+
+func (c *diskIOCollector) Reset() {
+	c.prev = nil
+}
+
+func (c *diskIOCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	config := c.config
+	ic := c.next()
+	prev := c.prev
+	c.prev = nil
+
+	diskIOStats, err := c.io.get(ctx)
+	if notImplemented(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read disk I/O counters: %w", err)
+	}
+
+	// Sum up all disk devices
+	var totalReadBytes, totalWriteBytes, totalReadOps, totalWriteOps, totalIOTime uint64
+	for _, ioStat := range diskIOStats {
+		totalReadBytes += ioStat.ReadBytes
+		totalWriteBytes += ioStat.WriteBytes
+		totalReadOps += ioStat.ReadCount
+		totalWriteOps += ioStat.WriteCount
+		totalIOTime += ioStat.IoTime
+	}
+	if config.RawCounters {
+		payload.DiskReadBytes = &totalReadBytes
+		payload.DiskWriteBytes = &totalWriteBytes
+		payload.DiskReadOps = &totalReadOps
+		payload.DiskWriteOps = &totalWriteOps
+		payload.DiskIOTime = &totalIOTime
+	}
+
+	// Deltas are summed per device so disks appearing or disappearing
+	// between cycles don't skew the totals
+	if prev != nil {
+		var readBytes, writeBytes, readOps, writeOps, ioTime uint64
+		var readBytesRate, writeBytesRate, readOpsRate, writeOpsRate float64
+		for name, ioStat := range diskIOStats {
+			prevStat, ok := prev[name]
+			if !ok {
+				continue
+			}
+			readBytes += *ic.delta(prevStat.ReadBytes, ioStat.ReadBytes)
+			writeBytes += *ic.delta(prevStat.WriteBytes, ioStat.WriteBytes)
+			readOps += *ic.delta(prevStat.ReadCount, ioStat.ReadCount)
+			writeOps += *ic.delta(prevStat.WriteCount, ioStat.WriteCount)
+			ioTime += *ic.delta(prevStat.IoTime, ioStat.IoTime)
+			readBytesRate += *ic.perSec(prevStat.ReadBytes, ioStat.ReadBytes)
+			writeBytesRate += *ic.perSec(prevStat.WriteBytes, ioStat.WriteBytes)
+			readOpsRate += *ic.perSec(prevStat.ReadCount, ioStat.ReadCount)
+			writeOpsRate += *ic.perSec(prevStat.WriteCount, ioStat.WriteCount)
+		}
+		payload.DiskReadBytesDelta = &readBytes
+		payload.DiskWriteBytesDelta = &writeBytes
+		payload.DiskReadOpsDelta = &readOps
+		payload.DiskWriteOpsDelta = &writeOps
+		payload.DiskIOTimeDelta = &ioTime
+		payload.DiskReadBytesPerSec = &readBytesRate
+		payload.DiskWriteBytesPerSec = &writeBytesRate
+		payload.DiskReadOpsPerSec = &readOpsRate
+		payload.DiskWriteOpsPerSec = &writeOpsRate
+	}
+	c.prev = diskIOStats
+	return nil
+}
+
+// inodeUsage returns the inode counts of a filesystem, or nils for
+// filesystems that don't track inodes (FAT, many network mounts report a
+// total of 0) so they are omitted rather than reported as 0% used.
+func inodeUsage(usage *disk.UsageStat) (usedPercent *float64, used, total *uint64) {
+	if usage.InodesTotal == 0 {
+		return nil, nil, nil
+	}
+	return &usage.InodesUsedPercent, &usage.InodesUsed, &usage.InodesTotal
+}
+
+// filterPartitions returns the partitions the disk list reports, in order,
+// and how many were left out by the mountpoint filter. Special filesystems
+// are skipped first (CRICKET_FS_EXCLUDE / CRICKET_FS_INCLUDE), then
+// mountpoints that CRICKET_DISK_INCLUDE doesn't match or CRICKET_DISK_EXCLUDE
+// does, then read-only mounts with CRICKET_SKIP_READONLY. It runs before
+// disk.Usage, so filtered mounts cost no statfs call.
+func filterPartitions(partitions []disk.PartitionStat, config *Config) ([]disk.PartitionStat, int) {
+	var kept []disk.PartitionStat
+	skippedMounts := 0
+	for _, partition := range partitions {
+		if config.FSTypes.Skip(partition.Fstype) {
+			continue
+		}
+		if !config.Mounts.MatchPath(partition.Mountpoint) {
+			skippedMounts++
+			continue
+		}
+		if config.SkipReadOnly && isReadOnlyMount(partition.Opts) {
+			continue
+		}
+		kept = append(kept, partition)
+	}
+	return kept, skippedMounts
+}
+
+// mountUsage is a partition with its usage.
+type mountUsage struct {
+	partition disk.PartitionStat
+	usage     *disk.UsageStat
+}
+
+// primaryMounts returns the partitions with their usage, read by usage. A
+// device mounted more than once (bind mounts, btrfs subvolumes) is reported
+// only once, at its primary mountpoint. Going through the mounts shortest
+// path first makes that "/" or the shortest one, and falls back to the next
+// one if its usage can't be read.
+func primaryMounts(partitions []disk.PartitionStat, usage func(mountpoint string) (*disk.UsageStat, error)) []mountUsage {
+	partitions = append([]disk.PartitionStat(nil), partitions...)
+	sort.SliceStable(partitions, func(i, j int) bool {
+		return len(partitions[i].Mountpoint) < len(partitions[j].Mountpoint)
+	})
+	var mounts []mountUsage
+	seenDevices := map[string]bool{}
+	for _, partition := range partitions {
+		if seenDevices[partition.Device] {
+			slog.Debug("Skipping mount, its device is already reported", "mountpoint", partition.Mountpoint, "device", partition.Device)
+			continue
+		}
+		stat, err := usage(partition.Mountpoint)
+		if err != nil {
+			slog.Debug("Skipping mount", "mountpoint", partition.Mountpoint, "error", err)
+			continue
+		}
+		seenDevices[partition.Device] = true
+		mounts = append(mounts, mountUsage{partition: partition, usage: stat})
+	}
+	return mounts
+}
+
+// isReadOnlyMount reports whether the mount options include "ro".
+func isReadOnlyMount(opts []string) bool {
+	for _, opt := range opts {
+		if opt == "ro" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package collectors
+
+import "os"
+
+// DefaultRootDiskPath is the filesystem reported as the host's root disk
+// when CRICKET_ROOT_DISK_PATH isn't set. Since macOS 10.15 "/" is the sealed,
+// read-only system volume, and everything that fills up the disk lives on
+// the data volume next to it.
+func DefaultRootDiskPath() string {
+	if _, err := os.Stat("/System/Volumes/Data"); err == nil {
+		return "/System/Volumes/Data"
+	}
+	return "/"
+}
+
+// ioCounterNames returns no names on macOS: partitions are APFS volumes
+// (disk3s1s1), while the I/O counters are kept per physical disk (disk0), so
+// there is nothing to match. The totals in the diskio collector still cover
+// all disks.
+func ioCounterNames(device string) []string {
+	return nil
+}
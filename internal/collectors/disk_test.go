@@ -0,0 +1,151 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestFilterPartitions(t *testing.T) {
+	partitions := []disk.PartitionStat{
+		// The root filesystem bind-mounted, listed before the root mount
+		{Device: "/dev/sda1", Mountpoint: "/var/lib/docker", Fstype: "ext4", Opts: []string{"rw"}},
+		{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4", Opts: []string{"rw"}},
+		{Device: "/dev/sda2", Mountpoint: "/boot", Fstype: "ext4", Opts: []string{"rw"}},
+		{Device: "/dev/sdb1", Mountpoint: "/data1", Fstype: "xfs", Opts: []string{"rw"}},
+		{Device: "/dev/sdc1", Mountpoint: "/data2", Fstype: "xfs", Opts: []string{"rw"}},
+		{Device: "/dev/sdd1", Mountpoint: "/data2/scratch", Fstype: "xfs", Opts: []string{"rw"}},
+		{Device: "/dev/loop0", Mountpoint: "/snap/core/1", Fstype: "squashfs", Opts: []string{"ro"}},
+		{Device: "/dev/sde1", Mountpoint: "/mnt/backup", Fstype: "ext4", Opts: []string{"ro"}},
+		{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs", Opts: []string{"rw"}},
+		// A bind mount of /data1
+		{Device: "/dev/sdb1", Mountpoint: "/srv/data1", Fstype: "xfs", Opts: []string{"rw"}},
+	}
+
+	tests := []struct {
+		name        string
+		mounts      GlobFilter
+		readOnly    bool
+		unreadable  []string
+		want        []string
+		wantSkipped int
+	}{
+		{
+			name: "no filter",
+			want: []string{"/", "/boot", "/data1", "/data2", "/mnt/backup", "/data2/scratch"},
+		},
+		{
+			name:        "include",
+			mounts:      GlobFilter{Include: []string{"/boot", "/data*"}},
+			want:        []string{"/boot", "/data1", "/data2", "/data2/scratch"},
+			wantSkipped: 4,
+		},
+		{
+			name:        "exclude covers mounts below",
+			mounts:      GlobFilter{Exclude: []string{"/data2"}},
+			want:        []string{"/", "/boot", "/data1", "/mnt/backup"},
+			wantSkipped: 2,
+		},
+		{
+			name:        "exclude applied after include",
+			mounts:      GlobFilter{Include: []string{"/data*"}, Exclude: []string{"/data2/*"}},
+			want:        []string{"/data1", "/data2"},
+			wantSkipped: 6,
+		},
+		{
+			name:        "fstype skip comes first",
+			mounts:      GlobFilter{Include: []string{"/run", "/snap/*"}},
+			want:        nil,
+			wantSkipped: 8,
+		},
+		{
+			name:     "read-only",
+			readOnly: true,
+			want:     []string{"/", "/boot", "/data1", "/data2", "/data2/scratch"},
+		},
+		{
+			name:        "device mounted twice is reported at the filtered mount",
+			mounts:      GlobFilter{Exclude: []string{"/data1"}},
+			want:        []string{"/", "/boot", "/data2", "/srv/data1", "/mnt/backup", "/data2/scratch"},
+			wantSkipped: 1,
+		},
+		{
+			name:        "shortest mountpoint wins",
+			mounts:      GlobFilter{Exclude: []string{"/boot", "/data*", "/mnt/*", "/srv/*"}},
+			want:        []string{"/"},
+			wantSkipped: 6,
+		},
+		{
+			name:       "next mount when usage can't be read",
+			unreadable: []string{"/data1"},
+			want:       []string{"/", "/boot", "/data2", "/srv/data1", "/mnt/backup", "/data2/scratch"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				FSTypes:      NewFSTypeFilter("", ""),
+				Mounts:       tt.mounts,
+				SkipReadOnly: tt.readOnly,
+			}
+			kept, skipped := filterPartitions(partitions, config)
+			mounts := primaryMounts(kept, func(mountpoint string) (*disk.UsageStat, error) {
+				for _, unreadable := range tt.unreadable {
+					if mountpoint == unreadable {
+						return nil, errors.New("permission denied")
+					}
+				}
+				return &disk.UsageStat{Path: mountpoint}, nil
+			})
+			var got []string
+			for _, mount := range mounts {
+				got = append(got, mount.partition.Mountpoint)
+				if mount.usage.Path != mount.partition.Mountpoint {
+					t.Errorf("%s has the usage of %s", mount.partition.Mountpoint, mount.usage.Path)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mountpoints = %v, want %v", got, tt.want)
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("skipped = %d, want %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestDiskIOCountersReadOncePerCycle(t *testing.T) {
+	reads := 0
+	counters := diskIOCounters{
+		ioCounters: func(ctx context.Context, names ...string) (map[string]disk.IOCountersStat, error) {
+			reads++
+			return map[string]disk.IOCountersStat{"sda": {Name: "sda", ReadBytes: uint64(reads)}}, nil
+		},
+	}
+
+	// The disk and diskio collectors share the first read of a cycle
+	first, _ := counters.get(context.Background())
+	second, _ := counters.get(context.Background())
+	if reads != 1 || first["sda"].ReadBytes != 1 || second["sda"].ReadBytes != 1 {
+		t.Fatalf("%d reads, got %v and %v, want one read shared", reads, first, second)
+	}
+
+	counters.reset()
+	if stats, _ := counters.get(context.Background()); reads != 2 || stats["sda"].ReadBytes != 2 {
+		t.Errorf("%d reads, got %v after a reset, want a new read", reads, stats)
+	}
+
+	// A caller out of time doesn't spoil the cycle for the other one
+	counters.reset()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := counters.get(cancelled); err == nil {
+		t.Error("get() with a cancelled context succeeded")
+	}
+	if stats, err := counters.get(context.Background()); err != nil || stats["sda"].ReadBytes != 3 {
+		t.Errorf("get() = %v, %v, want a new read", stats, err)
+	}
+}
@@ -0,0 +1,91 @@
+//go:build !windows && !darwin
+
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultRootDiskPath is the filesystem reported as the host's root disk
+// when CRICKET_ROOT_DISK_PATH isn't set.
+func DefaultRootDiskPath() string {
+	return "/"
+}
+
+// ioCounterNames lists the disk.IOCounters keys that may hold the I/O stats
+// of a mounted device, most specific first: the kernel name of the device
+// itself (sda1, nvme0n1p1, dm-3), then the whole disk it is a partition of.
+func ioCounterNames(device string) []string {
+	var names []string
+	add := func(name string) {
+		for _, existing := range names {
+			if existing == name {
+				return
+			}
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	// /dev/mapper/* and /dev/disk/by-*/* are symlinks to the kernel name
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	name := filepath.Base(device)
+	if strings.HasPrefix(device, "/dev/mapper/") {
+		// The symlink can be missing (e.g. inside containers), sysfs has
+		// the mapping as well
+		if dm := deviceMapperName(name); dm != "" {
+			name = dm
+		}
+	}
+	add(name)
+
+	// sysfs knows which disk a partition belongs to:
+	// /sys/class/block/sda1 -> ../../devices/.../block/sda/sda1
+	if _, err := os.Stat(filepath.Join("/sys/class/block", name, "partition")); err == nil {
+		if link, err := os.Readlink(filepath.Join("/sys/class/block", name)); err == nil {
+			add(filepath.Base(filepath.Dir(link)))
+		}
+	}
+
+	// Naming conventions, for when sysfs isn't available
+	add(parentDiskName(name))
+	return names
+}
+
+var (
+	// nvme0n1p1, mmcblk0p2 and loop0p1 separate the partition number with "p"
+	pSuffixPartition = regexp.MustCompile(`^(nvme\d+n\d+|mmcblk\d+|loop\d+)p\d+$`)
+	// sda1, vdb2, xvda1, hdc3 append it to the disk name directly
+	digitSuffixPartition = regexp.MustCompile(`^((?:sd|vd|xvd|hd)[a-z]+)\d+$`)
+)
+
+// parentDiskName derives the disk name from a partition name, or returns ""
+// for names that are whole devices (dm-3, md127, nvme0n1, sda).
+func parentDiskName(name string) string {
+	if m := pSuffixPartition.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	if m := digitSuffixPartition.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// deviceMapperName finds the dm-N kernel name of a device-mapper device
+// such as "vg-root" through /sys/block/dm-*/dm/name.
+func deviceMapperName(mapperName string) string {
+	paths, _ := filepath.Glob("/sys/block/dm-*/dm/name")
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err == nil && strings.TrimSpace(string(data)) == mapperName {
+			return filepath.Base(filepath.Dir(filepath.Dir(p)))
+		}
+	}
+	return ""
+}
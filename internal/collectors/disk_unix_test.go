@@ -0,0 +1,69 @@
+//go:build !windows && !darwin
+
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParentDiskName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"sda1", "sda"},
+		{"sdab12", "sdab"},
+		{"vdb2", "vdb"},
+		{"xvda1", "xvda"},
+		{"hdc3", "hdc"},
+		{"nvme0n1p1", "nvme0n1"},
+		{"nvme10n2p15", "nvme10n2"},
+		{"mmcblk0p2", "mmcblk0"},
+		{"loop0p1", "loop0"},
+		// Whole devices
+		{"sda", ""},
+		{"nvme0n1", ""},
+		{"mmcblk0", ""},
+		{"dm-3", ""},
+		{"md127", ""},
+	}
+	for _, tt := range tests {
+		if got := parentDiskName(tt.name); got != tt.want {
+			t.Errorf("parentDiskName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIOCounterNames(t *testing.T) {
+	// A /dev/disk/by-uuid style link to an eMMC partition
+	dir := t.TempDir()
+	target := filepath.Join(dir, "mmcblk7p2")
+	if err := os.WriteFile(target, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "by-uuid-0a1b")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	// Device names that don't exist here, so only the naming conventions
+	// apply
+	tests := []struct {
+		device string
+		want   []string
+	}{
+		{"/dev/sdzz3", []string{"sdzz3", "sdzz"}},
+		{"/dev/nvme97n1p2", []string{"nvme97n1p2", "nvme97n1"}},
+		{"/dev/nvme97n1", []string{"nvme97n1"}},
+		{"/dev/dm-97", []string{"dm-97"}},
+		{link, []string{"mmcblk7p2", "mmcblk7"}},
+	}
+	for _, tt := range tests {
+		if got := ioCounterNames(tt.device); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ioCounterNames(%q) = %v, want %v", tt.device, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package collectors
+
+import (
+	"os"
+	"strings"
+)
+
+// DefaultRootDiskPath is the filesystem reported as the host's root disk
+// when CRICKET_ROOT_DISK_PATH isn't set: the drive Windows is installed on.
+func DefaultRootDiskPath() string {
+	if drive := os.Getenv("SystemDrive"); drive != "" {
+		return strings.TrimSuffix(drive, `\`) + `\`
+	}
+	return `C:\`
+}
+
+// ioCounterNames lists the disk.IOCounters keys that may hold the I/O stats
+// of a mounted device. Windows partitions are drive letters ("C:"), and the
+// counters are keyed by the same letter.
+func ioCounterNames(device string) []string {
+	name := strings.ToUpper(strings.TrimRight(device, `\/`))
+	if name == "" {
+		return nil
+	}
+	return []string{name}
+}
@@ -0,0 +1,119 @@
+package collectors
+
+import (
+	"path"
+	"strings"
+)
+
+// GlobFilter decides whether a name should be reported based on
+// comma-separated glob patterns. Patterns prefixed with "!" exclude, the
+// others include. When there are include patterns a name must match one of
+// them, and an exclude match always wins.
+type GlobFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// ParseGlobFilter reads a comma-separated list of glob patterns, with "!"
+// marking the excluded ones.
+func ParseGlobFilter(value string) GlobFilter {
+	var f GlobFilter
+	for _, pattern := range SplitList(value) {
+		if strings.HasPrefix(pattern, "!") {
+			f.Exclude = append(f.Exclude, strings.TrimPrefix(pattern, "!"))
+		} else {
+			f.Include = append(f.Include, pattern)
+		}
+	}
+	return f
+}
+
+// SplitList splits a comma-separated setting, dropping empty entries.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (f GlobFilter) Match(name string) bool {
+	for _, pattern := range f.Exclude {
+		if matched, _ := path.Match(pattern, name); matched {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPath is Match for filesystem paths, where a pattern also covers
+// everything below the directories it matches: "/var/lib/kubelet/*" matches
+// "/var/lib/kubelet/pods/<id>/volumes/..." too.
+func (f GlobFilter) MatchPath(p string) bool {
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			for dir := p; ; dir = path.Dir(dir) {
+				if matched, _ := path.Match(pattern, dir); matched {
+					return true
+				}
+				if dir == "/" || dir == "." {
+					break
+				}
+			}
+		}
+		return false
+	}
+	if matches(f.Exclude) {
+		return false
+	}
+	return len(f.Include) == 0 || matches(f.Include)
+}
+
+// defaultFSExclude lists the pseudo, virtual and read-only image filesystems
+// that are left out of the per-disk list.
+var defaultFSExclude = []string{
+	"tmpfs", "devtmpfs", "sysfs", "proc", "devpts", "securityfs",
+	"cgroup", "cgroup2", "overlay", "squashfs", "autofs", "fuse.*", "devfs",
+}
+
+// FSTypeFilter decides which filesystem types are skipped in the disk loop.
+// Patterns are globs, so "fuse.*" covers every FUSE mount, and an include
+// match wins over an exclude match so hosts with an overlay root can opt
+// back in.
+type FSTypeFilter struct {
+	Exclude []string
+	Include []string
+}
+
+// NewFSTypeFilter extends the default exclude list with the comma-separated
+// exclude patterns and lets the include patterns override both.
+func NewFSTypeFilter(exclude, include string) FSTypeFilter {
+	return FSTypeFilter{
+		Exclude: append(append([]string{}, defaultFSExclude...), SplitList(exclude)...),
+		Include: SplitList(include),
+	}
+}
+
+func (f FSTypeFilter) Skip(fstype string) bool {
+	for _, pattern := range f.Include {
+		if matched, _ := path.Match(pattern, fstype); matched {
+			return false
+		}
+	}
+	for _, pattern := range f.Exclude {
+		if matched, _ := path.Match(pattern, fstype); matched {
+			return true
+		}
+	}
+	return false
+}
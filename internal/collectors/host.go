@@ -0,0 +1,169 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	stdnet "net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// recentBootWindow is how soon after boot the collector's first payload is
+// flagged as the first one after a reboot.
+const recentBootWindow = 5 * time.Minute
+
+// bootTimeTolerance absorbs the jitter in the boot time derived from the
+// wall clock minus the uptime, e.g. after NTP adjusts the clock.
+const bootTimeTolerance = 60
+
+// hostCollector reports the host's identity, uptime and IP address, and
+// notices reboots.
+type hostCollector struct {
+	config       *Config
+	prevBootTime uint64
+	fqdn         fqdnCache
+}
+
+func (c *hostCollector) Name() string {
+	return "host"
+}
+
+func (c *hostCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	payload.IPAddress = c.ipAddress(ctx)
+	// DNS is only asked when CRICKET_HOSTNAME_SOURCE=fqdn; otherwise the
+	// FQDN is reported when the host name already is one
+	switch source := c.config.HostnameSource; {
+	case strings.EqualFold(source, HostnameFQDN):
+		payload.FQDN = c.fqdn.lookup(ctx)
+	case isLiteralHostname(source):
+		if strings.Contains(source, ".") {
+			payload.FQDN = source
+		}
+	default:
+		if hostname, err := os.Hostname(); err == nil && strings.Contains(strings.TrimSuffix(hostname, "."), ".") {
+			payload.FQDN = strings.TrimSuffix(hostname, ".")
+		}
+	}
+
+	hostInfo, err := collectCall(ctx, "host info", host.InfoWithContext)
+	if err != nil {
+		return fmt.Errorf("failed to read host info: %w", err)
+	}
+	payload.OperatingSystem = hostInfo.OS
+	payload.UptimeSeconds = hostInfo.Uptime
+	payload.KernelVersion = hostInfo.KernelVersion
+	payload.PlatformFamily = hostInfo.PlatformFamily
+	payload.PlatformVersion = hostInfo.PlatformVersion
+	payload.HostID = hostInfo.HostID
+	payload.Virtualization = hostInfo.VirtualizationSystem
+
+	if hostInfo.BootTime > 0 {
+		payload.BootTime = time.Unix(int64(hostInfo.BootTime), 0).UTC().Format(time.RFC3339)
+		payload.Rebooted = c.detectReboot(hostInfo.BootTime, hostInfo.Uptime)
+	}
+	return nil
+}
+
+// ipAddress returns CRICKET_IP_ADDRESS if set, otherwise the IPv4 address
+// of the interface traffic to the API leaves from, or failing that the first
+// non-loopback one.
+func (c *hostCollector) ipAddress(ctx context.Context) string {
+	if c.config.IPAddress != "" {
+		return c.config.IPAddress
+	}
+	if ip := outboundIP(ctx, c.config.IPDetectTarget); ip != "" {
+		return ip
+	}
+	addrs, err := stdnet.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	return firstInterfaceIP(addrs)
+}
+
+// outboundIP returns the local address of a UDP socket connected to target
+// (host:port). Connecting a UDP socket sends nothing, it only makes the
+// kernel pick the route, which avoids guessing among several interfaces.
+func outboundIP(ctx context.Context, target string) string {
+	if target == "" {
+		return ""
+	}
+	var dialer stdnet.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", target)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	addr, ok := conn.LocalAddr().(*stdnet.UDPAddr)
+	if !ok || addr.IP.IsUnspecified() {
+		return ""
+	}
+	return addr.IP.String()
+}
+
+// firstInterfaceIP returns the first IPv4 address that isn't loopback or
+// link-local, or "" if there is none.
+func firstInterfaceIP(addrs []stdnet.Addr) string {
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*stdnet.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		return ip.String()
+	}
+	return ""
+}
+
+// detectReboot reports whether the host rebooted since the previous cycle,
+// or, on the first cycle, whether it booted only moments ago (the collector
+// starting at boot). After a reboot the registry drops the counter state
+// from before it, since diffing against it would make deltas and rates
+// nonsense.
+func (c *hostCollector) detectReboot(bootTime, uptime uint64) bool {
+	prev := c.prevBootTime
+	c.prevBootTime = bootTime
+	if prev == 0 {
+		return time.Duration(uptime)*time.Second < recentBootWindow
+	}
+	if bootTime <= prev+bootTimeTolerance {
+		return false
+	}
+
+	slog.Info("Host rebooted, resetting counter state", "previous_boot_time", prev, "boot_time", bootTime)
+	return true
+}
+
+// selfCollector reports the collector process's own resource usage
+// (CRICKET_SELF_METRICS).
+type selfCollector struct {
+	self *process.Process
+}
+
+func (c selfCollector) Name() string {
+	return "self"
+}
+
+func (c selfCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	payload.Collector = &CollectorStats{
+		MemoryBytes: memStats.Alloc,
+		Goroutines:  runtime.NumGoroutine(),
+	}
+	cpuPercent, err := c.self.PercentWithContext(ctx, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read own CPU usage: %w", err)
+	}
+	payload.Collector.CPUPercent = &cpuPercent
+	return nil
+}
@@ -0,0 +1,97 @@
+package collectors
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestDetectReboot(t *testing.T) {
+	const bootTime = 1700000000
+	tests := []struct {
+		name     string
+		prevBoot uint64
+		bootTime uint64
+		uptime   uint64
+		want     bool
+	}{
+		{name: "first cycle right after boot", bootTime: bootTime, uptime: 30, want: true},
+		{name: "first cycle just inside the window", bootTime: bootTime, uptime: uint64(recentBootWindow.Seconds()) - 1, want: true},
+		{name: "first cycle after the window", bootTime: bootTime, uptime: uint64(recentBootWindow.Seconds()), want: false},
+		{name: "first cycle long after boot", bootTime: bootTime, uptime: 86400, want: false},
+		{name: "same boot time", prevBoot: bootTime, bootTime: bootTime, uptime: 86400, want: false},
+		{name: "drift within the tolerance", prevBoot: bootTime, bootTime: bootTime + bootTimeTolerance, uptime: 86400, want: false},
+		{name: "drift backwards", prevBoot: bootTime, bootTime: bootTime - bootTimeTolerance*2, uptime: 86400, want: false},
+		{name: "jump past the tolerance", prevBoot: bootTime, bootTime: bootTime + bootTimeTolerance + 1, uptime: 60, want: true},
+		{name: "reboot a day later", prevBoot: bootTime, bootTime: bootTime + 86400, uptime: 600, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &hostCollector{prevBootTime: tt.prevBoot}
+			if got := c.detectReboot(tt.bootTime, tt.uptime); got != tt.want {
+				t.Errorf("detectReboot(%d, %d) after %d = %t, want %t", tt.bootTime, tt.uptime, tt.prevBoot, got, tt.want)
+			}
+			if c.prevBootTime != tt.bootTime {
+				t.Errorf("prevBootTime = %d, want %d", c.prevBootTime, tt.bootTime)
+			}
+		})
+	}
+}
+
+func TestFirstInterfaceIP(t *testing.T) {
+	ipNet := func(s string) *net.IPNet {
+		ip, network, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network.IP = ip
+		return network
+	}
+	tests := []struct {
+		name  string
+		addrs []net.Addr
+		want  string
+	}{
+		{
+			name: "routable IPv4 after the others",
+			addrs: []net.Addr{
+				ipNet("127.0.0.1/8"),
+				ipNet("::1/128"),
+				ipNet("169.254.10.1/16"),
+				ipNet("fe80::1/64"),
+				ipNet("2001:db8::5/64"),
+				&net.IPAddr{IP: net.ParseIP("198.51.100.9")},
+				ipNet("192.168.1.20/24"),
+				ipNet("10.0.0.3/8"),
+			},
+			want: "192.168.1.20",
+		},
+		{
+			name:  "no usable address",
+			addrs: []net.Addr{ipNet("127.0.0.1/8"), ipNet("169.254.10.1/16"), ipNet("2001:db8::5/64")},
+			want:  "",
+		},
+		{name: "none", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firstInterfaceIP(tt.addrs); got != tt.want {
+				t.Errorf("firstInterfaceIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPAddress(t *testing.T) {
+	// A set address is used as is, without looking at the network
+	c := &hostCollector{config: &Config{IPAddress: "203.0.113.5", IPDetectTarget: "192.0.2.1:443"}}
+	if got := c.ipAddress(context.Background()); got != "203.0.113.5" {
+		t.Errorf("ipAddress() with CRICKET_IP_ADDRESS = %q, want 203.0.113.5", got)
+	}
+
+	// Otherwise it's the address traffic to the target leaves from
+	c = &hostCollector{config: &Config{IPDetectTarget: "127.0.0.1:9"}}
+	if got := c.ipAddress(context.Background()); got != "127.0.0.1" {
+		t.Errorf("ipAddress() towards 127.0.0.1 = %q, want 127.0.0.1", got)
+	}
+}
@@ -0,0 +1,160 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	stdnet "net"
+	"os"
+	"strings"
+	"time"
+)
+
+// CRICKET_HOSTNAME_SOURCE values; anything else is a literal host name.
+const (
+	HostnameShort = "short"
+	HostnameFQDN  = "fqdn"
+)
+
+const (
+	// fqdnLookupTimeout bounds the DNS lookups for the host's FQDN, so a
+	// broken resolver can't use up the host collector's timeout.
+	fqdnLookupTimeout = 2 * time.Second
+	// fqdnTTL is how long a resolved FQDN is cached, so DNS changes are
+	// picked up without a restart.
+	fqdnTTL = time.Hour
+	// fqdnRetry is how long a failed FQDN lookup is cached before the next
+	// attempt.
+	fqdnRetry = 10 * time.Minute
+)
+
+// isLiteralHostname reports whether a CRICKET_HOSTNAME_SOURCE is a pinned
+// name rather than a way of finding it.
+func isLiteralHostname(source string) bool {
+	return source != "" && !strings.EqualFold(source, HostnameShort) && !strings.EqualFold(source, HostnameFQDN)
+}
+
+// shortHostname returns the OS host name up to its first dot, or the pinned
+// name for a literal CRICKET_HOSTNAME_SOURCE.
+func shortHostname(source string) string {
+	if isLiteralHostname(source) {
+		return source
+	}
+	hostname, _ := os.Hostname()
+	short, _, _ := strings.Cut(hostname, ".")
+	return short
+}
+
+// ServerName returns the server name to register under when
+// CRICKET_SERVER_NAME isn't set: the host name as the OS reports it for an
+// empty source, its first label for "short", the canonical DNS name for
+// "fqdn" (the short name, with a warning, when that can't be resolved) and
+// source itself otherwise.
+func ServerName(source string) (string, error) {
+	if isLiteralHostname(source) {
+		return source, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	short, _, _ := strings.Cut(hostname, ".")
+	switch {
+	case strings.EqualFold(source, HostnameShort):
+		return short, nil
+	case strings.EqualFold(source, HostnameFQDN):
+		ctx, cancel := context.WithTimeout(context.Background(), fqdnLookupTimeout)
+		defer cancel()
+		fqdn, err := LookupFQDN(ctx, hostname)
+		if err != nil {
+			slog.Warn("Failed to look up the FQDN, using the short name as the server name", "hostname", hostname, "error", err)
+			return short, nil
+		}
+		return fqdn, nil
+	}
+	return hostname, nil
+}
+
+// LookupFQDN returns the canonical DNS name of hostname, the way
+// "hostname -f" finds it: the name the forward lookup resolves to through
+// the search domains, or else a reverse lookup of its addresses that
+// extends the host name.
+func LookupFQDN(ctx context.Context, hostname string) (string, error) {
+	hostname = strings.TrimSuffix(hostname, ".")
+	if strings.Contains(hostname, ".") {
+		return hostname, nil
+	}
+	resolver := stdnet.DefaultResolver
+	if cname, err := resolver.LookupCNAME(ctx, hostname); err == nil {
+		if name := strings.TrimSuffix(cname, "."); strings.Contains(name, ".") {
+			return name, nil
+		}
+	}
+	addrs, err := resolver.LookupHost(ctx, hostname)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		names, err := resolver.LookupAddr(ctx, addr)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			name = strings.TrimSuffix(name, ".")
+			if strings.HasPrefix(strings.ToLower(name), strings.ToLower(hostname)+".") {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no domain name found for %s", hostname)
+}
+
+// fqdnCache holds the result of the last FQDN lookup, so DNS is asked again
+// only when the host name changes, the result is older than fqdnTTL or,
+// after a failure, every fqdnRetry.
+type fqdnCache struct {
+	hostname   string
+	fqdn       string
+	resolvedAt time.Time
+	warned     bool
+	// Stand-ins for LookupFQDN and time.Now in tests
+	lookupFQDN func(ctx context.Context, hostname string) (string, error)
+	now        func() time.Time
+}
+
+// lookup returns the FQDN of the OS host name, or "" when it can't be
+// resolved. Failures are logged once rather than every cycle.
+func (c *fqdnCache) lookup(ctx context.Context) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	ttl := fqdnTTL
+	if c.fqdn == "" {
+		ttl = fqdnRetry
+	}
+	if hostname == c.hostname && now().Sub(c.resolvedAt) < ttl {
+		return c.fqdn
+	}
+
+	resolve := LookupFQDN
+	if c.lookupFQDN != nil {
+		resolve = c.lookupFQDN
+	}
+	ctx, cancel := context.WithTimeout(ctx, fqdnLookupTimeout)
+	defer cancel()
+	fqdn, err := resolve(ctx, hostname)
+	if hostname != c.hostname {
+		c.warned = false
+	}
+	c.hostname, c.fqdn, c.resolvedAt = hostname, fqdn, now()
+	if err != nil && !c.warned {
+		slog.Warn("Failed to look up the FQDN, reporting only the short name", "hostname", hostname, "retry", fqdnRetry, "error", err)
+		c.warned = true
+	}
+	return fqdn
+}
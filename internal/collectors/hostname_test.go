@@ -0,0 +1,73 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFQDNCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var lookups int
+	result, resultErr := "web-1.example.com", error(nil)
+	cache := fqdnCache{
+		lookupFQDN: func(ctx context.Context, hostname string) (string, error) {
+			lookups++
+			return result, resultErr
+		},
+		now: func() time.Time { return now },
+	}
+
+	steps := []struct {
+		name        string
+		after       time.Duration
+		fail        bool
+		want        string
+		wantLookups int
+	}{
+		{name: "first lookup", want: "web-1.example.com", wantLookups: 1},
+		{name: "cached", after: fqdnTTL - time.Minute, want: "web-1.example.com", wantLookups: 1},
+		{name: "expired", after: time.Minute, fail: true, want: "", wantLookups: 2},
+		{name: "failure cached", after: fqdnRetry - time.Minute, want: "", wantLookups: 2},
+		{name: "failure retried", after: time.Minute, want: "web-1.example.com", wantLookups: 3},
+	}
+	for _, step := range steps {
+		now = now.Add(step.after)
+		if step.fail {
+			result, resultErr = "", errors.New("no such host")
+		} else {
+			result, resultErr = "web-1.example.com", nil
+		}
+		if got := cache.lookup(context.Background()); got != step.want || lookups != step.wantLookups {
+			t.Errorf("%s: lookup() = %q after %d lookups, want %q after %d", step.name, got, lookups, step.want, step.wantLookups)
+		}
+	}
+}
+
+func TestHostCollectorLooksUpFQDNOnlyWhenAsked(t *testing.T) {
+	for _, tt := range []struct {
+		source      string
+		wantLookups int
+	}{
+		{"", 0},
+		{HostnameShort, 0},
+		{"web-1.example.com", 0},
+		{HostnameFQDN, 1},
+	} {
+		var lookups int
+		c := &hostCollector{config: &Config{HostnameSource: tt.source, IPAddress: "192.0.2.1"}}
+		c.fqdn.lookupFQDN = func(ctx context.Context, hostname string) (string, error) {
+			lookups++
+			return hostname + ".example.com", nil
+		}
+		for i := 0; i < 3; i++ {
+			if err := c.Collect(context.Background(), &MetricsPayload{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if lookups != tt.wantLookups {
+			t.Errorf("CRICKET_HOSTNAME_SOURCE=%q: %d lookups in 3 cycles, want %d", tt.source, lookups, tt.wantLookups)
+		}
+	}
+}
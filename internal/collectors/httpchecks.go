@@ -0,0 +1,166 @@
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCheckBodyBytes caps how much of a response HTTP checks read when
+// looking for their expected text.
+const maxCheckBodyBytes = 1 << 20
+
+// HTTPCheck is one CRICKET_HTTP_CHECKS entry, a URL optionally followed by
+// "|"-separated options:
+//
+//	https://example.com/health                           any status below 400
+//	https://example.com/health|status=200|contains=ok    exactly 200, body contains "ok"
+//	http://example.com|redirects=false                   check the redirect itself
+//	https://example.com/slow|timeout=30s                 instead of CRICKET_HTTP_CHECK_TIMEOUT
+type HTTPCheck struct {
+	URL            string
+	Status         int
+	Contains       string
+	FollowRedirect bool
+	Timeout        time.Duration
+}
+
+// ParseHTTPChecks reads CRICKET_HTTP_CHECKS, with timeout as the default
+// for checks that don't set their own.
+func ParseHTTPChecks(value string, timeout time.Duration) []HTTPCheck {
+	var checks []HTTPCheck
+	for _, entry := range SplitList(value) {
+		options := strings.Split(entry, "|")
+		check := HTTPCheck{URL: strings.TrimSpace(options[0]), FollowRedirect: true, Timeout: timeout}
+		for _, option := range options[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+			switch key {
+			case "status":
+				if status, err := strconv.Atoi(value); err == nil {
+					check.Status = status
+				} else {
+					slog.Warn("Ignoring invalid status for HTTP check", "url", check.URL, "status", value)
+				}
+			case "contains":
+				check.Contains = value
+			case "redirects":
+				check.FollowRedirect = value != "false"
+			case "timeout":
+				if d, err := time.ParseDuration(value); err == nil {
+					check.Timeout = d
+				} else {
+					slog.Warn("Ignoring invalid timeout for HTTP check", "url", check.URL, "timeout", value)
+				}
+			default:
+				slog.Warn("Ignoring unknown option for HTTP check", "url", check.URL, "option", key)
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// httpChecksCollector runs the CRICKET_HTTP_CHECKS.
+type httpChecksCollector struct {
+	config *Config
+}
+
+func (c httpChecksCollector) Name() string {
+	return "httpchecks"
+}
+
+func (c httpChecksCollector) timeout(base time.Duration) time.Duration {
+	for _, check := range c.config.HTTPChecks {
+		base = max(base, check.Timeout+timeoutMargin)
+	}
+	return base
+}
+
+func (c httpChecksCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	payload.HTTPChecks = runHTTPChecks(ctx, c.config.HTTPChecks, c.config.UserAgent)
+	return nil
+}
+
+// runHTTPChecks runs the checks in parallel, each bounded by its timeout.
+// They use their own connections rather than the API client's, so nothing about
+// the ingest connection (proxy, client certificate) leaks into them.
+func runHTTPChecks(ctx context.Context, checks []HTTPCheck, userAgent string) []HTTPCheckResult {
+	results := make([]HTTPCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HTTPCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+			defer cancel()
+			results[i] = check.run(ctx, userAgent)
+		}(i, check)
+	}
+	wg.Wait()
+	return results
+}
+
+func (check HTTPCheck) run(ctx context.Context, userAgent string) HTTPCheckResult {
+	result := HTTPCheckResult{URL: check.URL}
+
+	var tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				handshake := float64(time.Since(tlsStart).Microseconds()) / 1000
+				result.TLSHandshakeMs = &handshake
+			}
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", check.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("User-Agent", userAgent)
+	client := &http.Client{
+		// A fresh connection every time, so the TLS handshake is measured
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true},
+	}
+	if !check.FollowRedirect {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBodyBytes))
+	responseTime := float64(time.Since(start).Microseconds()) / 1000
+	result.StatusCode = resp.StatusCode
+	result.ResponseTimeMs = &responseTime
+
+	switch {
+	case err != nil:
+		result.Error = fmt.Sprintf("failed to read body: %v", err)
+	case check.Status != 0 && resp.StatusCode != check.Status:
+		result.Error = fmt.Sprintf("status %d, expected %d", resp.StatusCode, check.Status)
+	case check.Status == 0 && resp.StatusCode >= 400:
+		result.Error = fmt.Sprintf("status %d", resp.StatusCode)
+	case check.Contains != "" && !bytes.Contains(body, []byte(check.Contains)):
+		result.Error = fmt.Sprintf("body does not contain %q", check.Contains)
+	default:
+		result.Success = true
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// memoryCollector gathers memory usage, against the cgroup limit when
+// running in a container with one.
+type memoryCollector struct {
+	cgroupAware bool
+}
+
+func (c *memoryCollector) Name() string {
+	return "memory"
+}
+
+func (c *memoryCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	memInfo, err := collectCall(ctx, "memory", mem.VirtualMemoryWithContext)
+	if err != nil {
+		return fmt.Errorf("failed to read memory usage: %w", err)
+	}
+	payload.MemoryUsagePercent = &memInfo.UsedPercent
+	payload.MemoryUsedBytes = &memInfo.Used
+	payload.MemoryTotalBytes = &memInfo.Total
+	payload.MemoryAvailableBytes = &memInfo.Available
+	// Breakdown of what "used" includes, where the platform reports it;
+	// elsewhere these would read as zero rather than unknown
+	switch runtime.GOOS {
+	case "linux":
+		payload.MemoryCachedBytes = &memInfo.Cached
+		payload.MemoryBuffersBytes = &memInfo.Buffers
+		payload.MemorySharedBytes = &memInfo.Shared
+		payload.MemorySlabBytes = &memInfo.Slab
+		payload.MemoryDirtyBytes = &memInfo.Dirty
+	case "freebsd":
+		payload.MemoryCachedBytes = &memInfo.Cached
+		payload.MemoryBuffersBytes = &memInfo.Buffers
+	}
+	if c.cgroupAware {
+		applyCgroupLimits(payload, readCgroupLimits())
+	}
+	return nil
+}
+
+// swapCollector gathers swap usage, and the swap activity since the
+// previous cycle.
+type swapCollector struct {
+	counterHistory
+	prev *mem.SwapMemoryStat
+}
+
+func (c *swapCollector) Name() string {
+	return "swap"
+}
+
+func (c *swapCollector) Reset() {
+	c.prev = nil
+}
+
+func (c *swapCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	ic := c.next()
+	prev := c.prev
+	c.prev = nil
+
+	swapInfo, err := collectCall(ctx, "swap", mem.SwapMemoryWithContext)
+	if err != nil {
+		return fmt.Errorf("failed to read swap usage: %w", err)
+	}
+	payload.SwapUsedBytes = &swapInfo.Used
+	payload.SwapTotalBytes = &swapInfo.Total
+	// Hosts without swap report 0% rather than NaN
+	swapPercent := 0.0
+	if swapInfo.Total > 0 {
+		swapPercent = swapInfo.UsedPercent
+	}
+	payload.SwapUsagePercent = &swapPercent
+
+	// Bytes swapped in and out since the previous cycle, to tell a host that
+	// merely has swap in use from one that is actively paging
+	if prev != nil {
+		payload.SwapInBytes = ic.delta(prev.Sin, swapInfo.Sin)
+		payload.SwapOutBytes = ic.delta(prev.Sout, swapInfo.Sout)
+		payload.SwapInBytesPerSec = ic.perSec(prev.Sin, swapInfo.Sin)
+		payload.SwapOutBytesPerSec = ic.perSec(prev.Sout, swapInfo.Sout)
+	} else {
+		// Unlike the other rates these start out at 0 rather than missing,
+		// so swap alerts don't see a gap after every restart
+		var zero float64
+		payload.SwapInBytesPerSec, payload.SwapOutBytesPerSec = &zero, &zero
+	}
+	c.prev = swapInfo
+	return nil
+}
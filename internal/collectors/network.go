@@ -0,0 +1,229 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	stdnet "net"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// tcpStatsTimeout bounds the socket enumeration, which can take long on
+// hosts with hundreds of thousands of sockets.
+const tcpStatsTimeout = 5 * time.Second
+
+// networkCollector gathers the network I/O counters, per interface and in
+// total. The totals sum every matching interface except loopback, whose
+// traffic never leaves the host.
+type networkCollector struct {
+	config *Config
+	counterHistory
+	prev map[string]net.IOCountersStat
+}
+
+func (c *networkCollector) Name() string {
+	return "network"
+}
+
+func (c *networkCollector) Reset() {
+	c.prev = nil
+}
+
+func (c *networkCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	config := c.config
+	ic := c.next()
+	prev := c.prev
+	c.prev = nil
+
+	netStats, err := collectCall(ctx, "network io", func(ctx context.Context) ([]net.IOCountersStat, error) {
+		return net.IOCountersWithContext(ctx, true)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read network I/O counters: %w", err)
+	}
+	c.prev = aggregateNetwork(payload, config, ic, netStats, prev, loopbackInterfaces())
+	return nil
+}
+
+// aggregateNetwork sets the per-interface counters and the totals over the
+// non-loopback interfaces from netStats, diffed against prev, and returns
+// the stats the next cycle diffs against.
+func aggregateNetwork(payload *MetricsPayload, config *Config, ic *intervalCounters, netStats []net.IOCountersStat,
+	prev map[string]net.IOCountersStat, loopback map[string]bool) map[string]net.IOCountersStat {
+	netIO := make(map[string]net.IOCountersStat, len(netStats))
+
+	var total net.IOCountersStat
+	var rxBytes, txBytes, rxPackets, txPackets, rxErrors, txErrors uint64
+	var rxBytesRate, txBytesRate float64
+	for _, stat := range netStats {
+		if !config.NetInterfaces.Match(stat.Name) {
+			continue
+		}
+		netIO[stat.Name] = stat
+
+		iface := NetworkInterface{
+			Name:     stat.Name,
+			Loopback: loopback[stat.Name],
+		}
+		if config.RawCounters {
+			iface.RXBytes = stat.BytesRecv
+			iface.TXBytes = stat.BytesSent
+			iface.RXPackets = stat.PacketsRecv
+			iface.TXPackets = stat.PacketsSent
+			iface.RXErrors = stat.Errin
+			iface.TXErrors = stat.Errout
+			iface.RXDrops = stat.Dropin
+			iface.TXDrops = stat.Dropout
+		}
+		prevStat, hasPrev := prev[stat.Name]
+		if hasPrev {
+			iface.RXBytesPerSec = ic.perSec(prevStat.BytesRecv, stat.BytesRecv)
+			iface.TXBytesPerSec = ic.perSec(prevStat.BytesSent, stat.BytesSent)
+		}
+		if config.NetPerInterface {
+			payload.NetworkInterfaces = append(payload.NetworkInterfaces, iface)
+		}
+
+		if iface.Loopback {
+			continue
+		}
+		total.BytesRecv += stat.BytesRecv
+		total.BytesSent += stat.BytesSent
+		total.PacketsRecv += stat.PacketsRecv
+		total.PacketsSent += stat.PacketsSent
+		total.Errin += stat.Errin
+		total.Errout += stat.Errout
+		// Deltas are summed per interface so interfaces coming and going
+		// (containers, VPNs) don't look like counter resets
+		if hasPrev {
+			rxBytes += *ic.delta(prevStat.BytesRecv, stat.BytesRecv)
+			txBytes += *ic.delta(prevStat.BytesSent, stat.BytesSent)
+			rxPackets += *ic.delta(prevStat.PacketsRecv, stat.PacketsRecv)
+			txPackets += *ic.delta(prevStat.PacketsSent, stat.PacketsSent)
+			rxErrors += *ic.delta(prevStat.Errin, stat.Errin)
+			txErrors += *ic.delta(prevStat.Errout, stat.Errout)
+			rxBytesRate += *iface.RXBytesPerSec
+			txBytesRate += *iface.TXBytesPerSec
+		}
+	}
+
+	if config.RawCounters {
+		payload.NetworkRXBytes = &total.BytesRecv
+		payload.NetworkTXBytes = &total.BytesSent
+		payload.NetworkRXPackets = &total.PacketsRecv
+		payload.NetworkTXPackets = &total.PacketsSent
+		payload.NetworkRXErrors = &total.Errin
+		payload.NetworkTXErrors = &total.Errout
+	}
+
+	if prev != nil {
+		payload.NetworkRXBytesDelta = &rxBytes
+		payload.NetworkTXBytesDelta = &txBytes
+		payload.NetworkRXPacketsDelta = &rxPackets
+		payload.NetworkTXPacketsDelta = &txPackets
+		payload.NetworkRXErrorsDelta = &rxErrors
+		payload.NetworkTXErrorsDelta = &txErrors
+		payload.NetworkRXBytesPerSec = &rxBytesRate
+		payload.NetworkTXBytesPerSec = &txBytesRate
+	}
+	return netIO
+}
+
+// tcpCollector counts TCP sockets by state (CRICKET_TCP_STATS). They are
+// left out when the enumeration fails or takes longer than tcpStatsTimeout.
+type tcpCollector struct {
+	config *Config
+}
+
+func (c tcpCollector) Name() string {
+	return "tcp"
+}
+
+func (c tcpCollector) timeout(base time.Duration) time.Duration {
+	return max(base, tcpStatsTimeout+timeoutMargin)
+}
+
+func (c tcpCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	ctx, cancel := context.WithTimeout(ctx, tcpStatsTimeout)
+	defer cancel()
+
+	type result struct {
+		conns []net.ConnectionStat
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// Owner uids aren't needed for counting, skipping them saves a
+		// lookup per socket
+		conns, err := net.ConnectionsWithoutUidsWithContext(ctx, "tcp")
+		done <- result{conns, err}
+	}()
+
+	var conns []net.ConnectionStat
+	select {
+	case r := <-done:
+		conns = r.conns
+		if r.err != nil {
+			// Mapping sockets to processes needs privileges gopsutil may not
+			// have, but the socket tables themselves are readable by anyone
+			fallback, err := readProcNetTCP()
+			if err != nil {
+				slog.Debug("Skipping TCP connection stats", "error", r.err)
+				return nil
+			}
+			slog.Debug("Counting TCP connections from the proc filesystem", "path", procPath()+"/net/tcp", "error", r.err)
+			conns = fallback
+		}
+	case <-ctx.Done():
+		slog.Debug("Skipping TCP connection stats, enumerating sockets took too long", "timeout", tcpStatsTimeout)
+		return nil
+	}
+
+	payload.TCPConnections = countTCPConnections(conns)
+	return nil
+}
+
+// countTCPConnections counts sockets by state and the distinct ports being
+// listened on.
+func countTCPConnections(conns []net.ConnectionStat) *TCPConnectionStats {
+	stats := &TCPConnectionStats{
+		Total: len(conns),
+		States: map[string]int{
+			"established": 0,
+			"time_wait":   0,
+			"close_wait":  0,
+			"syn_recv":    0,
+			"listen":      0,
+		},
+	}
+	ports := map[uint32]bool{}
+	for _, conn := range conns {
+		stats.States[strings.ToLower(conn.Status)]++
+		if conn.Status == "LISTEN" {
+			ports[conn.Laddr.Port] = true
+		}
+	}
+	stats.ListeningPorts = len(ports)
+	return stats
+}
+
+// loopbackInterfaces returns the names of the host's loopback interfaces.
+func loopbackInterfaces() map[string]bool {
+	loopback := map[string]bool{}
+	interfaces, err := stdnet.Interfaces()
+	if err != nil {
+		// Fall back to the conventional names
+		loopback["lo"] = true
+		loopback["lo0"] = true
+		return loopback
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&stdnet.FlagLoopback != 0 {
+			loopback[iface.Name] = true
+		}
+	}
+	return loopback
+}
@@ -0,0 +1,112 @@
+package collectors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestAggregateNetworkLeavesOutLoopback(t *testing.T) {
+	prev := map[string]net.IOCountersStat{
+		"lo":   {Name: "lo", BytesRecv: 1000000, BytesSent: 1000000, PacketsRecv: 1000, PacketsSent: 1000},
+		"eth0": {Name: "eth0", BytesRecv: 5000, BytesSent: 2000, PacketsRecv: 50, PacketsSent: 20},
+		"eth1": {Name: "eth1", BytesRecv: 100, BytesSent: 100, PacketsRecv: 1, PacketsSent: 1},
+	}
+	netStats := []net.IOCountersStat{
+		{Name: "lo", BytesRecv: 9000000, BytesSent: 9000000, PacketsRecv: 9000, PacketsSent: 9000},
+		{Name: "eth0", BytesRecv: 6000, BytesSent: 2500, PacketsRecv: 60, PacketsSent: 25},
+		{Name: "eth1", BytesRecv: 600, BytesSent: 300, PacketsRecv: 6, PacketsSent: 3},
+	}
+	config := &Config{RawCounters: true, NetPerInterface: true}
+	var payload MetricsPayload
+	next := aggregateNetwork(&payload, config, &intervalCounters{elapsed: 10}, netStats, prev, map[string]bool{"lo": true})
+
+	for _, tt := range []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"rx bytes", *payload.NetworkRXBytes, uint64(6600)},
+		{"tx bytes", *payload.NetworkTXBytes, uint64(2800)},
+		{"rx packets", *payload.NetworkRXPackets, uint64(66)},
+		{"tx packets", *payload.NetworkTXPackets, uint64(28)},
+		{"rx bytes delta", *payload.NetworkRXBytesDelta, uint64(1500)},
+		{"tx bytes delta", *payload.NetworkTXBytesDelta, uint64(700)},
+		{"rx packets delta", *payload.NetworkRXPacketsDelta, uint64(15)},
+		{"tx packets delta", *payload.NetworkTXPacketsDelta, uint64(7)},
+		{"rx bytes/s", *payload.NetworkRXBytesPerSec, 150.0},
+		{"tx bytes/s", *payload.NetworkTXBytesPerSec, 70.0},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// Loopback is still listed on its own, marked as such
+	if len(payload.NetworkInterfaces) != 3 {
+		t.Fatalf("%d interfaces, want 3", len(payload.NetworkInterfaces))
+	}
+	if lo := payload.NetworkInterfaces[0]; lo.Name != "lo" || !lo.Loopback || lo.RXBytes != 9000000 {
+		t.Errorf("lo = %+v, want it listed as loopback with its counters", lo)
+	}
+	if len(next) != 3 || next["lo"].BytesRecv != 9000000 {
+		t.Errorf("next cycle's baseline = %v, want every interface", next)
+	}
+}
+
+func TestCountTCPConnections(t *testing.T) {
+	conn := func(status string, port uint32) net.ConnectionStat {
+		return net.ConnectionStat{Status: status, Laddr: net.Addr{IP: "0.0.0.0", Port: port}}
+	}
+	tests := []struct {
+		name      string
+		conns     []net.ConnectionStat
+		want      map[string]int
+		wantPorts int
+	}{
+		{
+			name: "none",
+			want: map[string]int{"established": 0, "time_wait": 0, "close_wait": 0, "syn_recv": 0, "listen": 0},
+		},
+		{
+			name: "listening",
+			// The same port on IPv4 and IPv6 is one listening port
+			conns:     []net.ConnectionStat{conn("LISTEN", 80), conn("LISTEN", 80), conn("LISTEN", 22)},
+			want:      map[string]int{"established": 0, "time_wait": 0, "close_wait": 0, "syn_recv": 0, "listen": 3},
+			wantPorts: 2,
+		},
+		{
+			name: "mixed",
+			conns: []net.ConnectionStat{
+				conn("LISTEN", 443),
+				conn("ESTABLISHED", 443), conn("ESTABLISHED", 443), conn("ESTABLISHED", 51234),
+				conn("TIME_WAIT", 443), conn("TIME_WAIT", 443),
+				conn("CLOSE_WAIT", 51000),
+				conn("SYN_RECV", 443),
+			},
+			want:      map[string]int{"established": 3, "time_wait": 2, "close_wait": 1, "syn_recv": 1, "listen": 1},
+			wantPorts: 1,
+		},
+		{
+			name:  "other states",
+			conns: []net.ConnectionStat{conn("FIN_WAIT1", 443), conn("FIN_WAIT2", 443), conn("FIN_WAIT2", 443), conn("LAST_ACK", 443)},
+			want: map[string]int{"established": 0, "time_wait": 0, "close_wait": 0, "syn_recv": 0, "listen": 0,
+				"fin_wait1": 1, "fin_wait2": 2, "last_ack": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := countTCPConnections(tt.conns)
+			if got.Total != len(tt.conns) {
+				t.Errorf("Total = %d, want %d", got.Total, len(tt.conns))
+			}
+			if got.ListeningPorts != tt.wantPorts {
+				t.Errorf("ListeningPorts = %d, want %d", got.ListeningPorts, tt.wantPorts)
+			}
+			if !reflect.DeepEqual(got.States, tt.want) {
+				t.Errorf("States = %v, want %v", got.States, tt.want)
+			}
+		})
+	}
+}
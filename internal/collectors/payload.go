@@ -0,0 +1,394 @@
+package collectors
+
+// MetricsPayload is the result of one collection cycle, as sent to the API
+// and every other output.
+type MetricsPayload struct {
+	// Server registration fields
+	ServerName      string            `json:"server_name"`
+	Hostname        string            `json:"hostname"`
+	FQDN            string            `json:"fqdn,omitempty"`
+	IPAddress       string            `json:"ip_address,omitempty"`
+	OperatingSystem string            `json:"operating_system"`
+	Architecture    string            `json:"architecture"`
+	Tags            map[string]string `json:"tags,omitempty"`
+
+	// System information
+	UptimeSeconds     uint64  `json:"uptime_seconds"`
+	BootTime          string  `json:"boot_time,omitempty"`
+	Rebooted          bool    `json:"rebooted,omitempty"`
+	KernelVersion     string  `json:"kernel_version"`
+	PlatformFamily    string  `json:"platform_family"`
+	PlatformVersion   string  `json:"platform_version"`
+	CPUModel          string  `json:"cpu_model,omitempty"`
+	CPUCores          int32   `json:"cpu_cores,omitempty"`
+	CPUThreads        int32   `json:"cpu_threads,omitempty"`
+	NumCPU            int     `json:"num_cpu,omitempty"`
+	NumPhysicalCores  int     `json:"num_physical_cores,omitempty"`
+	TotalProcesses    *uint64 `json:"total_processes,omitempty"`
+	RunningProcesses  *uint64 `json:"running_processes,omitempty"`
+	SleepingProcesses *uint64 `json:"sleeping_processes,omitempty"`
+	ZombieProcesses   *uint64 `json:"zombie_processes,omitempty"`
+	TotalThreads      *uint64 `json:"total_threads,omitempty"`
+	HostID            string  `json:"host_id"`
+	Virtualization    string  `json:"virtualization"`
+
+	// Metrics fields
+	Timestamp              string   `json:"timestamp"`
+	CPUUsagePercent        *float64 `json:"cpu_usage_percent,omitempty"`
+	CPUUserPercent         *float64 `json:"cpu_user_percent,omitempty"`
+	CPUSystemPercent       *float64 `json:"cpu_system_percent,omitempty"`
+	CPUIOWaitPercent       *float64 `json:"cpu_iowait_percent,omitempty"`
+	CPUStealPercent        *float64 `json:"cpu_steal_percent,omitempty"`
+	CPUIRQPercent          *float64 `json:"cpu_irq_percent,omitempty"`
+	CPUIdlePercent         *float64 `json:"cpu_idle_percent,omitempty"`
+	CPULoad1m              *float64 `json:"cpu_load_1m,omitempty"`
+	CPULoad5m              *float64 `json:"cpu_load_5m,omitempty"`
+	CPULoad15m             *float64 `json:"cpu_load_15m,omitempty"`
+	CPULoad1mPerCore       *float64 `json:"cpu_load_1m_per_core,omitempty"`
+	CPULoad5mPerCore       *float64 `json:"cpu_load_5m_per_core,omitempty"`
+	CPULoad15mPerCore      *float64 `json:"cpu_load_15m_per_core,omitempty"`
+	MemoryUsagePercent     *float64 `json:"memory_usage_percent,omitempty"`
+	MemoryUsedBytes        *uint64  `json:"memory_used_bytes,omitempty"`
+	MemoryTotalBytes       *uint64  `json:"memory_total_bytes,omitempty"`
+	MemoryAvailableBytes   *uint64  `json:"memory_available_bytes,omitempty"`
+	MemoryCachedBytes      *uint64  `json:"memory_cached_bytes,omitempty"`
+	MemoryBuffersBytes     *uint64  `json:"memory_buffers_bytes,omitempty"`
+	MemorySharedBytes      *uint64  `json:"memory_shared_bytes,omitempty"`
+	MemorySlabBytes        *uint64  `json:"memory_slab_bytes,omitempty"`
+	MemoryDirtyBytes       *uint64  `json:"memory_dirty_bytes,omitempty"`
+	CgroupMemoryLimitBytes *uint64  `json:"cgroup_memory_limit_bytes,omitempty"`
+	CgroupCPUQuota         *float64 `json:"cgroup_cpu_quota,omitempty"`
+	SwapUsedBytes          *uint64  `json:"swap_used_bytes,omitempty"`
+	SwapTotalBytes         *uint64  `json:"swap_total_bytes,omitempty"`
+	SwapUsagePercent       *float64 `json:"swap_usage_percent,omitempty"`
+	FDAllocated            *uint64  `json:"fd_allocated,omitempty"`
+	FDMax                  *uint64  `json:"fd_max,omitempty"`
+	FDUsagePercent         *float64 `json:"fd_usage_percent,omitempty"`
+	DiskUsagePercent       *float64 `json:"disk_usage_percent,omitempty"`
+	DiskUsedBytes          *uint64  `json:"disk_used_bytes,omitempty"`
+	DiskTotalBytes         *uint64  `json:"disk_total_bytes,omitempty"`
+	DiskAvailableBytes     *uint64  `json:"disk_available_bytes,omitempty"`
+	DiskInodesUsedPercent  *float64 `json:"disk_inodes_used_percent,omitempty"`
+	DiskInodesUsed         *uint64  `json:"disk_inodes_used,omitempty"`
+	DiskInodesTotal        *uint64  `json:"disk_inodes_total,omitempty"`
+	DiskReadBytes          *uint64  `json:"disk_read_bytes,omitempty"`
+	DiskWriteBytes         *uint64  `json:"disk_write_bytes,omitempty"`
+	DiskReadOps            *uint64  `json:"disk_read_ops,omitempty"`
+	DiskWriteOps           *uint64  `json:"disk_write_ops,omitempty"`
+	DiskIOTime             *uint64  `json:"disk_io_time,omitempty"`
+	NetworkRXBytes         *uint64  `json:"network_rx_bytes,omitempty"`
+	NetworkTXBytes         *uint64  `json:"network_tx_bytes,omitempty"`
+	NetworkRXPackets       *uint64  `json:"network_rx_packets,omitempty"`
+	NetworkTXPackets       *uint64  `json:"network_tx_packets,omitempty"`
+	NetworkRXErrors        *uint64  `json:"network_rx_errors,omitempty"`
+	NetworkTXErrors        *uint64  `json:"network_tx_errors,omitempty"`
+
+	// Per-interval deltas of the cumulative I/O counters above (omitted on the
+	// first collection after startup)
+	IntervalSeconds       *float64 `json:"interval_seconds,omitempty"`
+	CounterReset          bool     `json:"counter_reset,omitempty"`
+	DiskReadBytesDelta    *uint64  `json:"disk_read_bytes_delta,omitempty"`
+	DiskWriteBytesDelta   *uint64  `json:"disk_write_bytes_delta,omitempty"`
+	DiskReadOpsDelta      *uint64  `json:"disk_read_ops_delta,omitempty"`
+	DiskWriteOpsDelta     *uint64  `json:"disk_write_ops_delta,omitempty"`
+	DiskIOTimeDelta       *uint64  `json:"disk_io_time_delta,omitempty"`
+	NetworkRXBytesDelta   *uint64  `json:"network_rx_bytes_delta,omitempty"`
+	NetworkTXBytesDelta   *uint64  `json:"network_tx_bytes_delta,omitempty"`
+	NetworkRXPacketsDelta *uint64  `json:"network_rx_packets_delta,omitempty"`
+	NetworkTXPacketsDelta *uint64  `json:"network_tx_packets_delta,omitempty"`
+	NetworkRXErrorsDelta  *uint64  `json:"network_rx_errors_delta,omitempty"`
+	NetworkTXErrorsDelta  *uint64  `json:"network_tx_errors_delta,omitempty"`
+	SwapInBytes           *uint64  `json:"swap_in_bytes,omitempty"`
+	SwapOutBytes          *uint64  `json:"swap_out_bytes,omitempty"`
+
+	// Per-second rates over the last interval (omitted on the first collection
+	// after startup, 0 for a sample where the counter was reset)
+	DiskReadBytesPerSec  *float64 `json:"disk_read_bytes_per_sec,omitempty"`
+	DiskWriteBytesPerSec *float64 `json:"disk_write_bytes_per_sec,omitempty"`
+	DiskReadOpsPerSec    *float64 `json:"disk_read_ops_per_sec,omitempty"`
+	DiskWriteOpsPerSec   *float64 `json:"disk_write_ops_per_sec,omitempty"`
+	NetworkRXBytesPerSec *float64 `json:"network_rx_bytes_per_sec,omitempty"`
+	NetworkTXBytesPerSec *float64 `json:"network_tx_bytes_per_sec,omitempty"`
+	SwapInBytesPerSec    *float64 `json:"swap_in_bytes_per_sec,omitempty"`
+	SwapOutBytesPerSec   *float64 `json:"swap_out_bytes_per_sec,omitempty"`
+
+	// Pressure Stall Information, Linux 4.20+ (percentages of time some or all
+	// tasks were stalled, and the total stall time in microseconds)
+	PSICPUSomeAvg10     *float64 `json:"psi_cpu_some_avg10,omitempty"`
+	PSICPUSomeAvg60     *float64 `json:"psi_cpu_some_avg60,omitempty"`
+	PSICPUSomeAvg300    *float64 `json:"psi_cpu_some_avg300,omitempty"`
+	PSICPUSomeTotal     *uint64  `json:"psi_cpu_some_total,omitempty"`
+	PSICPUFullAvg10     *float64 `json:"psi_cpu_full_avg10,omitempty"`
+	PSICPUFullAvg60     *float64 `json:"psi_cpu_full_avg60,omitempty"`
+	PSICPUFullAvg300    *float64 `json:"psi_cpu_full_avg300,omitempty"`
+	PSICPUFullTotal     *uint64  `json:"psi_cpu_full_total,omitempty"`
+	PSIMemorySomeAvg10  *float64 `json:"psi_memory_some_avg10,omitempty"`
+	PSIMemorySomeAvg60  *float64 `json:"psi_memory_some_avg60,omitempty"`
+	PSIMemorySomeAvg300 *float64 `json:"psi_memory_some_avg300,omitempty"`
+	PSIMemorySomeTotal  *uint64  `json:"psi_memory_some_total,omitempty"`
+	PSIMemoryFullAvg10  *float64 `json:"psi_memory_full_avg10,omitempty"`
+	PSIMemoryFullAvg60  *float64 `json:"psi_memory_full_avg60,omitempty"`
+	PSIMemoryFullAvg300 *float64 `json:"psi_memory_full_avg300,omitempty"`
+	PSIMemoryFullTotal  *uint64  `json:"psi_memory_full_total,omitempty"`
+	PSIIOSomeAvg10      *float64 `json:"psi_io_some_avg10,omitempty"`
+	PSIIOSomeAvg60      *float64 `json:"psi_io_some_avg60,omitempty"`
+	PSIIOSomeAvg300     *float64 `json:"psi_io_some_avg300,omitempty"`
+	PSIIOSomeTotal      *uint64  `json:"psi_io_some_total,omitempty"`
+	PSIIOFullAvg10      *float64 `json:"psi_io_full_avg10,omitempty"`
+	PSIIOFullAvg60      *float64 `json:"psi_io_full_avg60,omitempty"`
+	PSIIOFullAvg300     *float64 `json:"psi_io_full_avg300,omitempty"`
+	PSIIOFullTotal      *uint64  `json:"psi_io_full_total,omitempty"`
+
+	// Per-core CPU information (ordered by core index)
+	CPUPerCore        []CPUCore `json:"cpu_per_core,omitempty"`
+	CPUCoreMaxPercent *float64  `json:"cpu_core_max_percent,omitempty"`
+	CPUCoreMinPercent *float64  `json:"cpu_core_min_percent,omitempty"`
+
+	// Per-disk information
+	DiskDevices []DiskDevice `json:"disk_devices,omitempty"`
+
+	// Per-interface network information
+	NetworkInterfaces []NetworkInterface `json:"network_interfaces,omitempty"`
+
+	// Temperature sensor readings (CRICKET_COLLECT_TEMPS)
+	Temperatures          []SensorTemp `json:"temperatures,omitempty"`
+	CPUTemperatureCelsius *float64     `json:"cpu_temperature_celsius,omitempty"`
+
+	// TCP sockets by state (CRICKET_TCP_STATS)
+	TCPConnections *TCPConnectionStats `json:"tcp_connections,omitempty"`
+
+	// systemd units (CRICKET_SYSTEMD / CRICKET_SYSTEMD_UNITS)
+	FailedUnits  *int         `json:"failed_units_count,omitempty"`
+	SystemdUnits []UnitStatus `json:"systemd_units,omitempty"`
+
+	// SMART health of the physical disks (CRICKET_SMART)
+	SmartDevices []SmartDevice `json:"smart_devices,omitempty"`
+
+	// ZFS pools (CRICKET_ZFS)
+	ZFSPools []ZFSPool `json:"zfs_pools,omitempty"`
+
+	// Linux software RAID arrays from /proc/mdstat
+	MDArrays []MDArray `json:"md_arrays,omitempty"`
+
+	// Reachability of CRICKET_PING_TARGETS
+	Probes []ProbeResult `json:"probes,omitempty"`
+
+	// Synthetic HTTP checks (CRICKET_HTTP_CHECKS)
+	HTTPChecks []HTTPCheckResult `json:"http_checks,omitempty"`
+
+	// TLS certificate expiry (CRICKET_TLS_CHECKS)
+	Certificates []CertificateInfo `json:"certificates,omitempty"`
+
+	// Heaviest processes by CPU and by memory (CRICKET_TOP_PROCESSES)
+	TopProcesses         []ProcessInfo `json:"top_processes,omitempty"`
+	TopProcessesByMemory []ProcessInfo `json:"top_processes_by_memory,omitempty"`
+
+	// Health of the processes listed in CRICKET_WATCH_PROCESSES
+	MonitoredProcesses []ProcessStatus `json:"monitored_processes,omitempty"`
+
+	// The collector's own resource usage (CRICKET_SELF_METRICS)
+	Collector *CollectorStats `json:"collector,omitempty"`
+
+	// Offline spool counters (CRICKET_SPOOL_DIR)
+	Spool *SpoolStats `json:"spool,omitempty"`
+
+	// Collectors that failed this cycle, whose fields are missing or
+	// incomplete rather than 0
+	CollectionErrors []string `json:"collection_errors,omitempty"`
+}
+
+type CPUCore struct {
+	Core         int     `json:"core"`
+	UsagePercent float64 `json:"usage_percent"`
+}
+
+type ProcessInfo struct {
+	PID        int32    `json:"pid"`
+	Name       string   `json:"name"`
+	Username   string   `json:"username,omitempty"`
+	Cmdline    string   `json:"cmdline,omitempty"`
+	CPUPercent *float64 `json:"cpu_percent,omitempty"`
+	MemoryRSS  uint64   `json:"memory_rss"`
+}
+
+// ProcessStatus is the health of one watched process, summed over all the
+// processes matching it (e.g. nginx workers). A watched process that isn't
+// running still has an entry, with Running false.
+type ProcessStatus struct {
+	Name        string   `json:"name"`
+	Running     bool     `json:"running"`
+	PID         int32    `json:"pid,omitempty"`
+	Count       int      `json:"count"`
+	CPUPercent  *float64 `json:"cpu_percent,omitempty"`
+	MemoryBytes uint64   `json:"memory_bytes"`
+	OpenFDs     *int32   `json:"open_fds,omitempty"`
+	FDLimit     *uint64  `json:"fd_limit,omitempty"`
+	FDUsage     *float64 `json:"fd_usage_percent,omitempty"`
+}
+
+// CollectorStats is the collector process's own resource usage.
+type CollectorStats struct {
+	MemoryBytes   uint64   `json:"memory_bytes"`
+	Goroutines    int      `json:"goroutines"`
+	CPUPercent    *float64 `json:"cpu_percent,omitempty"`
+	SkippedCycles uint64   `json:"skipped_cycles"`
+	// Set by the agent: this cycle's collection, and handing the previous
+	// cycle's payload to the sinks (unknown in the first cycle)
+	CollectionDurationMs int64  `json:"collection_duration_ms"`
+	SendDurationMs       *int64 `json:"send_duration_ms,omitempty"`
+}
+
+type DiskDevice struct {
+	Device            string   `json:"device"`
+	Mountpoint        string   `json:"mountpoint"`
+	Filesystem        string   `json:"filesystem"`
+	UsagePercent      float64  `json:"usage_percent"`
+	UsedBytes         uint64   `json:"used_bytes"`
+	TotalBytes        uint64   `json:"total_bytes"`
+	AvailableBytes    uint64   `json:"available_bytes"`
+	InodesUsedPercent *float64 `json:"inodes_used_percent,omitempty"`
+	InodesUsed        *uint64  `json:"inodes_used,omitempty"`
+	InodesTotal       *uint64  `json:"inodes_total,omitempty"`
+	ReadBytes         uint64   `json:"read_bytes,omitempty"`
+	WriteBytes        uint64   `json:"write_bytes,omitempty"`
+	ReadOps           uint64   `json:"read_ops,omitempty"`
+	WriteOps          uint64   `json:"write_ops,omitempty"`
+	ReadBytesDelta    *uint64  `json:"read_bytes_delta,omitempty"`
+	WriteBytesDelta   *uint64  `json:"write_bytes_delta,omitempty"`
+	ReadOpsDelta      *uint64  `json:"read_ops_delta,omitempty"`
+	WriteOpsDelta     *uint64  `json:"write_ops_delta,omitempty"`
+	ReadBytesPerSec   *float64 `json:"read_bytes_per_sec,omitempty"`
+	WriteBytesPerSec  *float64 `json:"write_bytes_per_sec,omitempty"`
+}
+
+type NetworkInterface struct {
+	Name          string   `json:"name"`
+	Loopback      bool     `json:"loopback,omitempty"`
+	RXBytes       uint64   `json:"rx_bytes,omitempty"`
+	TXBytes       uint64   `json:"tx_bytes,omitempty"`
+	RXPackets     uint64   `json:"rx_packets,omitempty"`
+	TXPackets     uint64   `json:"tx_packets,omitempty"`
+	RXErrors      uint64   `json:"rx_errors,omitempty"`
+	TXErrors      uint64   `json:"tx_errors,omitempty"`
+	RXDrops       uint64   `json:"rx_drops,omitempty"`
+	TXDrops       uint64   `json:"tx_drops,omitempty"`
+	RXBytesPerSec *float64 `json:"rx_bytes_per_sec,omitempty"`
+	TXBytesPerSec *float64 `json:"tx_bytes_per_sec,omitempty"`
+}
+
+type SensorTemp struct {
+	SensorKey string   `json:"sensor_key"`
+	Current   float64  `json:"current"`
+	High      *float64 `json:"high,omitempty"`
+	Critical  *float64 `json:"critical,omitempty"`
+}
+
+// SmartDevice is the SMART health of one physical disk, as reported by
+// smartctl. Attributes the drive doesn't report are omitted: reallocated
+// sectors only exist on ATA drives, media errors only on NVMe.
+type SmartDevice struct {
+	Device             string   `json:"device"`
+	Model              string   `json:"model,omitempty"`
+	Healthy            *bool    `json:"healthy,omitempty"`
+	TemperatureCelsius *float64 `json:"temperature_celsius,omitempty"`
+	PowerOnHours       *uint64  `json:"power_on_hours,omitempty"`
+	ReallocatedSectors *uint64  `json:"reallocated_sectors,omitempty"`
+	MediaErrors        *uint64  `json:"media_errors,omitempty"`
+}
+
+// ZFSPool is the capacity and health of one ZFS pool. Status is zpool's
+// explanation of what is wrong with a pool that isn't healthy.
+type ZFSPool struct {
+	Name                 string   `json:"name"`
+	Health               string   `json:"health"`
+	SizeBytes            uint64   `json:"size_bytes"`
+	AllocatedBytes       uint64   `json:"allocated_bytes"`
+	FreeBytes            uint64   `json:"free_bytes"`
+	CapacityPercent      float64  `json:"capacity_percent"`
+	FragmentationPercent *float64 `json:"fragmentation_percent,omitempty"`
+	Status               string   `json:"status,omitempty"`
+}
+
+// MDArray is the state of one Linux software RAID array. Devices counts the
+// members listed, Failed and Spare those marked (F) and (S). ExpectedDevices
+// and ActiveDevices come from the [n/m] status, which linear and raid0
+// arrays don't have. SyncAction is "resync", "recovery", "check", "repair"
+// or "reshape" while one is running or pending.
+type MDArray struct {
+	Device          string   `json:"device"`
+	State           string   `json:"state"`
+	Level           string   `json:"level,omitempty"`
+	Devices         int      `json:"devices"`
+	ExpectedDevices *int     `json:"expected_devices,omitempty"`
+	ActiveDevices   *int     `json:"active_devices,omitempty"`
+	FailedDevices   int      `json:"failed_devices"`
+	SpareDevices    int      `json:"spare_devices"`
+	Degraded        bool     `json:"degraded"`
+	SyncAction      string   `json:"sync_action,omitempty"`
+	SyncPercent     *float64 `json:"sync_percent,omitempty"`
+}
+
+// ProbeResult is the outcome of one reachability probe. Mode is how an ICMP
+// probe was sent: "raw" with a raw socket (root or CAP_NET_RAW) or
+// "unprivileged" with a ping socket (net.ipv4.ping_group_range).
+type ProbeResult struct {
+	Target     string   `json:"target"`
+	Type       string   `json:"type"`
+	Success    bool     `json:"success"`
+	LatencyMs  *float64 `json:"latency_ms,omitempty"`
+	StatusCode int      `json:"status_code,omitempty"`
+	Mode       string   `json:"mode,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// HTTPCheckResult is the outcome of one CRICKET_HTTP_CHECKS entry. The TLS
+// handshake time is only there for https URLs.
+type HTTPCheckResult struct {
+	URL            string   `json:"url"`
+	StatusCode     int      `json:"status_code,omitempty"`
+	ResponseTimeMs *float64 `json:"response_time_ms,omitempty"`
+	TLSHandshakeMs *float64 `json:"tls_handshake_ms,omitempty"`
+	Success        bool     `json:"success"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// CertificateInfo is the leaf certificate of one CRICKET_TLS_CHECKS entry.
+// A certificate that doesn't verify is still reported, with VerifyError set;
+// Error is set when no certificate could be obtained at all.
+type CertificateInfo struct {
+	Target          string `json:"target"`
+	Subject         string `json:"subject,omitempty"`
+	Issuer          string `json:"issuer,omitempty"`
+	NotAfter        string `json:"not_after,omitempty"`
+	DaysUntilExpiry *int   `json:"days_until_expiry,omitempty"`
+	VerifyError     string `json:"verify_error,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// UnitStatus is the state of one systemd unit as systemctl show reports it.
+// LoadState is "not-found" for a unit that doesn't exist. Restarts is the
+// number of automatic restarts, omitted on systemd versions before 235.
+type UnitStatus struct {
+	Name        string  `json:"name"`
+	LoadState   string  `json:"load_state"`
+	ActiveState string  `json:"active_state"`
+	SubState    string  `json:"sub_state"`
+	Restarts    *uint64 `json:"restarts,omitempty"`
+}
+
+// TCPConnectionStats counts TCP sockets (IPv4 and IPv6). States holds a
+// count for every state seen, keyed by the lowercased state name
+// ("established", "time_wait", ...); the common ones are always present.
+type TCPConnectionStats struct {
+	Total          int            `json:"total"`
+	ListeningPorts int            `json:"listening_ports"`
+	States         map[string]int `json:"states"`
+}
+
+// SpoolStats is a snapshot of the spool's counters.
+type SpoolStats struct {
+	Pending  int    `json:"pending_count"`
+	Spooled  uint64 `json:"spooled_count"`
+	Replayed uint64 `json:"replayed_count"`
+	Dropped  uint64 `json:"dropped_count"`
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package collectors
+
+import (
+	stdnet "net"
+	"os"
+	"syscall"
+)
+
+// listenPingSocket opens an unprivileged ICMP datagram ("ping") socket,
+// allowed for the groups in net.ipv4.ping_group_range on Linux.
+func listenPingSocket() (stdnet.PacketConn, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return stdnet.FilePacketConn(f)
+}
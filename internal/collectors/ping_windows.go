@@ -0,0 +1,13 @@
+package collectors
+
+import (
+	"errors"
+	stdnet "net"
+)
+
+// listenPingSocket is not available on Windows, which has no unprivileged
+// ICMP datagram sockets; ICMP probes need the raw socket there (an
+// administrator account).
+func listenPingSocket() (stdnet.PacketConn, error) {
+	return nil, errors.New("unprivileged ICMP sockets are not supported on Windows")
+}
@@ -0,0 +1,176 @@
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	stdnet "net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probesCollector checks that the CRICKET_PING_TARGETS are reachable.
+type probesCollector struct {
+	config *Config
+}
+
+func (c probesCollector) Name() string {
+	return "probes"
+}
+
+func (c probesCollector) timeout(base time.Duration) time.Duration {
+	return max(base, c.config.ProbeTimeout+timeoutMargin)
+}
+
+func (c probesCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	payload.Probes = runProbes(ctx, c.config.ProbeTargets, c.config.ProbeTimeout, c.config.UserAgent)
+	return nil
+}
+
+// runProbes probes all targets concurrently, each bounded by timeout. A
+// target is an http(s):// URL, a host:port to connect to over TCP, or a host
+// to ping.
+func runProbes(ctx context.Context, targets []string, timeout time.Duration, userAgent string) []ProbeResult {
+	results := make([]ProbeResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			results[i] = probe(ctx, target, userAgent)
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+func probe(ctx context.Context, target, userAgent string) ProbeResult {
+	result := ProbeResult{Target: target}
+	start := time.Now()
+	var err error
+	switch {
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		result.Type = "http"
+		result.StatusCode, err = probeHTTP(ctx, target, userAgent)
+	case strings.Contains(target, ":") && stdnet.ParseIP(target) == nil:
+		result.Type = "tcp"
+		var conn stdnet.Conn
+		var dialer stdnet.Dialer
+		if conn, err = dialer.DialContext(ctx, "tcp", target); err == nil {
+			conn.Close()
+		}
+	default:
+		result.Type = "icmp"
+		result.Mode, err = ping(ctx, target)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	latency := float64(time.Since(start).Microseconds()) / 1000
+	result.Success = true
+	result.LatencyMs = &latency
+	return result
+}
+
+// probeHTTP requests url and returns the status code; 4xx and 5xx responses
+// count as failures.
+func probeHTTP(ctx context.Context, url, userAgent string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// ping sends one ICMP echo request to host and waits for the reply. It uses
+// a raw socket when permitted and falls back to an unprivileged ping socket,
+// returning which one it used.
+func ping(ctx context.Context, host string) (string, error) {
+	addrs, err := stdnet.DefaultResolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return "", err
+	}
+	dst := addrs[0]
+
+	mode := "raw"
+	var conn stdnet.PacketConn
+	var addr stdnet.Addr = &stdnet.IPAddr{IP: dst}
+	conn, err = stdnet.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		mode = "unprivileged"
+		addr = &stdnet.UDPAddr{IP: dst}
+		if conn, err = listenPingSocket(); err != nil {
+			return mode, fmt.Errorf("no permission for raw or ping sockets: %w", err)
+		}
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// The kernel replaces the id of ping socket requests, so replies are
+	// matched on the sequence number and a random payload
+	id, seq := uint16(os.Getpid()), uint16(rand.Intn(1<<16))
+	token := make([]byte, 8)
+	binary.BigEndian.PutUint64(token, rand.Uint64())
+	if _, err := conn.WriteTo(icmpEchoRequest(id, seq, token), addr); err != nil {
+		return mode, err
+	}
+
+	reply := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(reply)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return mode, errors.New("no reply")
+			}
+			return mode, err
+		}
+		// Echo reply: type 0, then code, checksum, id, sequence and the data sent
+		msg := reply[:n]
+		if len(msg) >= 8+len(token) && msg[0] == 0 &&
+			binary.BigEndian.Uint16(msg[6:8]) == seq && bytes.Equal(msg[8:8+len(token)], token) {
+			return mode, nil
+		}
+	}
+}
+
+// icmpEchoRequest builds an ICMP echo request message.
+func icmpEchoRequest(id, seq uint16, data []byte) []byte {
+	msg := make([]byte, 8+len(data))
+	msg[0] = 8 // echo request
+	binary.BigEndian.PutUint16(msg[4:6], id)
+	binary.BigEndian.PutUint16(msg[6:8], seq)
+	copy(msg[8:], data)
+
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(msg[i:]))
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	binary.BigEndian.PutUint16(msg[2:4], ^uint16(sum))
+	return msg
+}
@@ -0,0 +1,351 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// maxTopProcesses caps CRICKET_TOP_PROCESSES so the process lists stay a
+// bounded part of the payload.
+const maxTopProcesses = 20
+
+// maxCmdlineLength caps the command line reported per process so a few
+// processes with huge argument lists can't bloat the payload.
+const maxCmdlineLength = 256
+
+// processesCollector counts processes by state, and their threads, and
+// gathers the top and watched processes.
+type processesCollector struct {
+	config   *Config
+	procCPU  cpuTracker
+	watchCPU cpuTracker
+}
+
+func (c *processesCollector) Name() string {
+	return "processes"
+}
+
+func (c *processesCollector) Reset() {
+	c.procCPU = cpuTracker{}
+	c.watchCPU = cpuTracker{}
+}
+
+func (c *processesCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	config := c.config
+	if !config.ProcessCounts && config.TopProcesses == 0 && len(config.WatchProcesses) == 0 {
+		return nil
+	}
+	processes, err := collectCall(ctx, "processes", process.ProcessesWithContext)
+	if err != nil {
+		return fmt.Errorf("failed to list processes: %w", err)
+	}
+	if config.ProcessCounts {
+		states := make([]processState, len(processes))
+		for i, proc := range processes {
+			states[i] = proc
+		}
+		countProcesses(ctx, states, payload)
+	}
+
+	if config.TopProcesses > 0 {
+		payload.TopProcesses, payload.TopProcessesByMemory = c.topProcesses(ctx, processes)
+	}
+	if len(config.WatchProcesses) > 0 {
+		payload.MonitoredProcesses = c.watchedProcesses(ctx, processes)
+	}
+	return nil
+}
+
+// processState is what the process counts need of a process, so tests can
+// stand in for *process.Process.
+type processState interface {
+	StatusWithContext(ctx context.Context) ([]string, error)
+	NumThreadsWithContext(ctx context.Context) (int32, error)
+}
+
+// countProcesses sets the process counts by state and the thread count.
+// Processes that exit while they're counted still count as processes.
+func countProcesses(ctx context.Context, processes []processState, payload *MetricsPayload) {
+	var running, sleeping, zombie, threads uint64
+	for _, proc := range processes {
+		if ctx.Err() != nil {
+			break
+		}
+		status, err := proc.StatusWithContext(ctx)
+		if err == nil && len(status) > 0 {
+			switch status[0] {
+			case "R", "Running":
+				running++
+			case "S", "Sleeping":
+				sleeping++
+			case "Z", "Zombie":
+				zombie++
+			}
+		}
+		if n, err := proc.NumThreadsWithContext(ctx); err == nil && n > 0 {
+			threads += uint64(n)
+		}
+	}
+	total := uint64(len(processes))
+	payload.TotalProcesses = &total
+	payload.TotalThreads = &threads
+	payload.RunningProcesses = &running
+	payload.SleepingProcesses = &sleeping
+	payload.ZombieProcesses = &zombie
+}
+
+// ProcessWatch is one CRICKET_WATCH_PROCESSES entry:
+//
+//	nginx                          processes named nginx
+//	java:elasticsearch             processes named java whose command line contains "elasticsearch"
+//	postgres=/run/postgres.pid     the process whose pid is in the pidfile
+type ProcessWatch struct {
+	Label   string
+	Name    string
+	Cmdline string
+	PIDFile string
+}
+
+// ParseProcessWatches reads CRICKET_WATCH_PROCESSES, a comma-separated list
+// of ProcessWatch entries.
+func ParseProcessWatches(value string) []ProcessWatch {
+	var watches []ProcessWatch
+	for _, entry := range SplitList(value) {
+		w := ProcessWatch{Label: entry}
+		if label, pidFile, ok := strings.Cut(entry, "="); ok {
+			w.Label, w.PIDFile = label, pidFile
+		} else if name, cmdline, ok := strings.Cut(entry, ":"); ok {
+			w.Name, w.Cmdline = name, cmdline
+		} else {
+			w.Name = entry
+		}
+		watches = append(watches, w)
+	}
+	return watches
+}
+
+// matches reports whether proc belongs to a name-based watch. Linux truncates
+// process names to 15 characters, so a truncated name matches its prefix.
+func (w ProcessWatch) matches(ctx context.Context, proc *process.Process) bool {
+	name, err := proc.NameWithContext(ctx)
+	if err != nil || (name != w.Name && !(len(name) == 15 && strings.HasPrefix(w.Name, name))) {
+		return false
+	}
+	if w.Cmdline == "" {
+		return true
+	}
+	cmdline, err := proc.CmdlineWithContext(ctx)
+	return err == nil && strings.Contains(cmdline, w.Cmdline)
+}
+
+// watchedProcesses reports one ProcessStatus per CRICKET_WATCH_PROCESSES
+// entry, in the configured order.
+func (c *processesCollector) watchedProcesses(ctx context.Context, processes []*process.Process) []ProcessStatus {
+	c.watchCPU.next()
+	statuses := make([]ProcessStatus, 0, len(c.config.WatchProcesses))
+	for _, w := range c.config.WatchProcesses {
+		var matched []*process.Process
+		if w.PIDFile != "" {
+			// A missing or stale pidfile reports the process as not running
+			if data, err := os.ReadFile(w.PIDFile); err == nil {
+				if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+					for _, proc := range processes {
+						if proc.Pid == int32(pid) {
+							matched = append(matched, proc)
+							break
+						}
+					}
+				}
+			}
+		} else {
+			for _, proc := range processes {
+				if ctx.Err() != nil {
+					break
+				}
+				if w.matches(ctx, proc) {
+					matched = append(matched, proc)
+				}
+			}
+		}
+
+		status := ProcessStatus{Name: w.Label}
+		var cpuTotal float64
+		cpuKnown := true
+		for _, proc := range matched {
+			memInfo, err := proc.MemoryInfoWithContext(ctx)
+			if err != nil {
+				// Exited since the process list was taken
+				continue
+			}
+			status.Count++
+			status.MemoryBytes += memInfo.RSS
+			if status.PID == 0 || proc.Pid < status.PID {
+				status.PID = proc.Pid
+			}
+			if times, err := proc.TimesWithContext(ctx); err == nil {
+				if percent := c.watchCPU.percent(proc.Pid, times.User+times.System); percent != nil {
+					cpuTotal += *percent
+				} else {
+					cpuKnown = false
+				}
+			}
+			if fds, err := proc.NumFDsWithContext(ctx); err == nil {
+				if status.OpenFDs == nil {
+					status.OpenFDs = new(int32)
+				}
+				*status.OpenFDs += fds
+				// The limit applies to each process on its own, so report the
+				// one closest to running out
+				if limit := nofileLimit(ctx, proc); limit > 0 {
+					usage := float64(fds) / float64(limit) * 100
+					if status.FDUsage == nil || usage > *status.FDUsage {
+						status.FDLimit = &limit
+						status.FDUsage = &usage
+					}
+				}
+			}
+		}
+		status.Running = status.Count > 0
+		if status.Running && cpuKnown {
+			status.CPUPercent = &cpuTotal
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// nofileLimit returns the soft limit on a process's open files, or 0 when
+// it can't be read or is unlimited.
+func nofileLimit(ctx context.Context, proc *process.Process) uint64 {
+	limits, err := proc.RlimitWithContext(ctx)
+	if err != nil {
+		return 0
+	}
+	for _, limit := range limits {
+		if limit.Resource == process.RLIMIT_NOFILE && limit.Soft > 0 && limit.Soft != math.MaxUint64 {
+			return limit.Soft
+		}
+	}
+	return 0
+}
+
+// cpuTracker turns the cumulative CPU time of processes into their usage
+// since the previous cycle.
+type cpuTracker struct {
+	prev, current     map[int32]float64
+	prevAt, currentAt time.Time
+}
+
+// next starts a new cycle, keeping the last one's samples as the baseline.
+func (t *cpuTracker) next() {
+	t.prev, t.prevAt = t.current, t.currentAt
+	t.current, t.currentAt = map[int32]float64{}, time.Now()
+}
+
+// percent records the total CPU seconds of pid for this cycle and returns
+// its usage since the previous one, or nil without a usable baseline (new
+// process, or a reused pid whose counter went backwards).
+func (t *cpuTracker) percent(pid int32, total float64) *float64 {
+	t.current[pid] = total
+	prevTotal, ok := t.prev[pid]
+	elapsed := t.currentAt.Sub(t.prevAt).Seconds()
+	if !ok || total < prevTotal || elapsed <= 0 {
+		return nil
+	}
+	percent := (total - prevTotal) / elapsed * 100
+	return &percent
+}
+
+// topProcesses returns the config.TopProcesses processes that used the most
+// CPU since the previous cycle and the ones with the most resident memory.
+// CPU usage needs a previous sample, so it is omitted for processes seen for
+// the first time (everything on the first cycle), which then rank last by
+// CPU. Processes that can't be read, because of permissions or because they
+// exited mid-scan, are skipped.
+func (c *processesCollector) topProcesses(ctx context.Context, processes []*process.Process) (byCPU, byMemory []ProcessInfo) {
+	type candidate struct {
+		proc *process.Process
+		info ProcessInfo
+	}
+
+	c.procCPU.next()
+	candidates := make([]candidate, 0, len(processes))
+	for _, proc := range processes {
+		if ctx.Err() != nil {
+			break
+		}
+		times, err := proc.TimesWithContext(ctx)
+		if err != nil {
+			continue
+		}
+		memInfo, err := proc.MemoryInfoWithContext(ctx)
+		if err != nil {
+			continue
+		}
+
+		info := ProcessInfo{
+			PID:        proc.Pid,
+			CPUPercent: c.procCPU.percent(proc.Pid, times.User+times.System),
+			MemoryRSS:  memInfo.RSS,
+		}
+		candidates = append(candidates, candidate{proc: proc, info: info})
+	}
+
+	limit := c.config.TopProcesses
+	if limit > maxTopProcesses {
+		limit = maxTopProcesses
+	}
+	cpuOf := func(info ProcessInfo) float64 {
+		if info.CPUPercent == nil {
+			return -1
+		}
+		return *info.CPUPercent
+	}
+	// Names, users and command lines are only looked up for the processes
+	// reported, and only once for those in both lists
+	details := map[int32]ProcessInfo{}
+	top := func(less func(a, b ProcessInfo) bool) []ProcessInfo {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return less(candidates[i].info, candidates[j].info)
+		})
+		n := limit
+		if len(candidates) < n {
+			n = len(candidates)
+		}
+		list := make([]ProcessInfo, 0, n)
+		for _, cand := range candidates[:n] {
+			info, ok := details[cand.info.PID]
+			if !ok {
+				info = cand.info
+				info.Name, _ = cand.proc.NameWithContext(ctx)
+				info.Username, _ = cand.proc.UsernameWithContext(ctx)
+				info.Cmdline, _ = cand.proc.CmdlineWithContext(ctx)
+				if len(info.Cmdline) > maxCmdlineLength {
+					info.Cmdline = info.Cmdline[:maxCmdlineLength]
+				}
+				details[info.PID] = info
+			}
+			list = append(list, info)
+		}
+		return list
+	}
+
+	byCPU = top(func(a, b ProcessInfo) bool {
+		if cpuOf(a) != cpuOf(b) {
+			return cpuOf(a) > cpuOf(b)
+		}
+		return a.MemoryRSS > b.MemoryRSS
+	})
+	byMemory = top(func(a, b ProcessInfo) bool {
+		return a.MemoryRSS > b.MemoryRSS
+	})
+	return byCPU, byMemory
+}
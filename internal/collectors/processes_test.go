@@ -0,0 +1,66 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// stubProcess is a process with a fixed status and thread count.
+type stubProcess struct {
+	status  string
+	threads int32
+	err     error
+}
+
+func (p stubProcess) StatusWithContext(ctx context.Context) ([]string, error) {
+	return []string{p.status}, p.err
+}
+
+func (p stubProcess) NumThreadsWithContext(ctx context.Context) (int32, error) {
+	return p.threads, p.err
+}
+
+func TestCountProcesses(t *testing.T) {
+	processes := []processState{
+		stubProcess{status: "R", threads: 4},
+		stubProcess{status: "S", threads: 12},
+		stubProcess{status: "S", threads: 1},
+		stubProcess{status: "Z"},
+		stubProcess{status: "Z"},
+		stubProcess{status: "I", threads: 1},
+		// Exited while it was being counted
+		stubProcess{err: errors.New("no such process")},
+	}
+	var payload MetricsPayload
+	countProcesses(context.Background(), processes, &payload)
+
+	for _, tt := range []struct {
+		name  string
+		value *uint64
+		want  uint64
+	}{
+		{"total_processes", payload.TotalProcesses, 7},
+		{"running_processes", payload.RunningProcesses, 1},
+		{"sleeping_processes", payload.SleepingProcesses, 2},
+		{"zombie_processes", payload.ZombieProcesses, 2},
+		{"total_threads", payload.TotalThreads, 18},
+	} {
+		if tt.value == nil {
+			t.Errorf("%s not set", tt.name)
+		} else if *tt.value != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, *tt.value, tt.want)
+		}
+	}
+}
+
+func TestProcessCountsOff(t *testing.T) {
+	var payload MetricsPayload
+	c := &processesCollector{config: &Config{}}
+	if err := c.Collect(context.Background(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.TotalProcesses != nil || payload.TotalThreads != nil || payload.ZombieProcesses != nil {
+		t.Error("process counts set with CRICKET_COLLECT_PROCESS_COUNTS=false")
+	}
+}
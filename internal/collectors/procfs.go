@@ -0,0 +1,298 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// fileDescriptorsCollector reports the system-wide file handle usage from
+// /proc/sys/fs/file-nr. The fields are left out where that file doesn't exist.
+type fileDescriptorsCollector struct{}
+
+func (fileDescriptorsCollector) Name() string {
+	return "filedescriptors"
+}
+
+func (fileDescriptorsCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	data, err := os.ReadFile(filepath.Join(procPath(), "sys", "fs", "file-nr"))
+	if err != nil {
+		return nil
+	}
+	allocated, max, err := parseFileNr(string(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse file-nr: %w", err)
+	}
+	payload.FDAllocated = &allocated
+	payload.FDMax = &max
+	if max > 0 {
+		percent := float64(allocated) / float64(max) * 100
+		payload.FDUsagePercent = &percent
+	}
+	return nil
+}
+
+// PressureField is where one line of a /proc/pressure file goes in the payload.
+type PressureField struct {
+	Resource, Kind       string
+	Avg10, Avg60, Avg300 **float64
+	Total                **uint64
+}
+
+// PressureFields lists the payload fields of every /proc/pressure line.
+func PressureFields(payload *MetricsPayload) []PressureField {
+	return []PressureField{
+		{"cpu", "some", &payload.PSICPUSomeAvg10, &payload.PSICPUSomeAvg60, &payload.PSICPUSomeAvg300, &payload.PSICPUSomeTotal},
+		{"cpu", "full", &payload.PSICPUFullAvg10, &payload.PSICPUFullAvg60, &payload.PSICPUFullAvg300, &payload.PSICPUFullTotal},
+		{"memory", "some", &payload.PSIMemorySomeAvg10, &payload.PSIMemorySomeAvg60, &payload.PSIMemorySomeAvg300, &payload.PSIMemorySomeTotal},
+		{"memory", "full", &payload.PSIMemoryFullAvg10, &payload.PSIMemoryFullAvg60, &payload.PSIMemoryFullAvg300, &payload.PSIMemoryFullTotal},
+		{"io", "some", &payload.PSIIOSomeAvg10, &payload.PSIIOSomeAvg60, &payload.PSIIOSomeAvg300, &payload.PSIIOSomeTotal},
+		{"io", "full", &payload.PSIIOFullAvg10, &payload.PSIIOFullAvg60, &payload.PSIIOFullAvg300, &payload.PSIIOFullTotal},
+	}
+}
+
+// pressureStats is one line of a /proc/pressure file.
+type pressureStats struct {
+	Avg10, Avg60, Avg300 float64
+	Total                uint64
+}
+
+// pressureCollector reads /proc/pressure/{cpu,memory,io}. Kernels without
+// PSI and unprivileged containers can't read them, so the fields are just
+// left out then.
+type pressureCollector struct{}
+
+func (pressureCollector) Name() string {
+	return "pressure"
+}
+
+func (pressureCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	var errs []error
+	parsed := make(map[string]map[string]pressureStats)
+	for _, field := range PressureFields(payload) {
+		stats, ok := parsed[field.Resource]
+		if !ok {
+			data, err := os.ReadFile(filepath.Join(procPath(), "pressure", field.Resource))
+			if err == nil {
+				stats, err = parsePressure(string(data))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to parse %s pressure: %w", field.Resource, err))
+				}
+			}
+			parsed[field.Resource] = stats
+		}
+		line, ok := stats[field.Kind]
+		if !ok {
+			continue
+		}
+		avg10, avg60, avg300, total := line.Avg10, line.Avg60, line.Avg300, line.Total
+		*field.Avg10, *field.Avg60, *field.Avg300, *field.Total = &avg10, &avg60, &avg300, &total
+	}
+	return errors.Join(errs...)
+}
+
+// parsePressure parses a /proc/pressure file, keyed by the "some" or "full"
+// at the start of each line, e.g.
+//
+//	some avg10=0.12 avg60=0.05 avg300=0.01 total=123456
+func parsePressure(data string) (map[string]pressureStats, error) {
+	stats := make(map[string]pressureStats)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var entry pressureStats
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("malformed field %q", field)
+			}
+			var err error
+			switch key {
+			case "avg10":
+				entry.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				entry.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				entry.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				entry.Total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+		stats[fields[0]] = entry
+	}
+	return stats, nil
+}
+
+// mdraidCollector reports the software RAID arrays in /proc/mdstat, which
+// only exists when the md driver is loaded.
+type mdraidCollector struct{}
+
+func (mdraidCollector) Name() string {
+	return "mdraid"
+}
+
+func (mdraidCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	data, err := os.ReadFile(filepath.Join(procPath(), "mdstat"))
+	if err != nil {
+		return nil
+	}
+	payload.MDArrays = parseMDStat(string(data))
+	return nil
+}
+
+var (
+	// md0 : active raid1 sdb1[1] sda1[0]
+	mdDeviceLine = regexp.MustCompile(`^(md\S*)\s*:\s*(\S+)\s*(.*)$`)
+	// sdb1[1](F)
+	mdMember = regexp.MustCompile(`^\S+\[\d+\](\([A-Z]\))?$`)
+	// 1953382464 blocks super 1.2 [2/1] [U_]
+	mdDeviceCounts = regexp.MustCompile(`\[(\d+)/(\d+)\]`)
+	// [==>......]  recovery = 12.6% (37043392/293039104) finish=127.5min, or resync=DELAYED
+	mdSyncProgress = regexp.MustCompile(`\b(resync|recovery|check|repair|reshape)\s*=\s*(?:([\d.]+)%|\S+)`)
+)
+
+// parseMDStat parses /proc/mdstat: a line per array naming its state, level
+// and members, followed by indented lines with the [n/m] device counts and
+// any running resync, recovery, check or reshape.
+func parseMDStat(data string) []MDArray {
+	var arrays []MDArray
+	var current *MDArray
+	for _, line := range strings.Split(data, "\n") {
+		if m := mdDeviceLine.FindStringSubmatch(line); m != nil {
+			arrays = append(arrays, MDArray{Device: m[1], State: m[2]})
+			current = &arrays[len(arrays)-1]
+			for _, field := range strings.Fields(m[3]) {
+				switch {
+				case strings.HasPrefix(field, "("):
+					// (auto-read-only) and (read-only) markers
+				case mdMember.MatchString(field):
+					current.Devices++
+					if strings.HasSuffix(field, "(F)") {
+						current.FailedDevices++
+					} else if strings.HasSuffix(field, "(S)") {
+						current.SpareDevices++
+					}
+				case current.Level == "":
+					current.Level = field
+				}
+			}
+			continue
+		}
+		if current == nil || strings.TrimLeft(line, " \t") == line {
+			// "Personalities :", "unused devices:" and blank lines
+			if strings.TrimSpace(line) == "" {
+				current = nil
+			}
+			continue
+		}
+		if m := mdDeviceCounts.FindStringSubmatch(line); m != nil {
+			expected, _ := strconv.Atoi(m[1])
+			active, _ := strconv.Atoi(m[2])
+			current.ExpectedDevices, current.ActiveDevices = &expected, &active
+		}
+		if m := mdSyncProgress.FindStringSubmatch(line); m != nil {
+			current.SyncAction = m[1]
+			if percent, err := strconv.ParseFloat(m[2], 64); err == nil {
+				current.SyncPercent = &percent
+			}
+		}
+	}
+
+	for i := range arrays {
+		array := &arrays[i]
+		array.Degraded = array.FailedDevices > 0 ||
+			(array.ActiveDevices != nil && *array.ActiveDevices < *array.ExpectedDevices)
+	}
+	return arrays
+}
+
+// parseFileNr parses the contents of /proc/sys/fs/file-nr: the number of
+// allocated file handles, the number of allocated but unused ones (always 0
+// since Linux 2.6) and the maximum.
+func parseFileNr(data string) (allocated, max uint64, err error) {
+	fields := strings.Fields(data)
+	if len(fields) != 3 {
+		return 0, 0, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	if allocated, err = strconv.ParseUint(fields[0], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid allocated count: %w", err)
+	}
+	if max, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid maximum: %w", err)
+	}
+	return allocated, max, nil
+}
+
+// tcpStates maps the state codes in /proc/net/tcp to the names gopsutil uses.
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// readProcNetTCP lists the TCP sockets in /proc/net/tcp and tcp6 with only
+// their state and local port, which is all counting them needs.
+func readProcNetTCP() ([]net.ConnectionStat, error) {
+	var conns []net.ConnectionStat
+	var read bool
+	for _, name := range []string{"tcp", "tcp6"} {
+		data, err := os.ReadFile(filepath.Join(procPath(), "net", name))
+		if err != nil {
+			// Kernels without IPv6 have no tcp6
+			continue
+		}
+		read = true
+		conns = append(conns, parseProcNetTCP(string(data))...)
+	}
+	if !read {
+		return nil, errors.New("no TCP socket table found")
+	}
+	return conns, nil
+}
+
+// parseProcNetTCP parses a /proc/net/tcp table, a header line followed by a
+// line per socket, e.g.
+//
+//	0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 1234 ...
+func parseProcNetTCP(data string) []net.ConnectionStat {
+	var conns []net.ConnectionStat
+	lines := strings.Split(data, "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		var conn net.ConnectionStat
+		conn.Status = tcpStates[fields[3]]
+		if conn.Status == "" {
+			conn.Status = "NONE"
+		}
+		if i := strings.LastIndex(fields[1], ":"); i >= 0 {
+			if port, err := strconv.ParseUint(fields[1][i+1:], 16, 32); err == nil {
+				conn.Laddr.Port = uint32(port)
+			}
+		}
+		conns = append(conns, conn)
+	}
+	return conns
+}
@@ -0,0 +1,206 @@
+package collectors
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestParsePressure(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]pressureStats
+		wantErr bool
+	}{
+		{
+			name: "some and full",
+			data: "some avg10=1.50 avg60=0.75 avg300=0.10 total=123456\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=42\n",
+			want: map[string]pressureStats{
+				"some": {Avg10: 1.5, Avg60: 0.75, Avg300: 0.1, Total: 123456},
+				"full": {Total: 42},
+			},
+		},
+		{
+			// /proc/pressure/cpu has no "full" line before Linux 5.13
+			name: "some only",
+			data: "some avg10=0.12 avg60=0.05 avg300=0.01 total=987\n",
+			want: map[string]pressureStats{
+				"some": {Avg10: 0.12, Avg60: 0.05, Avg300: 0.01, Total: 987},
+			},
+		},
+		{
+			name: "unknown fields are ignored",
+			data: "some avg10=0.12 avg600=9.99 total=1\n",
+			want: map[string]pressureStats{"some": {Avg10: 0.12, Total: 1}},
+		},
+		{
+			name: "empty",
+			data: "",
+			want: map[string]pressureStats{},
+		},
+		{name: "field without value", data: "some avg10 total=1\n", wantErr: true},
+		{name: "invalid average", data: "some avg10=high total=1\n", wantErr: true},
+		{name: "negative total", data: "some avg10=0.00 total=-1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePressure(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePressure() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePressure() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMDStat(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name string
+		data string
+		want []MDArray
+	}{
+		{
+			name: "no arrays",
+			data: "Personalities : \nunused devices: <none>\n",
+		},
+		{
+			name: "healthy mirror",
+			data: `Personalities : [raid1]
+md0 : active raid1 sdb1[1] sda1[0]
+      1953382464 blocks super 1.2 [2/2] [UU]
+      bitmap: 0/15 pages [0KB], 65536KB chunk
+
+unused devices: <none>
+`,
+			want: []MDArray{
+				{Device: "md0", State: "active", Level: "raid1", Devices: 2, ExpectedDevices: intPtr(2), ActiveDevices: intPtr(2)},
+			},
+		},
+		{
+			name: "failed member and spare, recovering",
+			data: `Personalities : [raid5]
+md1 : active raid5 sdd1[3](S) sdc1[2](F) sdb1[1] sda1[0]
+      585674752 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [UU_]
+      [==>..................]  recovery = 12.6% (37043392/292837376) finish=127.5min speed=33440K/sec
+
+unused devices: <none>
+`,
+			want: []MDArray{
+				{Device: "md1", State: "active", Level: "raid5", Devices: 4, ExpectedDevices: intPtr(3), ActiveDevices: intPtr(2),
+					FailedDevices: 1, SpareDevices: 1, Degraded: true, SyncAction: "recovery", SyncPercent: floatPtr(12.6)},
+			},
+		},
+		{
+			name: "delayed resync and read-only marker",
+			data: `Personalities : [raid1] [raid10]
+md2 : active (auto-read-only) raid1 sdf1[1] sde1[0]
+      976630464 blocks super 1.2 [2/2] [UU]
+        resync=DELAYED
+
+md3 : inactive sdg1[0](S)
+      976630464 blocks super 1.2
+
+unused devices: <none>
+`,
+			want: []MDArray{
+				{Device: "md2", State: "active", Level: "raid1", Devices: 2, ExpectedDevices: intPtr(2), ActiveDevices: intPtr(2), SyncAction: "resync"},
+				{Device: "md3", State: "inactive", Devices: 1, SpareDevices: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMDStat(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMDStat() = %s, want %s", mdArraysString(got), mdArraysString(tt.want))
+			}
+		})
+	}
+}
+
+// mdArraysString shows the arrays with their pointer fields dereferenced.
+func mdArraysString(arrays []MDArray) string {
+	data, _ := json.Marshal(arrays)
+	return string(data)
+}
+
+func TestParseFileNr(t *testing.T) {
+	tests := []struct {
+		data          string
+		wantAllocated uint64
+		wantMax       uint64
+		wantErr       bool
+	}{
+		{data: "3424\t0\t9223372036854775807\n", wantAllocated: 3424, wantMax: 9223372036854775807},
+		{data: "1056 0 98304", wantAllocated: 1056, wantMax: 98304},
+		{data: "", wantErr: true},
+		{data: "1056 0", wantErr: true},
+		{data: "1056 0 98304 7", wantErr: true},
+		{data: "many 0 98304", wantErr: true},
+		{data: "1056 0 -1", wantErr: true},
+	}
+	for _, tt := range tests {
+		allocated, max, err := parseFileNr(tt.data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFileNr(%q) error = %v, want error %v", tt.data, err, tt.wantErr)
+			continue
+		}
+		if allocated != tt.wantAllocated || max != tt.wantMax {
+			t.Errorf("parseFileNr(%q) = %d, %d, want %d, %d", tt.data, allocated, max, tt.wantAllocated, tt.wantMax)
+		}
+	}
+}
+
+func TestParseProcNetTCP(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []net.ConnectionStat
+	}{
+		{
+			name: "header only",
+			data: "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n",
+		},
+		{
+			name: "tcp",
+			data: `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 12345 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1F90 0100007F:D431 01 00000000:00000000 00:00000000 00000000  1000        0 23456 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:D431 0100007F:1F90 06 00000000:00000000 03:00000D1A 00000000     0        0 0 3 0000000000000000
+`,
+			want: []net.ConnectionStat{
+				{Status: "LISTEN", Laddr: net.Addr{Port: 22}},
+				{Status: "ESTABLISHED", Laddr: net.Addr{Port: 8080}},
+				{Status: "TIME_WAIT", Laddr: net.Addr{Port: 54321}},
+			},
+		},
+		{
+			name: "tcp6 and an unknown state",
+			data: `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:01BB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 34567 1
+   1: 00000000000000000000000001000000:0050 00000000000000000000000001000000:C350 0C 00000000:00000000 00:00000000 00000000     0        0 45678 1
+`,
+			want: []net.ConnectionStat{
+				{Status: "LISTEN", Laddr: net.Addr{Port: 443}},
+				{Status: "NONE", Laddr: net.Addr{Port: 80}},
+			},
+		},
+		{
+			name: "truncated line",
+			data: "  sl  local_address rem_address   st\n   0: 00000000:0016\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseProcNetTCP(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProcNetTCP() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
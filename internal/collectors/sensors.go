@@ -0,0 +1,113 @@
+package collectors
+
+import (
+	"context"
+	"runtime"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// temperaturesCollector reads the temperature sensors. VMs usually have
+// none, or return an error, which isn't worth a log line every cycle.
+type temperaturesCollector struct {
+	config *Config
+	// Stand-in for SensorsTemperaturesWithContext in tests
+	sensors func(ctx context.Context) ([]host.TemperatureStat, error)
+}
+
+func (c temperaturesCollector) Name() string {
+	return "temperatures"
+}
+
+func (c temperaturesCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	sensors := host.SensorsTemperaturesWithContext
+	if c.sensors != nil {
+		sensors = c.sensors
+	}
+	temps, _ := collectCall(ctx, "temperatures", sensors)
+	payload.Temperatures = sensorTemps(temps, c.config.TempSensors)
+	payload.CPUTemperatureCelsius = cpuTemperature(temps)
+	return nil
+}
+
+// sensorTemps converts the temperature readings of the sensors filter
+// matches, dropping the high and critical thresholds that some drivers
+// report as 0 or negative when they have none.
+func sensorTemps(temps []host.TemperatureStat, filter GlobFilter) []SensorTemp {
+	var readings []SensorTemp
+	for _, t := range temps {
+		// macOS reads every SMC key gopsutil knows of, and the ones the Mac
+		// doesn't have (all of them on Apple silicon) come back as 0
+		if runtime.GOOS == "darwin" && t.Temperature == 0 {
+			continue
+		}
+		if !filter.Match(strings.ToLower(t.SensorKey)) {
+			continue
+		}
+		reading := SensorTemp{SensorKey: t.SensorKey, Current: t.Temperature}
+		if high := t.High; high > 0 {
+			reading.High = &high
+		}
+		if critical := t.Critical; critical > 0 {
+			reading.Critical = &critical
+		}
+		readings = append(readings, reading)
+	}
+	return readings
+}
+
+// NewSensorFilter builds the sensor key filter from CRICKET_TEMP_SENSORS and
+// CRICKET_TEMP_SENSORS_EXCLUDE. Keys are matched in lower case, and a
+// pattern without wildcards matches the keys it's a prefix of, so
+// "coretemp" covers every coretemp sensor.
+func NewSensorFilter(include, exclude string) GlobFilter {
+	patterns := func(value string) []string {
+		var patterns []string
+		for _, pattern := range SplitList(value) {
+			pattern = strings.ToLower(pattern)
+			if !strings.ContainsAny(pattern, `*?[\`) {
+				pattern += "*"
+			}
+			patterns = append(patterns, pattern)
+		}
+		return patterns
+	}
+	return GlobFilter{Include: patterns(include), Exclude: patterns(exclude)}
+}
+
+// cpuSensorPrefixes are the sensors that measure the CPU package, best
+// first: Intel coretemp, AMD k10temp, then SoC sensors (e.g. Raspberry Pi)
+// and the CPU diode, heatsink and proximity SMC keys of Intel Macs.
+// Per-core sensors are the fallback when there's no package sensor.
+var cpuSensorPrefixes = []string{
+	"coretemp_package",
+	"k10temp_tctl",
+	"k10temp_tdie",
+	"cpu_thermal",
+	"soc_thermal",
+	"tc0d",
+	"tc0h",
+	"tc0p",
+	"coretemp_core",
+}
+
+// cpuTemperature picks the CPU temperature from the sensor readings, the
+// hottest of the best kind of sensor present, or nil when there is none.
+func cpuTemperature(temps []host.TemperatureStat) *float64 {
+	for _, prefix := range cpuSensorPrefixes {
+		var hottest *float64
+		for _, t := range temps {
+			if strings.HasPrefix(strings.ToLower(t.SensorKey), prefix) && t.Temperature > 0 {
+				if hottest == nil || t.Temperature > *hottest {
+					current := t.Temperature
+					hottest = &current
+				}
+			}
+		}
+		if hottest != nil {
+			return hottest
+		}
+	}
+	return nil
+}
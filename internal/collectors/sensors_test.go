@@ -0,0 +1,87 @@
+package collectors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+func TestTemperaturesCollector(t *testing.T) {
+	temps := []host.TemperatureStat{
+		{SensorKey: "coretemp_package_id_0", Temperature: 55, High: 80, Critical: 100},
+		{SensorKey: "coretemp_core_0", Temperature: 52, High: 80, Critical: 100},
+		{SensorKey: "nvme_composite_wdc", Temperature: 41, High: 0, Critical: -273},
+		{SensorKey: "acpitz", Temperature: 27.8},
+		{SensorKey: "Iwlwifi_1", Temperature: 38},
+	}
+
+	tests := []struct {
+		name             string
+		include, exclude string
+		want             []string
+	}{
+		{name: "all", want: []string{"coretemp_package_id_0", "coretemp_core_0", "nvme_composite_wdc", "acpitz", "Iwlwifi_1"}},
+		{name: "prefix", include: "coretemp", want: []string{"coretemp_package_id_0", "coretemp_core_0"}},
+		{name: "glob", include: "*_core_*,nvme_*", want: []string{"coretemp_core_0", "nvme_composite_wdc"}},
+		{name: "exclude", exclude: "acpitz,iwlwifi*", want: []string{"coretemp_package_id_0", "coretemp_core_0", "nvme_composite_wdc"}},
+		{name: "exclude wins", include: "coretemp", exclude: "coretemp_core_*", want: []string{"coretemp_package_id_0"}},
+		{name: "any case", include: "IWLWIFI", want: []string{"Iwlwifi_1"}},
+		{name: "no match", include: "k10temp", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := temperaturesCollector{
+				config: &Config{TempSensors: NewSensorFilter(tt.include, tt.exclude)},
+				sensors: func(ctx context.Context) ([]host.TemperatureStat, error) {
+					return temps, nil
+				},
+			}
+			var payload MetricsPayload
+			if err := c.Collect(context.Background(), &payload); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, reading := range payload.Temperatures {
+				got = append(got, reading.SensorKey)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sensors = %v, want %v", got, tt.want)
+			}
+			// The CPU temperature is picked from every sensor, not only the reported ones
+			if payload.CPUTemperatureCelsius == nil || *payload.CPUTemperatureCelsius != 55 {
+				t.Errorf("CPU temperature = %v, want 55", payload.CPUTemperatureCelsius)
+			}
+		})
+	}
+}
+
+func TestSensorTempsDropsBogusThresholds(t *testing.T) {
+	temps := []host.TemperatureStat{
+		{SensorKey: "coretemp_package_id_0", Temperature: 55, High: 80, Critical: 100},
+		{SensorKey: "nvme_composite", Temperature: 41, High: 0, Critical: -273},
+	}
+	high, critical := 80.0, 100.0
+	want := []SensorTemp{
+		{SensorKey: "coretemp_package_id_0", Current: 55, High: &high, Critical: &critical},
+		{SensorKey: "nvme_composite", Current: 41},
+	}
+	if got := sensorTemps(temps, GlobFilter{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("sensorTemps() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTemperaturesCollectorWithoutSensors(t *testing.T) {
+	c := temperaturesCollector{
+		config:  &Config{},
+		sensors: func(ctx context.Context) ([]host.TemperatureStat, error) { return nil, nil },
+	}
+	var payload MetricsPayload
+	if err := c.Collect(context.Background(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Temperatures != nil || payload.CPUTemperatureCelsius != nil {
+		t.Errorf("temperatures %v, CPU %v, want both left out", payload.Temperatures, payload.CPUTemperatureCelsius)
+	}
+}
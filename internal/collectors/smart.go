@@ -0,0 +1,186 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// smartctlTimeout bounds each smartctl call, so a slow (e.g. USB) drive
+// can't stall the cycle.
+const smartctlTimeout = 10 * time.Second
+
+// smartDiskName matches the whole disks worth asking smartctl about; virtual
+// disks (vd*, xvd*), loop and device-mapper devices have no SMART data.
+var smartDiskName = regexp.MustCompile(`^(sd[a-z]+|hd[a-z]+|nvme\d+n\d+)$`)
+
+// smartDisks returns the physical disks behind the mounted partitions, e.g.
+// /dev/sda for /dev/sda1.
+func smartDisks(partitions []disk.PartitionStat) []string {
+	var disks []string
+	seen := map[string]bool{}
+	for _, partition := range partitions {
+		// Resolved the same way as for the I/O counters, the whole disk
+		// comes after the partition
+		for _, name := range ioCounterNames(partition.Device) {
+			if smartDiskName.MatchString(name) && !seen[name] {
+				seen[name] = true
+				disks = append(disks, "/dev/"+name)
+			}
+		}
+	}
+	return disks
+}
+
+// smartCollector runs smartctl on every physical disk in parallel. When none
+// of them can be opened (no root or CAP_SYS_RAWIO) the collector is turned
+// off with a single warning instead of failing every cycle.
+type smartCollector struct {
+	config *Config
+	// Read by the registry while a cycle's collection may still be running
+	enabled atomic.Bool
+}
+
+func (c *smartCollector) Name() string {
+	return "smart"
+}
+
+func (c *smartCollector) timeout(base time.Duration) time.Duration {
+	return max(base, smartctlTimeout+timeoutMargin)
+}
+
+func (c *smartCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	partitions, err := collectCall(ctx, "disk partitions", func(ctx context.Context) ([]disk.PartitionStat, error) {
+		return disk.PartitionsWithContext(ctx, false)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+	disks := smartDisks(partitions)
+	if len(disks) == 0 {
+		return nil
+	}
+
+	devices := make([]*SmartDevice, len(disks))
+	errs := make([]error, len(disks))
+	var wg sync.WaitGroup
+	for i, device := range disks {
+		wg.Add(1)
+		go func(i int, device string) {
+			defer wg.Done()
+			devices[i], errs[i] = smartctl(ctx, device)
+		}(i, device)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, device := range devices {
+		if device != nil {
+			payload.SmartDevices = append(payload.SmartDevices, *device)
+			continue
+		}
+		failed++
+		slog.Debug("Skipping SMART data", "disk", disks[i], "error", errs[i])
+	}
+	if failed == len(disks) {
+		slog.Warn("smartctl could not read any disk, disabling SMART reporting; it needs root or CAP_SYS_RAWIO", "error", errs[0])
+		c.enabled.Store(false)
+	}
+	return nil
+}
+
+// smartctlOutput is the part of `smartctl --json` output that is reported.
+type smartctlOutput struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String string `json:"string"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	ModelName   string `json:"model_name"`
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature *struct {
+		Current float64 `json:"current"`
+	} `json:"temperature"`
+	PowerOnTime *struct {
+		Hours uint64 `json:"hours"`
+	} `json:"power_on_time"`
+	ATASmartAttributes *struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value uint64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeHealth *struct {
+		MediaErrors uint64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+// smartctl runs `smartctl -a --json` on a device, bounded by smartctlTimeout.
+func smartctl(ctx context.Context, device string) (*SmartDevice, error) {
+	ctx, cancel := context.WithTimeout(ctx, smartctlTimeout)
+	defer cancel()
+	// The exit status is a bit mask that is non-zero for a failing disk too,
+	// so the JSON is parsed regardless and its exit_status checked instead
+	out, _ := exec.CommandContext(ctx, "smartctl", "-a", "--json", device).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("smartctl timed out after %s", smartctlTimeout)
+	}
+	return parseSmartctl(device, out)
+}
+
+// parseSmartctl turns smartctl JSON output into a SmartDevice.
+func parseSmartctl(device string, out []byte) (*SmartDevice, error) {
+	var result smartctlOutput
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse smartctl output: %w", err)
+	}
+	// Bits 0 and 1: the command line was invalid or the device couldn't be opened
+	if result.Smartctl.ExitStatus&0x3 != 0 {
+		if len(result.Smartctl.Messages) > 0 {
+			return nil, errors.New(result.Smartctl.Messages[0].String)
+		}
+		return nil, fmt.Errorf("smartctl exited with status %d", result.Smartctl.ExitStatus)
+	}
+
+	smart := &SmartDevice{Device: device, Model: result.ModelName}
+	if result.SmartStatus != nil {
+		passed := result.SmartStatus.Passed
+		smart.Healthy = &passed
+	}
+	if result.Temperature != nil {
+		temperature := result.Temperature.Current
+		smart.TemperatureCelsius = &temperature
+	}
+	if result.PowerOnTime != nil {
+		hours := result.PowerOnTime.Hours
+		smart.PowerOnHours = &hours
+	}
+	if result.ATASmartAttributes != nil {
+		for _, attr := range result.ATASmartAttributes.Table {
+			// Attribute 5 is Reallocated_Sector_Ct
+			if attr.ID == 5 {
+				sectors := attr.Raw.Value
+				smart.ReallocatedSectors = &sectors
+			}
+		}
+	}
+	if result.NVMeHealth != nil {
+		mediaErrors := result.NVMeHealth.MediaErrors
+		smart.MediaErrors = &mediaErrors
+	}
+	return smart, nil
+}
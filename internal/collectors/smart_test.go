@@ -0,0 +1,31 @@
+//go:build !windows && !darwin
+
+package collectors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestSmartDisks(t *testing.T) {
+	// Device names that don't exist here, so they're resolved by the naming
+	// conventions alone
+	partitions := []disk.PartitionStat{
+		{Device: "/dev/sdzz1", Mountpoint: "/"},
+		{Device: "/dev/sdzz2", Mountpoint: "/home"},
+		{Device: "/dev/nvme97n1p1", Mountpoint: "/data"},
+		{Device: "/dev/nvme97n1p2", Mountpoint: "/data2"},
+		{Device: "/dev/nvme98n1", Mountpoint: "/scratch"},
+		// No SMART data behind these
+		{Device: "/dev/vdzz1", Mountpoint: "/var"},
+		{Device: "/dev/mmcblk97p1", Mountpoint: "/boot"},
+		{Device: "/dev/dm-97", Mountpoint: "/srv"},
+		{Device: "/dev/loop97", Mountpoint: "/snap/core/1"},
+	}
+	want := []string{"/dev/sdzz", "/dev/nvme97n1", "/dev/nvme98n1"}
+	if got := smartDisks(partitions); !reflect.DeepEqual(got, want) {
+		t.Errorf("smartDisks() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemctlTimeout bounds each systemctl call, so a hung D-Bus can't stall
+// the cycle.
+const systemctlTimeout = 5 * time.Second
+
+// systemdRunning reports whether the host was booted with systemd, the same
+// check sd_booted(3) makes, and systemctl is available.
+func systemdRunning() bool {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return false
+	}
+	_, err := exec.LookPath("systemctl")
+	return err == nil
+}
+
+// runCommand runs a command bounded by timeout and returns its stdout.
+func runCommand(ctx context.Context, timeout time.Duration, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%s %s timed out after %s", name, args[0], timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %w", name, args[0], err)
+	}
+	return string(out), nil
+}
+
+// systemctl runs systemctl with the given arguments, bounded by
+// systemctlTimeout.
+func systemctl(ctx context.Context, args ...string) (string, error) {
+	return runCommand(ctx, systemctlTimeout, "systemctl", append(args, "--no-pager")...)
+}
+
+// systemdCollector counts failed units and reports the state of the units
+// in CRICKET_SYSTEMD_UNITS.
+type systemdCollector struct {
+	config *Config
+}
+
+func (c systemdCollector) Name() string {
+	return "systemd"
+}
+
+func (c systemdCollector) timeout(base time.Duration) time.Duration {
+	return max(base, systemctlTimeout+timeoutMargin)
+}
+
+func (c systemdCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	var errs []error
+	out, err := systemctl(ctx, "list-units", "--state=failed", "--all", "--plain", "--no-legend")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list failed systemd units: %w", err))
+	} else {
+		failed := 0
+		for _, line := range strings.Split(out, "\n") {
+			if strings.TrimSpace(line) != "" {
+				failed++
+			}
+		}
+		payload.FailedUnits = &failed
+	}
+
+	if len(c.config.SystemdUnits) > 0 {
+		args := append([]string{"show", "--property=Id,LoadState,ActiveState,SubState,NRestarts"}, c.config.SystemdUnits...)
+		out, err = systemctl(ctx, args...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to query systemd units: %w", err))
+		} else {
+			payload.SystemdUnits = parseUnitStatuses(out, c.config.SystemdUnits)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseUnitStatuses parses systemctl show output, one block of Key=value
+// lines per unit separated by blank lines, in the order the units were given.
+func parseUnitStatuses(out string, units []string) []UnitStatus {
+	var statuses []UnitStatus
+	for i, block := range strings.Split(strings.TrimSpace(out), "\n\n") {
+		if i >= len(units) {
+			break
+		}
+		// Named as configured, Id would turn "nginx" into "nginx.service"
+		status := UnitStatus{Name: units[i]}
+		for _, line := range strings.Split(block, "\n") {
+			key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+			switch key {
+			case "LoadState":
+				status.LoadState = value
+			case "ActiveState":
+				status.ActiveState = value
+			case "SubState":
+				status.SubState = value
+			case "NRestarts":
+				if restarts, err := strconv.ParseUint(value, 10, 64); err == nil {
+					status.Restarts = &restarts
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,136 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zpoolTimeout bounds each zpool call; a pool with a hung device can block
+// zpool commands indefinitely.
+const zpoolTimeout = 10 * time.Second
+
+// zfsCollector reports the pools from zpool list, with the explanation from
+// zpool status -x for any that aren't healthy.
+type zfsCollector struct{}
+
+func (zfsCollector) Name() string {
+	return "zfs"
+}
+
+func (zfsCollector) timeout(base time.Duration) time.Duration {
+	return max(base, zpoolTimeout+timeoutMargin)
+}
+
+func (zfsCollector) Collect(ctx context.Context, payload *MetricsPayload) error {
+	out, err := runCommand(ctx, zpoolTimeout, "zpool", "list", "-Hp", "-o", "name,size,alloc,free,cap,frag,health")
+	if err != nil {
+		return fmt.Errorf("failed to list ZFS pools: %w", err)
+	}
+	pools, err := parseZpoolList(out)
+	if err != nil {
+		return fmt.Errorf("failed to parse zpool list output: %w", err)
+	}
+
+	unhealthy := false
+	for _, pool := range pools {
+		if pool.Health != "ONLINE" {
+			unhealthy = true
+		}
+	}
+	if unhealthy {
+		if out, err := runCommand(ctx, zpoolTimeout, "zpool", "status", "-x"); err == nil {
+			statuses := parseZpoolStatus(out)
+			for i := range pools {
+				pools[i].Status = statuses[pools[i].Name]
+			}
+		}
+	}
+	payload.ZFSPools = pools
+	return nil
+}
+
+// parseZpoolList parses `zpool list -Hp -o name,size,alloc,free,cap,frag,health`:
+// one tab-separated line per pool with exact byte counts. Fragmentation is
+// "-" for pools that don't track it.
+func parseZpoolList(out string) ([]ZFSPool, error) {
+	var pools []ZFSPool
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("expected 7 fields, got %d in %q", len(fields), line)
+		}
+		pool := ZFSPool{Name: fields[0], Health: fields[6]}
+		var err error
+		if pool.SizeBytes, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid size for pool %s: %w", pool.Name, err)
+		}
+		if pool.AllocatedBytes, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid allocated bytes for pool %s: %w", pool.Name, err)
+		}
+		if pool.FreeBytes, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid free bytes for pool %s: %w", pool.Name, err)
+		}
+		if pool.CapacityPercent, err = strconv.ParseFloat(strings.TrimSuffix(fields[4], "%"), 64); err != nil {
+			return nil, fmt.Errorf("invalid capacity for pool %s: %w", pool.Name, err)
+		}
+		if frag, err := strconv.ParseFloat(strings.TrimSuffix(fields[5], "%"), 64); err == nil {
+			pool.FragmentationPercent = &frag
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// zpoolStatusField matches the right-aligned labels of zpool status output;
+// continuation lines are indented past them.
+var zpoolStatusField = regexp.MustCompile(`^ {0,6}([a-z]+):(.*)$`)
+
+// parseZpoolStatus extracts the "status:" explanation of each pool from
+// `zpool status -x`, which only describes pools with problems:
+//
+//	  pool: tank
+//	 state: DEGRADED
+//	status: One or more devices could not be used because the label is missing or
+//	        invalid.  Sufficient replicas exist for the pool to continue
+//	        functioning in a degraded state.
+//	action: Replace the device using 'zpool replace'.
+func parseZpoolStatus(out string) map[string]string {
+	statuses := map[string]string{}
+	var pool string
+	var status []string
+	inStatus := false
+	flush := func() {
+		if pool != "" && len(status) > 0 {
+			statuses[pool] = strings.Join(status, " ")
+		}
+		status = nil
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if m := zpoolStatusField.FindStringSubmatch(line); m != nil {
+			inStatus = false
+			switch m[1] {
+			case "pool":
+				flush()
+				pool = strings.TrimSpace(m[2])
+			case "status":
+				inStatus = true
+				status = append(status, strings.TrimSpace(m[2]))
+			}
+			continue
+		}
+		if trimmed := strings.TrimSpace(line); inStatus && trimmed != "" {
+			status = append(status, trimmed)
+		} else {
+			inStatus = false
+		}
+	}
+	flush()
+	return statuses
+}
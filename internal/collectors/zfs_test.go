@@ -0,0 +1,84 @@
+package collectors
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseZpoolList(t *testing.T) {
+	frag := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name    string
+		out     string
+		want    []ZFSPool
+		wantErr bool
+	}{
+		{
+			name: "healthy and degraded",
+			out: "rpool\t498216206336\t124554051584\t373662154752\t25\t7\tONLINE\n" +
+				"tank\t15998753177600\t13598940200960\t2399812976640\t85\t31\tDEGRADED\n",
+			want: []ZFSPool{
+				{Name: "rpool", Health: "ONLINE", SizeBytes: 498216206336, AllocatedBytes: 124554051584, FreeBytes: 373662154752, CapacityPercent: 25, FragmentationPercent: frag(7)},
+				{Name: "tank", Health: "DEGRADED", SizeBytes: 15998753177600, AllocatedBytes: 13598940200960, FreeBytes: 2399812976640, CapacityPercent: 85, FragmentationPercent: frag(31)},
+			},
+		},
+		{
+			// Older releases print percentages, and "-" for untracked fragmentation
+			name: "percent signs and no fragmentation",
+			out:  "backup\t1000\t500\t500\t50%\t-\tFAULTED\n",
+			want: []ZFSPool{{Name: "backup", Health: "FAULTED", SizeBytes: 1000, AllocatedBytes: 500, FreeBytes: 500, CapacityPercent: 50}},
+		},
+		{name: "no pools", out: "", want: nil},
+		{name: "blank lines", out: "\n  \n", want: nil},
+		{name: "missing fields", out: "rpool\t498216206336\t124554051584\tONLINE\n", wantErr: true},
+		{name: "space separated", out: "rpool 498216206336 124554051584 373662154752 25 7 ONLINE\n", wantErr: true},
+		{name: "bad size", out: "rpool\t464G\t116G\t348G\t25\t7\tONLINE\n", wantErr: true},
+		{name: "bad capacity", out: "rpool\t1000\t500\t500\thalf\t7\tONLINE\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseZpoolList(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseZpoolList() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseZpoolList() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseZpoolStatus(t *testing.T) {
+	out := `  pool: tank
+ state: DEGRADED
+status: One or more devices could not be used because the label is missing or
+        invalid.  Sufficient replicas exist for the pool to continue
+        functioning in a degraded state.
+action: Replace the device using 'zpool replace'.
+   see: https://openzfs.github.io/openzfs-docs/msg/ZFS-8000-4J
+config:
+
+        NAME        STATE     READ WRITE CKSUM
+        tank        DEGRADED     0     0     0
+          raidz1-0  DEGRADED     0     0     0
+            sda     ONLINE       0     0     0
+            sdb     UNAVAIL      0     0     0
+
+errors: No known data errors
+
+  pool: backup
+ state: SUSPENDED
+status: One or more devices are faulted in response to IO failures.
+action: Make sure the affected devices are connected, then run 'zpool clear'.
+`
+	want := map[string]string{
+		"tank":   "One or more devices could not be used because the label is missing or invalid.  Sufficient replicas exist for the pool to continue functioning in a degraded state.",
+		"backup": "One or more devices are faulted in response to IO failures.",
+	}
+	if got := parseZpoolStatus(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseZpoolStatus() = %q, want %q", got, want)
+	}
+	if got := parseZpoolStatus("all pools are healthy\n"); len(got) != 0 {
+		t.Errorf("parseZpoolStatus() of healthy pools = %q, want none", got)
+	}
+}
@@ -0,0 +1,54 @@
+package sender
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// ReadAPIKeyFile reads the API key from path, dropping the whitespace and
+// trailing newline secret managers tend to leave around it.
+func ReadAPIKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the API key: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("API key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// refreshAPIKey reads CRICKET_API_KEY_FILE again if it changed since it was
+// last read, or whatever its modification time with force, and reports
+// whether that gave a new key. A file that can't be read keeps the current
+// key, as secret managers may be halfway through replacing it.
+func (a *API) refreshAPIKey(force bool) bool {
+	path := a.config.APIKeyFile
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("Can't check the API key file, keeping the current key", "error", err)
+		return false
+	}
+	if !force && info.ModTime().Equal(a.keyModTime) {
+		return false
+	}
+	key, err := ReadAPIKeyFile(path)
+	if err != nil {
+		slog.Warn("Keeping the current API key", "error", err)
+		return false
+	}
+	a.keyModTime = info.ModTime()
+	if key == a.config.APIKey {
+		return false
+	}
+	AddSecrets(key)
+	a.config.APIKey = key
+	slog.Info("Loaded a new API key", "file", path, "key", MaskSecret(key))
+	return true
+}
@@ -0,0 +1,266 @@
+package sender
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cricket-collector/internal/collectors"
+)
+
+func TestAPIReusesConnections(t *testing.T) {
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	api, err := NewAPI(&Config{
+		APIBaseURL:      server.URL,
+		APIKey:          "test",
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		CollectInterval: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false); err != nil {
+			t.Fatalf("Send() = %v", err)
+		}
+	}
+	if n := connections.Load(); n != 1 {
+		t.Errorf("3 sends opened %d connections, want 1 kept alive", n)
+	}
+}
+
+func TestAPIUsesProxy(t *testing.T) {
+	type request struct{ host, path, auth string }
+	var proxied []request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A plain HTTP request through a proxy carries the absolute URL
+		proxied = append(proxied, request{r.URL.Host, r.URL.Path, r.Header.Get("Proxy-Authorization")})
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer proxy.Close()
+	var direct atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		direct.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	proxyURL := strings.Replace(proxy.URL, "http://", "http://cricket:secret@", 1)
+	send := func(baseURL, noProxy string) {
+		t.Helper()
+		api, err := NewAPI(&Config{
+			APIBaseURL:      baseURL,
+			APIKey:          "test",
+			IngestPath:      "/api/metrics/ingest",
+			IngestMethod:    http.MethodPost,
+			CollectInterval: 60,
+			ProxyURL:        proxyURL,
+			NoProxy:         noProxy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false); err != nil {
+			t.Fatalf("Send() to %s = %v", baseURL, err)
+		}
+	}
+
+	// The API host doesn't resolve, only the proxy can deliver it
+	send("http://ingest.cricket.invalid", "")
+	want := request{"ingest.cricket.invalid", "/api/metrics/ingest", "Basic Y3JpY2tldDpzZWNyZXQ="}
+	if len(proxied) != 1 || proxied[0] != want {
+		t.Fatalf("proxy got %+v, want %+v", proxied, want)
+	}
+
+	// NO_PROXY entries go direct
+	send(server.URL, "localhost,127.0.0.0/8")
+	if len(proxied) != 1 || direct.Load() != 1 {
+		t.Errorf("proxy got %d requests and the server %d, want the second one direct", len(proxied), direct.Load())
+	}
+}
+
+// writeCertPEM writes the server's certificate to a CA file.
+func writeCertPEM(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCustomCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	caFile := writeCertPEM(t, server)
+
+	for _, tt := range []struct {
+		name    string
+		caFile  string
+		wantErr bool
+	}{
+		{"system roots only", "", true},
+		{"CA file", caFile, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			api, err := NewAPI(&Config{
+				APIBaseURL:      server.URL,
+				APIKey:          "test",
+				IngestPath:      "/api/metrics/ingest",
+				IngestMethod:    http.MethodPost,
+				TLSCAFile:       tt.caFile,
+				CollectInterval: 60,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Send() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{"missing CA file", Config{TLSCAFile: filepath.Join(dir, "missing.pem")}, "failed to read CA file"},
+		{"CA file without certificates", Config{TLSCAFile: notPEM}, "no valid PEM certificates"},
+		{"certificate without key", Config{TLSCertFile: notPEM}, "must be set together"},
+		{"key without certificate", Config{TLSKeyFile: notPEM}, "must be set together"},
+		{"invalid key pair", Config{TLSCertFile: notPEM, TLSKeyFile: notPEM}, "failed to load client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTLSConfig(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newTLSConfig() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key, and
+// returns their paths and the certificate.
+func writeClientCert(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "collector"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestMutualTLS(t *testing.T) {
+	certFile, keyFile, cert := writeClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "collector" {
+			t.Errorf("request without the client certificate")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	caFile := writeCertPEM(t, server)
+
+	for _, tt := range []struct {
+		name              string
+		certFile, keyFile string
+		wantErr           bool
+	}{
+		{"without a client certificate", "", "", true},
+		{"with a client certificate", certFile, keyFile, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			api, err := NewAPI(&Config{
+				APIBaseURL:      server.URL,
+				APIKey:          "test",
+				IngestPath:      "/api/metrics/ingest",
+				IngestMethod:    http.MethodPost,
+				TLSCAFile:       caFile,
+				TLSCertFile:     tt.certFile,
+				TLSKeyFile:      tt.keyFile,
+				CollectInterval: 60,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Send() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
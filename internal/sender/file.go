@@ -0,0 +1,163 @@
+package sender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cricket-collector/internal/collectors"
+)
+
+// FileSink appends every payload as one line of JSON to a local file, for
+// hosts that can't reach any API; "cricket-collector replay" sends the file
+// later. The file is rotated by size, keeping a number of older ones as
+// <path>.1 (newest) to <path>.N.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	keep     int
+	sync     bool
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+}
+
+func NewFileSink(config Config) *FileSink {
+	return &FileSink{
+		path:     config.FileSink,
+		maxBytes: config.FileMaxBytes,
+		keep:     config.FileKeep,
+		sync:     config.FileSync,
+	}
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+// Send appends the payload, nothing is batched so flush is ignored.
+func (s *FileSink) Send(ctx context.Context, payload *collectors.MetricsPayload, flush bool) error {
+	line, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The file (or its whole directory) may have been moved away or deleted
+	// since the last write, e.g. by an operator carrying the data out
+	if s.file != nil {
+		if _, err := os.Stat(s.path); err != nil {
+			s.file.Close()
+			s.file = nil
+		}
+	}
+	if s.file != nil && s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		s.file.Close()
+		s.file = nil
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err == nil && s.sync {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		// Reopened on the next send
+		s.file.Close()
+		s.file = nil
+		return fmt.Errorf("failed to write %s: %w", s.path, err)
+	}
+	return nil
+}
+
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", s.path, err)
+	}
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat %s: %w", s.path, err)
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts <path> to <path>.1, <path>.1 to <path>.2 and so on,
+// deleting the oldest file beyond the number to keep.
+func (s *FileSink) rotate() error {
+	if s.keep < 1 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", s.path, err)
+		}
+		return nil
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.keep))
+	for i := s.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// Replay sends every payload in a file written by the file sink to the
+// API, in order and with their original timestamps. It stops at the first
+// payload that can't be delivered, reporting its line so the rest can be
+// sent later.
+func (a *API) Replay(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	sent, skipped := 0, 0
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var payload collectors.MetricsPayload
+			if jsonErr := json.Unmarshal(line, &payload); jsonErr != nil {
+				// Most likely the last line, cut short by a crash
+				slog.Warn("Skipping unreadable line", "path", path, "line", lineNumber, "error", jsonErr)
+				skipped++
+			} else if sendErr := a.sendMetrics(ctx, &payload); sendErr != nil {
+				return fmt.Errorf("failed to send line %d of %s (%d payloads sent before it): %w", lineNumber, path, sent, sendErr)
+			} else {
+				sent++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	slog.Info("Replayed payloads", "path", path, "sent", sent, "skipped", skipped)
+	return nil
+}
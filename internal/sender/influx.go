@@ -0,0 +1,185 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cricket-collector/internal/collectors"
+)
+
+// InfluxSink writes payloads to an InfluxDB bucket in line protocol, through
+// the /api/v2/write endpoint of InfluxDB 2.x (or the compatibility one of 1.8).
+type InfluxSink struct {
+	url       string
+	token     string
+	org       string
+	bucket    string
+	userAgent string
+	client    *http.Client
+}
+
+func NewInfluxSink(config Config) *InfluxSink {
+	return &InfluxSink{
+		url:       config.InfluxURL,
+		token:     config.InfluxToken,
+		org:       config.InfluxOrg,
+		bucket:    config.InfluxBucket,
+		userAgent: config.UserAgent,
+		// Not the API client: its proxy and client certificate are for Cricket
+		client: &http.Client{Timeout: config.HTTPTimeout},
+	}
+}
+
+func (s *InfluxSink) Name() string {
+	return "influx"
+}
+
+// Send writes the payload right away, nothing is batched so flush is ignored.
+func (s *InfluxSink) Send(ctx context.Context, payload *collectors.MetricsPayload, flush bool) error {
+	var body bytes.Buffer
+	if err := writeLineProtocol(&body, payload); err != nil {
+		return err
+	}
+
+	params := url.Values{"bucket": {s.bucket}, "precision": {"ns"}}
+	if s.org != "" {
+		params.Set("org", s.org)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url+"/api/v2/write?"+params.Encode(), &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	req.Header.Set("User-Agent", s.userAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errorBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		return fmt.Errorf("write failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(errorBody)))
+	}
+	return nil
+}
+
+// writeLineProtocol converts a payload into a "host" point with its
+// top-level numbers, plus a "disk" point per filesystem and a "network" point
+// per interface. All are tagged with server_name and hostname and stamped
+// with the collection time.
+func writeLineProtocol(w io.Writer, payload *collectors.MetricsPayload) error {
+	at, err := time.Parse(time.RFC3339, payload.Timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to parse payload timestamp: %w", err)
+	}
+	tags := []string{"server_name", payload.ServerName, "hostname", payload.Hostname}
+
+	// The host's strings (kernel version, CPU model, ...) would make poor tags
+	writePoint(w, "host", tags, reflect.ValueOf(*payload), false, at)
+	for _, device := range payload.DiskDevices {
+		writePoint(w, "disk", tags, reflect.ValueOf(device), true, at)
+	}
+	for _, iface := range payload.NetworkInterfaces {
+		writePoint(w, "network", tags, reflect.ValueOf(iface), true, at)
+	}
+	return nil
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// writePoint writes one line for the struct v. Numbers and booleans become
+// fields (integers with the "i" suffix, so a field keeps the same type from
+// one point to the next). With stringTags, non-empty strings become tags.
+func writePoint(w io.Writer, measurement string, tags []string, v reflect.Value, stringTags bool, at time.Time) {
+	var fields []string
+	eachField(v, func(name string, field reflect.Value) {
+		var value string
+		switch field.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f := field.Float()
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return
+			}
+			value = strconv.FormatFloat(f, 'f', -1, 64)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value = strconv.FormatInt(field.Int(), 10) + "i"
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if field.Uint() > math.MaxInt64 {
+				return
+			}
+			value = strconv.FormatUint(field.Uint(), 10) + "i"
+		case reflect.Bool:
+			value = strconv.FormatBool(field.Bool())
+		case reflect.String:
+			if stringTags && field.String() != "" {
+				tags = append(tags[:len(tags):len(tags)], name, field.String())
+			}
+			return
+		default:
+			return
+		}
+		fields = append(fields, influxKeyEscaper.Replace(name)+"="+value)
+	})
+	if len(fields) == 0 {
+		return
+	}
+
+	line := influxMeasurementEscaper.Replace(measurement)
+	for i := 0; i+1 < len(tags); i += 2 {
+		if tags[i+1] != "" {
+			line += "," + influxKeyEscaper.Replace(tags[i]) + "=" + influxKeyEscaper.Replace(tags[i+1])
+		}
+	}
+	fmt.Fprintf(w, "%s %s %d\n", line, strings.Join(fields, ","), at.UnixNano())
+}
+
+// eachField calls fn with the JSON name and value of every field of the
+// struct v, following pointers and skipping nil ones.
+func eachField(v reflect.Value, fn func(name string, field reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		field := v.Field(i)
+		if name == "" || name == "-" {
+			continue
+		}
+		if field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+		fn(name, field)
+	}
+}
+
+// numericValue returns the value of a number field as a float.
+func numericValue(field reflect.Value) (float64, bool) {
+	switch field.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := field.Float()
+		return f, !math.IsNaN(f) && !math.IsInf(f, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(field.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(field.Uint()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,297 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cricket-collector/internal/collectors"
+)
+
+// OTLPSink exports payloads as OpenTelemetry metrics over OTLP/HTTP, using
+// the protocol's JSON encoding so no OpenTelemetry libraries are needed.
+type OTLPSink struct {
+	endpoint  string
+	headers   map[string]string
+	compress  bool
+	version   string
+	userAgent string
+	client    *http.Client
+}
+
+func NewOTLPSink(config Config) *OTLPSink {
+	endpoint := config.OTLPEndpoint
+	// Like OTEL_EXPORTER_OTLP_ENDPOINT, a base URL gets the metrics path appended
+	if !strings.HasSuffix(endpoint, "/v1/metrics") {
+		endpoint += "/v1/metrics"
+	}
+	return &OTLPSink{
+		endpoint:  endpoint,
+		headers:   config.OTLPHeaders,
+		compress:  config.Compress,
+		version:   config.Version,
+		userAgent: config.UserAgent,
+		client:    &http.Client{Timeout: config.HTTPTimeout},
+	}
+}
+
+func (s *OTLPSink) Name() string {
+	return "otlp"
+}
+
+// Send exports the payload right away, nothing is batched so flush is ignored.
+func (s *OTLPSink) Send(ctx context.Context, payload *collectors.MetricsPayload, flush bool) error {
+	data, err := json.Marshal(otlpRequest(payload, s.version))
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+	body, contentEncoding := data, ""
+	if s.compress && len(data) > compressThreshold {
+		if body, err = gzipBody(data); err != nil {
+			return fmt.Errorf("failed to compress metrics: %w", err)
+		}
+		contentEncoding = "gzip"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	req.Header.Set("User-Agent", s.userAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export to %s: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errorBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		return fmt.Errorf("export failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(errorBody)))
+	}
+	return nil
+}
+
+// ParseOTLPHeaders reads CRICKET_OTLP_HEADERS, in the format of
+// OTEL_EXPORTER_OTLP_HEADERS: "name=value,name2=value2" with URL-encoded values.
+func ParseOTLPHeaders(value string) map[string]string {
+	headers := map[string]string{}
+	for _, entry := range collectors.SplitList(value) {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			slog.Warn("Ignoring CRICKET_OTLP_HEADERS entry without a value", "header", name)
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers
+}
+
+// otlpCounters are the payload fields holding counters that only grow
+// from boot, exported as monotonic sums starting at the boot time. The
+// per-interval deltas and rates derived from them are plain gauges.
+var otlpCounters = map[string]bool{
+	"disk_read_bytes":       true,
+	"disk_write_bytes":      true,
+	"disk_read_ops":         true,
+	"disk_write_ops":        true,
+	"disk_io_time":          true,
+	"network_rx_bytes":      true,
+	"network_tx_bytes":      true,
+	"network_rx_packets":    true,
+	"network_tx_packets":    true,
+	"network_rx_errors":     true,
+	"network_tx_errors":     true,
+	"disk.read_bytes":       true,
+	"disk.write_bytes":      true,
+	"disk.read_ops":         true,
+	"disk.write_ops":        true,
+	"network.rx_bytes":      true,
+	"network.tx_bytes":      true,
+	"network.rx_packets":    true,
+	"network.tx_packets":    true,
+	"network.rx_errors":     true,
+	"network.tx_errors":     true,
+	"network.rx_drops":      true,
+	"network.tx_drops":      true,
+	"psi_cpu_some_total":    true,
+	"psi_cpu_full_total":    true,
+	"psi_memory_some_total": true,
+	"psi_memory_full_total": true,
+	"psi_io_some_total":     true,
+	"psi_io_full_total":     true,
+}
+
+// The subset of the OTLP metrics data model the collector exports, in its
+// JSON form (64-bit integers and timestamps are strings).
+type otlpExportRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope     `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpMetric struct {
+	Name  string    `json:"name"`
+	Gauge *otlpData `json:"gauge,omitempty"`
+	Sum   *otlpData `json:"sum,omitempty"`
+}
+
+type otlpData struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality,omitempty"`
+	IsMonotonic            bool            `json:"isMonotonic,omitempty"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+	AsInt             string          `json:"asInt,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// otlpAggregationCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpAggregationCumulative = 2
+
+func otlpAttributes(pairs ...string) []otlpAttribute {
+	var attributes []otlpAttribute
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		attribute := otlpAttribute{Key: pairs[i]}
+		attribute.Value.StringValue = pairs[i+1]
+		attributes = append(attributes, attribute)
+	}
+	return attributes
+}
+
+// otlpRequest maps a payload to OTLP metrics named cricket.<field>, and
+// cricket.disk.<field> and cricket.network.<field> with one data point per
+// filesystem or interface. The resource carries host.name, service.name and
+// the payload's tags.
+func otlpRequest(payload *collectors.MetricsPayload, version string) otlpExportRequest {
+	resource := []string{
+		"host.name", payload.Hostname,
+		"service.name", "cricket-collector",
+		"service.version", version,
+		"cricket.server_name", payload.ServerName,
+	}
+	tagKeys := make([]string, 0, len(payload.Tags))
+	for key := range payload.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+	for _, key := range tagKeys {
+		resource = append(resource, key, payload.Tags[key])
+	}
+
+	now := time.Now()
+	if at, err := time.Parse(time.RFC3339, payload.Timestamp); err == nil {
+		now = at
+	}
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	bootAt, bootErr := time.Parse(time.RFC3339, payload.BootTime)
+	bootTime := strconv.FormatInt(bootAt.UnixNano(), 10)
+
+	var metrics []*otlpMetric
+	byName := map[string]*otlpMetric{}
+	add := func(group string, v reflect.Value, attributes []otlpAttribute) {
+		eachField(v, func(name string, field reflect.Value) {
+			point := otlpDataPoint{Attributes: attributes, TimeUnixNano: timestamp}
+			switch field.Kind() {
+			case reflect.Float32, reflect.Float64:
+				value, ok := numericValue(field)
+				if !ok {
+					return
+				}
+				point.AsDouble = &value
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				point.AsInt = strconv.FormatInt(field.Int(), 10)
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				point.AsInt = strconv.FormatUint(field.Uint(), 10)
+			default:
+				return
+			}
+
+			key := group + name
+			metric := byName[key]
+			if metric == nil {
+				metric = &otlpMetric{Name: "cricket." + key}
+				if otlpCounters[key] && bootErr == nil {
+					metric.Sum = &otlpData{AggregationTemporality: otlpAggregationCumulative, IsMonotonic: true}
+				} else {
+					metric.Gauge = &otlpData{}
+				}
+				byName[key] = metric
+				metrics = append(metrics, metric)
+			}
+			if metric.Sum != nil {
+				point.StartTimeUnixNano = bootTime
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, point)
+			} else {
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, point)
+			}
+		})
+	}
+	add("", reflect.ValueOf(*payload), nil)
+	for _, device := range payload.DiskDevices {
+		add("disk.", reflect.ValueOf(device), otlpAttributes("device", device.Device, "mountpoint", device.Mountpoint, "filesystem", device.Filesystem))
+	}
+	for _, iface := range payload.NetworkInterfaces {
+		add("network.", reflect.ValueOf(iface), otlpAttributes("interface", iface.Name))
+	}
+
+	return otlpExportRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: otlpAttributes(resource...)},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "cricket-collector", Version: version},
+			Metrics: metrics,
+		}},
+	}}}
+}
@@ -0,0 +1,304 @@
+package sender
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cricket-collector/internal/collectors"
+)
+
+// PrometheusExporter serves the most recently collected payload on /metrics
+// in the Prometheus text exposition format.
+type PrometheusExporter struct {
+	mu     sync.Mutex
+	latest *collectors.MetricsPayload
+}
+
+// Update replaces the snapshot served to scrapers.
+func (e *PrometheusExporter) Update(payload *collectors.MetricsPayload) {
+	e.mu.Lock()
+	e.latest = payload
+	e.mu.Unlock()
+}
+
+// Serve listens on addr and serves /metrics in the background. Binding
+// errors are returned so a bad address fails at startup.
+func (e *PrometheusExporter) Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Warn("Prometheus endpoint stopped", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	payload := e.latest
+	e.mu.Unlock()
+
+	// Nothing to report until the first collection finishes
+	if payload == nil {
+		http.Error(w, "no metrics collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	var b bytes.Buffer
+	writePrometheusMetrics(&b, payload)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// promWriter emits metric families, writing the HELP and TYPE header once
+// before the first sample of each family.
+type promWriter struct {
+	w    io.Writer
+	seen map[string]bool
+}
+
+func (p *promWriter) sample(name, kind, help string, value float64, labels ...string) {
+	if !p.seen[name] {
+		p.seen[name] = true
+		fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	fmt.Fprint(p.w, name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(labels[i+1])))
+		}
+		fmt.Fprintf(p.w, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(p.w, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (p *promWriter) gauge(name, help string, value float64, labels ...string) {
+	p.sample(name, "gauge", help, value, labels...)
+}
+
+func (p *promWriter) counter(name, help string, value float64, labels ...string) {
+	p.sample(name, "counter", help, value, labels...)
+}
+
+func (p *promWriter) optionalGauge(name, help string, value *float64, labels ...string) {
+	if value != nil {
+		p.gauge(name, help, *value, labels...)
+	}
+}
+
+func (p *promWriter) optionalCount(name, help string, value *uint64, labels ...string) {
+	if value != nil {
+		p.gauge(name, help, float64(*value), labels...)
+	}
+}
+
+func (p *promWriter) optionalCounter(name, help string, value *uint64, labels ...string) {
+	if value != nil {
+		p.counter(name, help, float64(*value), labels...)
+	}
+}
+
+// writePrometheusMetrics renders payload as cricket_* metrics. Per-core,
+// per-disk and per-interface values use core, device and interface labels;
+// cumulative I/O counters are only present when CRICKET_RAW_COUNTERS is on.
+func writePrometheusMetrics(w io.Writer, payload *collectors.MetricsPayload) {
+	p := &promWriter{w: w, seen: map[string]bool{}}
+
+	p.gauge("cricket_uptime_seconds", "Seconds since the host booted.", float64(payload.UptimeSeconds))
+	if bootTime, err := time.Parse(time.RFC3339, payload.BootTime); err == nil {
+		p.gauge("cricket_boot_time_seconds", "Host boot time as a Unix timestamp.", float64(bootTime.Unix()))
+	}
+	p.optionalCount("cricket_processes", "Number of processes.", payload.TotalProcesses)
+	p.optionalCount("cricket_zombie_processes", "Number of zombie processes.", payload.ZombieProcesses)
+	p.optionalCount("cricket_threads", "Number of threads over all processes.", payload.TotalThreads)
+
+	p.optionalGauge("cricket_cpu_usage_percent", "Overall CPU usage.", payload.CPUUsagePercent)
+	p.optionalGauge("cricket_cpu_user_percent", "CPU time spent in user mode.", payload.CPUUserPercent)
+	p.optionalGauge("cricket_cpu_system_percent", "CPU time spent in kernel mode.", payload.CPUSystemPercent)
+	p.optionalGauge("cricket_cpu_iowait_percent", "CPU time spent waiting for I/O.", payload.CPUIOWaitPercent)
+	p.optionalGauge("cricket_cpu_steal_percent", "CPU time stolen by the hypervisor.", payload.CPUStealPercent)
+	p.optionalGauge("cricket_cpu_irq_percent", "CPU time spent servicing interrupts.", payload.CPUIRQPercent)
+	p.optionalGauge("cricket_cpu_idle_percent", "CPU time spent idle.", payload.CPUIdlePercent)
+	for _, core := range payload.CPUPerCore {
+		p.gauge("cricket_cpu_core_usage_percent", "CPU usage per core.", core.UsagePercent, "core", strconv.Itoa(core.Core))
+	}
+	p.optionalGauge("cricket_load1", "1 minute load average.", payload.CPULoad1m)
+	p.optionalGauge("cricket_load5", "5 minute load average.", payload.CPULoad5m)
+	p.optionalGauge("cricket_load15", "15 minute load average.", payload.CPULoad15m)
+	p.optionalGauge("cricket_load1_per_core", "1 minute load average per CPU.", payload.CPULoad1mPerCore)
+	p.optionalGauge("cricket_load5_per_core", "5 minute load average per CPU.", payload.CPULoad5mPerCore)
+	p.optionalGauge("cricket_load15_per_core", "15 minute load average per CPU.", payload.CPULoad15mPerCore)
+	p.optionalGauge("cricket_cpu_temperature_celsius", "CPU package temperature.", payload.CPUTemperatureCelsius)
+
+	p.optionalGauge("cricket_memory_usage_percent", "Memory usage.", payload.MemoryUsagePercent)
+	p.optionalCount("cricket_memory_used_bytes", "Memory in use.", payload.MemoryUsedBytes)
+	p.optionalCount("cricket_memory_total_bytes", "Total memory.", payload.MemoryTotalBytes)
+	p.optionalCount("cricket_memory_available_bytes", "Memory available for new allocations.", payload.MemoryAvailableBytes)
+	p.optionalCount("cricket_memory_cached_bytes", "Memory used by the page cache.", payload.MemoryCachedBytes)
+	p.optionalCount("cricket_memory_buffers_bytes", "Memory used by kernel buffers.", payload.MemoryBuffersBytes)
+	if payload.CgroupMemoryLimitBytes != nil {
+		p.gauge("cricket_cgroup_memory_limit_bytes", "Memory limit of the collector's cgroup.", float64(*payload.CgroupMemoryLimitBytes))
+	}
+	p.optionalGauge("cricket_cgroup_cpu_quota", "CPUs the collector's cgroup may use.", payload.CgroupCPUQuota)
+	p.optionalCount("cricket_swap_used_bytes", "Swap in use.", payload.SwapUsedBytes)
+	p.optionalCount("cricket_swap_total_bytes", "Total swap.", payload.SwapTotalBytes)
+	p.optionalGauge("cricket_swap_in_bytes_per_second", "Bytes paged in from swap per second over the last interval.", payload.SwapInBytesPerSec)
+	p.optionalGauge("cricket_swap_out_bytes_per_second", "Bytes paged out to swap per second over the last interval.", payload.SwapOutBytesPerSec)
+	if payload.FDAllocated != nil && payload.FDMax != nil {
+		p.gauge("cricket_fd_allocated", "Allocated file handles.", float64(*payload.FDAllocated))
+		p.gauge("cricket_fd_max", "Maximum number of file handles.", float64(*payload.FDMax))
+	}
+	p.optionalGauge("cricket_fd_usage_percent", "File handle usage.", payload.FDUsagePercent)
+
+	if payload.FailedUnits != nil {
+		p.gauge("cricket_systemd_failed_units", "systemd units in the failed state.", float64(*payload.FailedUnits))
+	}
+	for _, unit := range payload.SystemdUnits {
+		active := 0.0
+		if unit.ActiveState == "active" {
+			active = 1
+		}
+		p.gauge("cricket_systemd_unit_active", "Whether the systemd unit is active.", active, "unit", unit.Name)
+	}
+
+	for _, device := range payload.SmartDevices {
+		if device.Healthy != nil {
+			healthy := 0.0
+			if *device.Healthy {
+				healthy = 1
+			}
+			p.gauge("cricket_smart_healthy", "Whether the disk passes its SMART self-assessment.", healthy, "device", device.Device)
+		}
+	}
+	for _, device := range payload.SmartDevices {
+		p.optionalGauge("cricket_smart_temperature_celsius", "Disk temperature reported by SMART.", device.TemperatureCelsius, "device", device.Device)
+	}
+
+	for _, pool := range payload.ZFSPools {
+		p.gauge("cricket_zfs_pool_capacity_percent", "ZFS pool space in use.", pool.CapacityPercent, "pool", pool.Name)
+	}
+	for _, pool := range payload.ZFSPools {
+		p.gauge("cricket_zfs_pool_size_bytes", "ZFS pool size.", float64(pool.SizeBytes), "pool", pool.Name)
+	}
+	for _, pool := range payload.ZFSPools {
+		p.gauge("cricket_zfs_pool_allocated_bytes", "ZFS pool space allocated.", float64(pool.AllocatedBytes), "pool", pool.Name)
+	}
+	for _, pool := range payload.ZFSPools {
+		online := 0.0
+		if pool.Health == "ONLINE" {
+			online = 1
+		}
+		p.gauge("cricket_zfs_pool_online", "Whether the ZFS pool is ONLINE.", online, "pool", pool.Name, "health", pool.Health)
+	}
+
+	for _, array := range payload.MDArrays {
+		degraded := 0.0
+		if array.Degraded {
+			degraded = 1
+		}
+		p.gauge("cricket_md_degraded", "Whether the software RAID array is degraded.", degraded, "device", array.Device)
+	}
+	for _, array := range payload.MDArrays {
+		p.optionalGauge("cricket_md_sync_percent", "Progress of the running resync, recovery, check or reshape.", array.SyncPercent, "device", array.Device, "action", array.SyncAction)
+	}
+
+	for _, result := range payload.Probes {
+		success := 0.0
+		if result.Success {
+			success = 1
+		}
+		p.gauge("cricket_probe_success", "Whether the reachability probe succeeded.", success, "target", result.Target, "type", result.Type)
+	}
+	for _, result := range payload.Probes {
+		p.optionalGauge("cricket_probe_latency_milliseconds", "Latency of the reachability probe.", result.LatencyMs, "target", result.Target, "type", result.Type)
+	}
+
+	for _, check := range payload.HTTPChecks {
+		success := 0.0
+		if check.Success {
+			success = 1
+		}
+		p.gauge("cricket_http_check_success", "Whether the HTTP check passed.", success, "url", check.URL)
+	}
+	for _, check := range payload.HTTPChecks {
+		p.optionalGauge("cricket_http_check_response_time_milliseconds", "Response time of the HTTP check.", check.ResponseTimeMs, "url", check.URL)
+	}
+
+	for _, cert := range payload.Certificates {
+		if cert.DaysUntilExpiry != nil {
+			p.gauge("cricket_certificate_days_until_expiry", "Days until the TLS certificate expires.", float64(*cert.DaysUntilExpiry), "target", cert.Target)
+		}
+	}
+
+	psi := collectors.PressureFields(payload)
+	for _, field := range psi {
+		p.optionalGauge("cricket_pressure_avg10_percent", "Share of the last 10 seconds tasks were stalled on the resource.", *field.Avg10, "resource", field.Resource, "kind", field.Kind)
+	}
+	for _, field := range psi {
+		p.optionalGauge("cricket_pressure_avg60_percent", "Share of the last 60 seconds tasks were stalled on the resource.", *field.Avg60, "resource", field.Resource, "kind", field.Kind)
+	}
+	for _, field := range psi {
+		p.optionalGauge("cricket_pressure_avg300_percent", "Share of the last 300 seconds tasks were stalled on the resource.", *field.Avg300, "resource", field.Resource, "kind", field.Kind)
+	}
+	for _, field := range psi {
+		if *field.Total != nil {
+			p.counter("cricket_pressure_stalled_seconds_total", "Total time tasks were stalled on the resource.", float64(**field.Total)/1e6, "resource", field.Resource, "kind", field.Kind)
+		}
+	}
+
+	p.optionalGauge("cricket_disk_usage_percent", "Root filesystem usage.", payload.DiskUsagePercent)
+	p.optionalCount("cricket_disk_used_bytes", "Root filesystem space in use.", payload.DiskUsedBytes)
+	p.optionalCount("cricket_disk_total_bytes", "Root filesystem size.", payload.DiskTotalBytes)
+	p.optionalCount("cricket_disk_available_bytes", "Root filesystem space available.", payload.DiskAvailableBytes)
+	p.optionalGauge("cricket_disk_read_bytes_per_second", "Disk read throughput over the last interval.", payload.DiskReadBytesPerSec)
+	p.optionalGauge("cricket_disk_write_bytes_per_second", "Disk write throughput over the last interval.", payload.DiskWriteBytesPerSec)
+	p.optionalGauge("cricket_disk_read_ops_per_second", "Disk read operations per second over the last interval.", payload.DiskReadOpsPerSec)
+	p.optionalGauge("cricket_disk_write_ops_per_second", "Disk write operations per second over the last interval.", payload.DiskWriteOpsPerSec)
+	// Samples of one family have to be contiguous, hence a loop per family
+	for _, d := range payload.DiskDevices {
+		p.gauge("cricket_disk_device_usage_percent", "Filesystem usage per device.", d.UsagePercent, "device", d.Device, "mountpoint", d.Mountpoint)
+	}
+	for _, d := range payload.DiskDevices {
+		p.gauge("cricket_disk_device_used_bytes", "Filesystem space in use per device.", float64(d.UsedBytes), "device", d.Device, "mountpoint", d.Mountpoint)
+	}
+	for _, d := range payload.DiskDevices {
+		p.gauge("cricket_disk_device_total_bytes", "Filesystem size per device.", float64(d.TotalBytes), "device", d.Device, "mountpoint", d.Mountpoint)
+	}
+
+	p.optionalGauge("cricket_network_receive_bytes_per_second", "Network receive throughput over the last interval.", payload.NetworkRXBytesPerSec)
+	p.optionalGauge("cricket_network_transmit_bytes_per_second", "Network transmit throughput over the last interval.", payload.NetworkTXBytesPerSec)
+	for _, iface := range payload.NetworkInterfaces {
+		p.optionalGauge("cricket_network_interface_receive_bytes_per_second", "Receive throughput per interface.", iface.RXBytesPerSec, "interface", iface.Name)
+	}
+	for _, iface := range payload.NetworkInterfaces {
+		p.optionalGauge("cricket_network_interface_transmit_bytes_per_second", "Transmit throughput per interface.", iface.TXBytesPerSec, "interface", iface.Name)
+	}
+
+	// The raw counters are left out of the payload when CRICKET_RAW_COUNTERS
+	// is off
+	p.optionalCounter("cricket_disk_read_bytes_total", "Bytes read from disk since boot.", payload.DiskReadBytes)
+	p.optionalCounter("cricket_disk_written_bytes_total", "Bytes written to disk since boot.", payload.DiskWriteBytes)
+	p.optionalCounter("cricket_disk_reads_total", "Disk read operations since boot.", payload.DiskReadOps)
+	p.optionalCounter("cricket_disk_writes_total", "Disk write operations since boot.", payload.DiskWriteOps)
+	p.optionalCounter("cricket_network_receive_bytes_total", "Bytes received since boot.", payload.NetworkRXBytes)
+	p.optionalCounter("cricket_network_transmit_bytes_total", "Bytes transmitted since boot.", payload.NetworkTXBytes)
+	p.optionalCounter("cricket_network_receive_errors_total", "Receive errors since boot.", payload.NetworkRXErrors)
+	p.optionalCounter("cricket_network_transmit_errors_total", "Transmit errors since boot.", payload.NetworkTXErrors)
+}
@@ -0,0 +1,73 @@
+package sender
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// minSecretLength is the shortest secret Redact replaces wherever it
+// appears. Shorter values, such as test keys, would garble unrelated text.
+const minSecretLength = 8
+
+// secrets are the configured values that must never show up in logs or
+// errors: API key, InfluxDB token and the like.
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// SetSecrets replaces the values Redact masks. Empty and short values are
+// ignored.
+func SetSecrets(values ...string) {
+	var kept []string
+	for _, value := range values {
+		if len(value) >= minSecretLength {
+			kept = append(kept, value)
+		}
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values = kept
+}
+
+// AddSecrets adds to the values Redact masks, for a key loaded after
+// startup.
+func AddSecrets(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, value := range values {
+		if len(value) >= minSecretLength {
+			secrets.values = append(secrets.values, value)
+		}
+	}
+}
+
+// headerPattern matches an Authorization or API key header and its value
+// in text that echoes requests, as misconfigured proxies do in their error
+// pages; bearerPattern a bearer token on its own.
+var (
+	headerPattern = regexp.MustCompile(`(?i)((?:proxy-)?authorization|x-api-key|api[-_]key)(["']?\s*[:=]\s*["']?)(?:(?:bearer|basic|token)\s+)?[^\s"',;}]+`)
+	bearerPattern = regexp.MustCompile(`(?i)\b(bearer)\s+[a-z0-9._~+/=-]+`)
+)
+
+// Redact masks the configured secrets and any Authorization header values
+// in s.
+func Redact(s string) string {
+	secrets.RLock()
+	for _, secret := range secrets.values {
+		s = strings.ReplaceAll(s, secret, "[REDACTED]")
+	}
+	secrets.RUnlock()
+	s = headerPattern.ReplaceAllString(s, "${1}${2}[REDACTED]")
+	return bearerPattern.ReplaceAllString(s, "${1} [REDACTED]")
+}
+
+// MaskSecret shows only the last 4 characters of a secret, enough to tell
+// which key is in use. Short values are masked completely.
+func MaskSecret(value string) string {
+	if len(value) < 2*minSecretLength {
+		return "[REDACTED]"
+	}
+	return "..." + value[len(value)-4:]
+}
@@ -0,0 +1,78 @@
+package sender
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cricket-collector/internal/collectors"
+)
+
+func TestRedact(t *testing.T) {
+	SetSecrets("ckt_perf_0123456789abcdef", "short")
+	defer SetSecrets()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "configured secret", in: "key ckt_perf_0123456789abcdef rejected", want: "key [REDACTED] rejected"},
+		{name: "short secret left alone", in: "a short answer", want: "a short answer"},
+		{name: "authorization header", in: "Authorization: Bearer abc.def-123", want: "Authorization: [REDACTED]"},
+		{name: "proxy authorization", in: "Proxy-Authorization: Basic dXNlcjpwYXNz", want: "Proxy-Authorization: [REDACTED]"},
+		{name: "json header", in: `{"authorization":"Bearer abc","ok":true}`, want: `{"authorization":"[REDACTED]","ok":true}`},
+		{name: "bare bearer token", in: "got bearer abc123 from client", want: "got bearer [REDACTED] from client"},
+		{name: "api key header", in: "X-Api-Key: zzz", want: "X-Api-Key: [REDACTED]"},
+		{name: "nothing to redact", in: "metrics submission failed with status 503", want: "metrics submission failed with status 503"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Redact(tt.in); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	if got := MaskSecret("ckt_perf_0123456789abcdef"); got != "...cdef" {
+		t.Errorf("MaskSecret() = %q, want %q", got, "...cdef")
+	}
+	if got := MaskSecret("short"); got != "[REDACTED]" {
+		t.Errorf("MaskSecret() = %q, want %q", got, "[REDACTED]")
+	}
+}
+
+func TestSendErrorStripsEchoedAuthorization(t *testing.T) {
+	const key = "ckt_perf_0123456789abcdef"
+	SetSecrets(key)
+	defer SetSecrets()
+
+	// A misconfigured proxy echoing the request headers in its error page
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request, you sent Authorization: " + r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(&Config{
+		APIBaseURL:      server.URL,
+		APIKey:          key,
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		CollectInterval: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false)
+	if err == nil {
+		t.Fatal("Send() succeeded, want an error")
+	}
+	if strings.Contains(err.Error(), key) || strings.Contains(err.Error(), "Bearer") {
+		t.Errorf("error leaks the API key: %v", err)
+	}
+}
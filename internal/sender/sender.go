@@ -0,0 +1,682 @@
+// Package sender delivers collected payloads: to the Cricket API, with
+// batching, retries and an offline spool, and to the other outputs.
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cricket-collector/internal/collectors"
+)
+
+// compressThreshold is the body size below which gzip isn't worth the overhead.
+const compressThreshold = 1024
+
+// maxResponseBodyBytes bounds how much of a successful ingest response is
+// read looking for settings pushed by the API.
+const maxResponseBodyBytes = 64 << 10
+
+// maxErrorBodyBytes limits how much of an error response ends up in logs.
+const maxErrorBodyBytes = 512
+
+// defaultThrottleBackoff is how long sends pause after a 429 without Retry-After.
+const defaultThrottleBackoff = time.Minute
+
+// maxRetryBackoff caps the delay between two send attempts.
+const maxRetryBackoff = 30 * time.Second
+
+// Sink is a destination for collected payloads. flush asks a sink that holds
+// payloads back (to batch them) to send everything it has.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, payload *collectors.MetricsPayload, flush bool) error
+}
+
+// Config holds the settings of the outputs.
+type Config struct {
+	APIBaseURL      string
+	FallbackURLs    []string
+	APIKey          string
+	APIKeyFile      string
+	CollectInterval int
+	HTTPTimeout     time.Duration
+	PreflightPath   string
+	IngestPath      string
+	IngestMethod    string
+	SuccessStatus   []int
+	SpoolDir        string
+	SpoolMaxBytes   int64
+	SpoolMaxAge     time.Duration
+	BatchSize       int
+	SendRetries     int
+	SendBackoffBase time.Duration
+	Compress        bool
+	TLSCAFile       string
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSInsecure     bool
+	ProxyURL        string
+	NoProxy         string
+	InfluxURL       string
+	InfluxToken     string
+	InfluxOrg       string
+	InfluxBucket    string
+	StatsdAddr      string
+	StatsdFlavor    string
+	StatsdPrefix    string
+	OTLPEndpoint    string
+	OTLPHeaders     map[string]string
+	FileSink        string
+	FileMaxBytes    int64
+	FileKeep        int
+	FileSync        bool
+	HTTPSink        bool
+	Version         string
+	UserAgent       string
+	Debug           bool
+}
+
+// API delivers payloads to the Cricket API, with batching and spooling. The
+// config is read on every send, so changes to it (by the API itself) apply
+// from the next one.
+type API struct {
+	config          *Config
+	client          *http.Client
+	spool           *Spool
+	batch           []*collectors.MetricsPayload
+	warnedStatus    bool
+	throttledUntil  time.Time
+	throttledCycles uint64
+	// Index into endpoints() of the one that last took a payload, tried
+	// first so a dead primary doesn't cost every send a timeout
+	current int
+	// When the API key file was last read
+	keyModTime time.Time
+	// OnConfig is called with the settings the API sends back with an ingest
+	OnConfig func(RemoteConfig)
+}
+
+// NewAPI sets up the client for the API, and the spool if CRICKET_SPOOL_DIR
+// is set.
+func NewAPI(config *Config) (*API, error) {
+	client, err := newHTTPClient(*config)
+	if err != nil {
+		return nil, err
+	}
+	a := &API{config: config, client: client}
+	if config.SpoolDir != "" {
+		a.spool, err = NewSpool(config.SpoolDir, config.SpoolMaxBytes, config.SpoolMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize spool directory: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// UpdateClient replaces the HTTP client with one built from config's
+// timeout, TLS and proxy settings. On error the current client is kept.
+func (a *API) UpdateClient(config Config) error {
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return err
+	}
+	a.client.CloseIdleConnections()
+	a.client = client
+	return nil
+}
+
+func (a *API) Name() string {
+	return "cricket"
+}
+
+// SpoolStats returns the spool's counters, or nil without a spool.
+func (a *API) SpoolStats() *collectors.SpoolStats {
+	if a.spool == nil {
+		return nil
+	}
+	stats := a.spool.Stats()
+	return &stats
+}
+
+// Send sends a payload to the Cricket API, or with batching adds it to the
+// batch, which is sent once it's full or when flush is set. Payloads that
+// can't be delivered go to the spool, and are sent ahead of new ones once
+// the API is back.
+func (a *API) Send(ctx context.Context, payload *collectors.MetricsPayload, flush bool) error {
+	config := a.config
+
+	if config.BatchSize > 1 {
+		a.batch = append(a.batch, payload)
+		if len(a.batch) < config.BatchSize && !flush {
+			slog.Debug("Batched payload", "batched", len(a.batch), "batch_size", config.BatchSize)
+			return nil
+		}
+	}
+
+	// Whatever failed earlier is backfilled first so the API gets payloads
+	// in order. While that fails, new payloads join the spool rather than
+	// overtake it
+	var err error
+	if a.spool != nil && a.spool.Stats().Pending > 0 {
+		err = a.flushSpool(ctx)
+	}
+	if err == nil {
+		if config.BatchSize > 1 {
+			err = a.sendBatch(ctx, a.batch)
+		} else {
+			err = a.sendMetrics(ctx, payload)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, ErrThrottled) {
+			a.throttledCycles++
+			slog.Debug("Skipping send", "reason", err, "throttled_cycles", a.throttledCycles)
+		}
+		if isRejected(err) {
+			// The API will never take it, keeping it would only hold up
+			// the payloads after it
+			slog.Warn("Dropping payload the API rejected", "status", rejectedStatus(err))
+			if config.BatchSize > 1 {
+				a.batch = nil
+			}
+		} else if a.spool != nil {
+			// Payloads that failed go to the spool, which enforces its size
+			// limit; without a spool a failed batch stays for the next attempt
+			pending := []*collectors.MetricsPayload{payload}
+			if config.BatchSize > 1 {
+				pending, a.batch = a.batch, nil
+			}
+			for _, p := range pending {
+				if spoolErr := a.spool.Append(p); spoolErr != nil {
+					slog.Error("Error spooling metrics", "error", spoolErr)
+				}
+			}
+		} else if config.BatchSize > 1 {
+			a.batch = trimBatch(a.batch, config.SpoolMaxBytes)
+		}
+	} else if config.BatchSize > 1 {
+		a.batch = nil
+	}
+
+	if a.spool != nil && config.Debug {
+		stats := a.spool.Stats()
+		slog.Debug("Spool", "pending", stats.Pending, "spooled", stats.Spooled, "replayed", stats.Replayed, "dropped", stats.Dropped)
+	}
+
+	return err
+}
+
+// flushSpool sends the spooled payloads, oldest first, and returns the
+// error that stopped it, if any.
+func (a *API) flushSpool(ctx context.Context) error {
+	sent, err := a.spool.Flush(func(payload *collectors.MetricsPayload) error {
+		return a.sendMetrics(ctx, payload)
+	})
+	if sent > 0 {
+		slog.Info("Delivered spooled payloads", "count", sent)
+	}
+	return err
+}
+
+// sendMetrics submits a single payload to CRICKET_INGEST_PATH.
+func (a *API) sendMetrics(ctx context.Context, payload *collectors.MetricsPayload) error {
+	return a.sendJSON(ctx, a.config.IngestPath, payload)
+}
+
+// sendBatch submits several payloads at once, as a JSON array, to
+// CRICKET_INGEST_PATH with "/batch" appended.
+func (a *API) sendBatch(ctx context.Context, payloads []*collectors.MetricsPayload) error {
+	return a.sendJSON(ctx, a.config.IngestPath+"/batch", payloads)
+}
+
+// trimBatch drops the oldest payloads of a batch that couldn't be sent until
+// the rest fits within maxBytes of JSON, so an unreachable API can't make it
+// grow without bound.
+func trimBatch(batch []*collectors.MetricsPayload, maxBytes int64) []*collectors.MetricsPayload {
+	if maxBytes <= 0 {
+		return batch
+	}
+	var size int64
+	keep := len(batch)
+	for keep > 0 {
+		data, err := json.Marshal(batch[keep-1])
+		if err != nil || size+int64(len(data)) > maxBytes {
+			break
+		}
+		size += int64(len(data))
+		keep--
+	}
+	if keep > 0 {
+		slog.Warn("Dropping unsent batched payloads over the size limit", "count", keep, "max_bytes", maxBytes)
+	}
+	return batch[keep:]
+}
+
+// endpoints returns the API URL followed by the fallback URLs.
+func (a *API) endpoints() []string {
+	return append([]string{a.config.APIBaseURL}, a.config.FallbackURLs...)
+}
+
+// sendJSON posts v to path. Every attempt goes through the endpoints in
+// order, starting with the one that last worked, until one takes it; server
+// errors and network failures move on to the next. When all of them failed,
+// the attempt is retried with exponential backoff. Client errors, 429
+// included, come from an API that is up, so they don't fail over, and other
+// than 429 are returned immediately since a bad payload or API key won't
+// get better by retrying. Retries stop once the next one would run past the
+// collection interval, so cycles never pile up behind a struggling API.
+// A 401 or 403 is retried once if CRICKET_API_KEY_FILE has a new key.
+// Failed sends return a *SendError.
+func (a *API) sendJSON(ctx context.Context, path string, v interface{}) error {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	body, contentEncoding := jsonData, ""
+	if a.config.Compress && len(jsonData) > compressThreshold {
+		if body, err = gzipBody(jsonData); err != nil {
+			return fmt.Errorf("failed to compress metrics: %w", err)
+		}
+		contentEncoding = "gzip"
+	}
+
+	if time.Now().Before(a.throttledUntil) {
+		return fmt.Errorf("%w until %s", ErrThrottled, a.throttledUntil.Format(time.RFC3339))
+	}
+
+	start := time.Now()
+	deadline := start.Add(time.Duration(a.config.CollectInterval) * time.Second)
+	rotated := false
+	for attempt := 0; ; attempt++ {
+		a.refreshAPIKey(false)
+		endpoint, err := a.postToEndpoints(ctx, path, body, contentEncoding)
+		if err == nil {
+			slog.Debug("Sent metrics", "endpoint", endpoint, "bytes", len(body), "attempts", attempt+1,
+				"duration_ms", time.Since(start).Milliseconds())
+			return nil
+		}
+		// A rejected key may have been rotated without the file's
+		// modification time showing it, so read it again and retry once
+		if isAuthError(err) && !rotated && a.refreshAPIKey(true) {
+			rotated = true
+			continue
+		}
+		if !isRetryable(err) {
+			return newSendError(endpoint, attempt+1, err)
+		}
+
+		delay := retryDelay(err, a.config.SendBackoffBase, attempt)
+		if attempt >= a.config.SendRetries || time.Now().Add(delay).After(deadline) {
+			a.throttleIfRateLimited(err)
+			return newSendError(endpoint, attempt+1, err)
+		}
+
+		slog.Debug("Send attempt failed, retrying", "endpoint", endpoint, "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return newSendError(endpoint, attempt+1, err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// postToEndpoints posts body to path on each endpoint in turn, starting
+// with the current one, until one takes it or fails in a way the others
+// can't fix. It returns the (redacted) URL of the last one tried.
+func (a *API) postToEndpoints(ctx context.Context, path string, body []byte, contentEncoding string) (string, error) {
+	bases := a.endpoints()
+	var endpoint string
+	var err error
+	for i := range bases {
+		n := (a.current + i) % len(bases)
+		endpoint = redactURL(bases[n] + path)
+		err = a.postMetrics(ctx, bases[n]+path, body, contentEncoding)
+		if err == nil {
+			if n != a.current%len(bases) {
+				slog.Info("Switched to another ingest endpoint", "endpoint", endpoint)
+			}
+			a.current = n
+			return endpoint, nil
+		}
+		if !shouldFailOver(err) {
+			break
+		}
+		if i+1 < len(bases) {
+			slog.Warn("Ingest endpoint failed, trying the next one", "endpoint", endpoint,
+				"next", redactURL(bases[(n+1)%len(bases)]+path), "error", err)
+		}
+	}
+	return endpoint, err
+}
+
+// redactURL hides the password of a URL that has one.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// describeSendError makes it clear whether a request failed on the way to the
+// proxy or to the API host itself.
+func (a *API) describeSendError(req *http.Request, err error) error {
+	var proxyURL *url.URL
+	if transport, ok := a.client.Transport.(*http.Transport); ok && transport.Proxy != nil {
+		proxyURL, _ = transport.Proxy(req)
+	}
+	if proxyURL == nil {
+		return fmt.Errorf("failed to send metrics to %s: %w", req.URL.Host, err)
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return fmt.Errorf("failed to connect to proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	return fmt.Errorf("failed to send metrics to %s via proxy %s: %w", req.URL.Host, proxyURL.Redacted(), err)
+}
+
+// throttleIfRateLimited holds off further sends after the API answered 429,
+// for as long as its Retry-After asked or defaultThrottleBackoff otherwise.
+func (a *API) throttleIfRateLimited(err error) {
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	backoff := statusErr.RetryAfter
+	if backoff <= 0 {
+		backoff = defaultThrottleBackoff
+	}
+	a.throttledUntil = time.Now().Add(backoff)
+	slog.Warn("Ingest API is rate limiting this collector, pausing sends", "until", a.throttledUntil.Format(time.RFC3339))
+}
+
+// Preflight makes one authenticated request to CRICKET_PREFLIGHT_PATH and
+// logs loudly if the API can't be reached or rejects the API key.
+func (a *API) Preflight(ctx context.Context) {
+	config := a.config
+	endpoint := config.APIBaseURL + "/" + strings.TrimLeft(config.PreflightPath, "/")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		slog.Error("Invalid API URL", "url", config.APIBaseURL, "error", err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+config.APIKey)
+	req.Header.Set("User-Agent", a.config.UserAgent)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		slog.Error("API preflight failed, the API is unreachable. Check CRICKET_API_URL and network access; sends will keep retrying.",
+			"endpoint", endpoint, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		slog.Error("API preflight failed, the API rejected the API key. Check CRICKET_API_KEY.", "endpoint", endpoint, "status", resp.StatusCode)
+	case resp.StatusCode == http.StatusNotFound:
+		slog.Warn("API preflight path not found. Check CRICKET_API_URL, or CRICKET_PREFLIGHT_PATH if the API has no such path.", "endpoint", endpoint, "status", resp.StatusCode)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		slog.Warn("API preflight returned an error status", "endpoint", endpoint, "status", resp.StatusCode)
+	default:
+		slog.Info("API preflight OK", "endpoint", endpoint)
+	}
+}
+
+// postMetrics makes a single request to endpoint.
+func (a *API) postMetrics(ctx context.Context, endpoint string, body []byte, contentEncoding string) error {
+	config := a.config
+
+	req, err := http.NewRequestWithContext(ctx, config.IngestMethod, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	req.Header.Set("Authorization", "Bearer "+config.APIKey)
+	req.Header.Set("User-Agent", a.config.UserAgent)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return a.describeSendError(req, err)
+	}
+	defer resp.Body.Close()
+	// Drain whatever is left of the body so the connection can be reused
+	defer io.Copy(io.Discard, resp.Body)
+
+	if !a.isSuccess(resp.StatusCode) {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes+1))
+		errorBody := string(body)
+		if len(body) > maxErrorBodyBytes {
+			errorBody = string(body[:maxErrorBodyBytes]) + "..."
+		}
+		// Proxies and gateways may echo the request, Authorization included
+		errorBody = Redact(errorBody)
+		return &statusError{
+			StatusCode: resp.StatusCode,
+			Body:       errorBody,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	// The API may answer with settings for this collector to pick up
+	if data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBodyBytes)); err == nil {
+		var response struct {
+			Config *RemoteConfig `json:"config"`
+		}
+		if json.Unmarshal(data, &response) == nil && response.Config != nil && a.OnConfig != nil {
+			a.OnConfig(*response.Config)
+		}
+	}
+
+	// The Cricket API answers 201 and gateways in front of it commonly 200.
+	// Anything else means something in between is rewriting responses.
+	// Worth knowing, but not an error.
+	if len(config.SuccessStatus) == 0 && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK && !a.warnedStatus {
+		slog.Warn("Ingest endpoint returned neither 200 nor 201, treating it as success", "endpoint", req.URL.Redacted(), "status", resp.StatusCode)
+		a.warnedStatus = true
+	}
+
+	return nil
+}
+
+// isSuccess reports whether an ingest response status means the payload was
+// accepted: one of CRICKET_SUCCESS_STATUS when set, any 2xx otherwise.
+func (a *API) isSuccess(status int) bool {
+	if len(a.config.SuccessStatus) == 0 {
+		return status >= 200 && status <= 299
+	}
+	for _, code := range a.config.SuccessStatus {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseStatusCodes reads CRICKET_SUCCESS_STATUS, comma-separated HTTP status
+// codes such as "200,201,202,204".
+func ParseStatusCodes(value string) []int {
+	var codes []int
+	for _, entry := range collectors.SplitList(value) {
+		code, err := strconv.Atoi(entry)
+		if err != nil || code < 100 || code > 599 {
+			slog.Warn("Ignoring CRICKET_SUCCESS_STATUS entry, expected an HTTP status code", "value", entry)
+			continue
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// RemoteConfig is the "config" object the API may include in its response
+// to an ingest, to change settings of a running collector without a
+// redeploy. Settings left out stay as they are.
+type RemoteConfig struct {
+	CollectInterval *int  `json:"collect_interval"`
+	CollectPerCPU   *bool `json:"collect_percpu"`
+	TopProcesses    *int  `json:"top_processes"`
+	TCPStats        *bool `json:"tcp_stats"`
+	CollectTemps    *bool `json:"collect_temps"`
+	PSI             *bool `json:"psi"`
+}
+
+func gzipBody(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrThrottled is returned instead of sending while the API has asked us to back off.
+var ErrThrottled = errors.New("sending paused by API rate limiting")
+
+// statusError is returned when the API answers with an unexpected status code.
+type statusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("metrics submission failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// SendError is returned when a payload couldn't be delivered to the API,
+// with where it was sent, the status of the last response (0 when there
+// was none) and how many attempts were made.
+type SendError struct {
+	Endpoint   string
+	StatusCode int
+	Attempts   int
+	Err        error
+}
+
+func newSendError(endpoint string, attempts int, err error) *SendError {
+	e := &SendError{Endpoint: endpoint, Attempts: attempts, Err: err}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		e.StatusCode = statusErr.StatusCode
+	}
+	return e
+}
+
+func (e *SendError) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("giving up after %d attempts: %v", e.Attempts, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// shouldFailOver reports whether another endpoint may take a payload this
+// one failed: after a network failure or a server error, but not when the
+// API rejected the payload or is rate limiting us.
+func shouldFailOver(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// isAuthError reports whether the API rejected the API key.
+func isAuthError(err error) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) &&
+		(statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden)
+}
+
+// isRejected reports whether the API refused a payload for good: a client
+// error other than a rejected API key, a timeout or rate limiting, none of
+// which are about the payload itself.
+func isRejected(err error) bool {
+	switch status := rejectedStatus(err); status {
+	case 0, http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	default:
+		return status >= 400 && status <= 499
+	}
+}
+
+// rejectedStatus is the HTTP status of a failed send, or 0 if it didn't get
+// one.
+func rejectedStatus(err error) int {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+func isRetryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	// Anything else is a network-level failure, unless we're shutting down
+	return !errors.Is(err, context.Canceled)
+}
+
+// retryDelay doubles the base delay for every attempt (up to maxRetryBackoff)
+// and adds up to 50% random jitter so a fleet of collectors doesn't retry in
+// lockstep. A Retry-After sent by the server takes precedence.
+func retryDelay(err error, base time.Duration, attempt int) time.Duration {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+
+	backoff := base << attempt
+	if backoff <= 0 || backoff > maxRetryBackoff {
+		// Shifting far enough overflows, which also means we're past the cap
+		backoff = maxRetryBackoff
+	}
+	if base <= 0 {
+		return 0
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
+
+// parseRetryAfter understands both forms of the Retry-After header: a number
+// of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
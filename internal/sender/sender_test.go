@@ -0,0 +1,437 @@
+package sender
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cricket-collector/internal/collectors"
+)
+
+func TestSendFailsOverToFallbackURL(t *testing.T) {
+	var primaryHits, fallbackHits atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackHits.Add(1)
+		if r.URL.Path != "/api/metrics/ingest" {
+			t.Errorf("fallback got path %q", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer fallback.Close()
+
+	api, err := NewAPI(&Config{
+		APIBaseURL:      primary.URL,
+		FallbackURLs:    []string{fallback.URL},
+		APIKey:          "test",
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		CollectInterval: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := &collectors.MetricsPayload{ServerName: "test"}
+	if err := api.Send(context.Background(), payload, false); err != nil {
+		t.Fatalf("Send() = %v, want the fallback to take it", err)
+	}
+	if primaryHits.Load() != 1 || fallbackHits.Load() != 1 {
+		t.Fatalf("hits: primary %d, fallback %d, want 1 and 1", primaryHits.Load(), fallbackHits.Load())
+	}
+
+	// The fallback worked last time, so it goes first now
+	if err := api.Send(context.Background(), payload, false); err != nil {
+		t.Fatalf("second Send() = %v", err)
+	}
+	if primaryHits.Load() != 1 || fallbackHits.Load() != 2 {
+		t.Errorf("hits after second send: primary %d, fallback %d, want 1 and 2", primaryHits.Load(), fallbackHits.Load())
+	}
+}
+
+func TestSendDoesNotFailOverOnClientError(t *testing.T) {
+	var fallbackHits atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackHits.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer fallback.Close()
+
+	api, err := NewAPI(&Config{
+		APIBaseURL:      primary.URL,
+		FallbackURLs:    []string{fallback.URL},
+		APIKey:          "test",
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		CollectInterval: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false); err == nil {
+		t.Fatal("Send() succeeded, want the 401 returned")
+	}
+	if fallbackHits.Load() != 0 {
+		t.Errorf("fallback got %d requests after a 401, want 0", fallbackHits.Load())
+	}
+}
+
+func TestSendPicksUpRotatedAPIKey(t *testing.T) {
+	var validKey atomic.Value
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer "+validKey.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "api-key")
+	modTime := time.Now().Add(-time.Hour)
+	writeKey := func(key string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(keyFile, []byte(key+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(keyFile, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeKey("key-1", modTime)
+	validKey.Store("key-1")
+
+	key, err := ReadAPIKeyFile(keyFile)
+	if err != nil || key != "key-1" {
+		t.Fatalf("ReadAPIKeyFile() = %q, %v, want the trimmed key", key, err)
+	}
+	api, err := NewAPI(&Config{
+		APIBaseURL:      server.URL,
+		APIKey:          key,
+		APIKeyFile:      keyFile,
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		CollectInterval: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := &collectors.MetricsPayload{ServerName: "test"}
+	send := func(wantRequests int32) {
+		t.Helper()
+		requests.Store(0)
+		if err := api.Send(context.Background(), payload, false); err != nil {
+			t.Fatalf("Send() = %v", err)
+		}
+		if got := requests.Load(); got != wantRequests {
+			t.Errorf("Send() made %d requests, want %d", got, wantRequests)
+		}
+	}
+	send(1)
+
+	// A new file is read before the next send
+	writeKey("key-2", modTime.Add(time.Minute))
+	validKey.Store("key-2")
+	send(1)
+
+	// One the modification time doesn't give away is read after the 401
+	writeKey("key-3", modTime.Add(time.Minute))
+	validKey.Store("key-3")
+	send(2)
+}
+
+func TestSendDeliversSpoolBeforeNewPayloads(t *testing.T) {
+	var down atomic.Bool
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload collectors.MetricsPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("bad payload: %v", err)
+		}
+		mu.Lock()
+		received = append(received, payload.Timestamp)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(&Config{
+		APIBaseURL:      server.URL,
+		APIKey:          "test",
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		SpoolDir:        t.TempDir(),
+		CollectInterval: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(timestamp string) error {
+		return api.Send(context.Background(), &collectors.MetricsPayload{Timestamp: timestamp}, false)
+	}
+
+	down.Store(true)
+	for _, timestamp := range []string{"2024-01-01T00:00:00Z", "2024-01-01T00:01:00Z"} {
+		if err := send(timestamp); err == nil {
+			t.Fatal("Send() succeeded with the API down")
+		}
+	}
+	if pending := api.SpoolStats().Pending; pending != 2 {
+		t.Fatalf("spool holds %d payloads, want 2", pending)
+	}
+
+	down.Store(false)
+	if err := send("2024-01-01T00:02:00Z"); err != nil {
+		t.Fatalf("Send() = %v after the API recovered", err)
+	}
+	want := []string{"2024-01-01T00:00:00Z", "2024-01-01T00:01:00Z", "2024-01-01T00:02:00Z"}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("API received %v, want %v", received, want)
+	}
+	if pending := api.SpoolStats().Pending; pending != 0 {
+		t.Errorf("spool still holds %d payloads", pending)
+	}
+}
+
+func TestSendRetriesWithBackoff(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []time.Time
+	statuses := []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusCreated}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(statuses[len(attempts)])
+		attempts = append(attempts, time.Now())
+	}))
+	defer server.Close()
+
+	const base = 20 * time.Millisecond
+	api, err := NewAPI(&Config{
+		APIBaseURL:      server.URL,
+		APIKey:          "test",
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		SendRetries:     3,
+		SendBackoffBase: base,
+		CollectInterval: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false); err != nil {
+		t.Fatalf("Send() = %v, want the third attempt to succeed", err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("Send() made %d attempts, want 3", len(attempts))
+	}
+	// Each delay doubles the one before, plus up to 50% jitter
+	for i, want := range []time.Duration{base, 2 * base} {
+		if got := attempts[i+1].Sub(attempts[i]); got < want || got > want*3/2+time.Second {
+			t.Errorf("delay before attempt %d = %v, want %v plus up to 50%% jitter", i+2, got, want)
+		}
+	}
+}
+
+func TestSendGivesUpAfterRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(&Config{
+		APIBaseURL:      server.URL,
+		APIKey:          "test",
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		SendRetries:     2,
+		SendBackoffBase: time.Millisecond,
+		CollectInterval: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false)
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || sendErr.Attempts != 3 || sendErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Send() = %v, want a SendError after 3 attempts with status 503", err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("server got %d requests, want 3", requests.Load())
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt, want := range []time.Duration{base, 2 * base, 4 * base} {
+		if got := retryDelay(&statusError{StatusCode: 503}, base, attempt); got < want || got > want*3/2 {
+			t.Errorf("retryDelay(attempt %d) = %v, want %v to %v", attempt, got, want, want*3/2)
+		}
+	}
+	if got := retryDelay(errors.New("connection refused"), base, 40); got < maxRetryBackoff || got > maxRetryBackoff*3/2 {
+		t.Errorf("retryDelay(attempt 40) = %v, want it capped at %v plus jitter", got, maxRetryBackoff)
+	}
+	if got := retryDelay(&statusError{StatusCode: 429, RetryAfter: 7 * time.Second}, base, 0); got != 7*time.Second {
+		t.Errorf("retryDelay() = %v, want the Retry-After of 7s", got)
+	}
+	if got := retryDelay(&statusError{StatusCode: 503}, 0, 2); got != 0 {
+		t.Errorf("retryDelay() without a base = %v, want 0", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second},
+		// A date in the past asks for no delay
+		{"Mon, 01 Jan 2024 11:59:00 GMT", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatusCodes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []int
+	}{
+		{"", nil},
+		{"201", []int{201}},
+		{"200, 201,202", []int{200, 201, 202}},
+		// Entries that aren't status codes are skipped with a warning
+		{"200,ok,99,600,204", []int{200, 204}},
+		{",,", nil},
+	}
+	for _, tt := range tests {
+		if got := ParseStatusCodes(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseStatusCodes(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSendSuccessStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		successStatus string
+		wantErr       bool
+	}{
+		{"", false},
+		{"202", false},
+		{"200,201", true},
+	} {
+		api, err := NewAPI(&Config{
+			APIBaseURL:      server.URL,
+			APIKey:          "test",
+			IngestPath:      "/api/metrics/ingest",
+			IngestMethod:    http.MethodPost,
+			SuccessStatus:   ParseStatusCodes(tt.successStatus),
+			CollectInterval: 60,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CRICKET_SUCCESS_STATUS=%q: a 202 gave %v, want error %v", tt.successStatus, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSendCompressesLargeBodies(t *testing.T) {
+	var got []*collectors.MetricsPayload
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("gzip.NewReader: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			body = zr
+		}
+		var payload collectors.MetricsPayload
+		if err := json.NewDecoder(body).Decode(&payload); err != nil {
+			t.Errorf("decoding the body: %v", err)
+		}
+		got = append(got, &payload)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(&Config{
+		APIBaseURL:      server.URL,
+		APIKey:          "test",
+		IngestPath:      "/api/metrics/ingest",
+		IngestMethod:    http.MethodPost,
+		CollectInterval: 60,
+		Compress:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{}
+	for i := 0; i < 100; i++ {
+		tags[fmt.Sprintf("tag_%d", i)] = "value"
+	}
+	large := &collectors.MetricsPayload{ServerName: "large", Tags: tags}
+	small := &collectors.MetricsPayload{ServerName: "small"}
+	for _, payload := range []*collectors.MetricsPayload{large, small} {
+		if err := api.Send(context.Background(), payload, false); err != nil {
+			t.Fatalf("Send(%s) = %v", payload.ServerName, err)
+		}
+	}
+
+	// Bodies under compressThreshold aren't worth compressing
+	if want := []string{"gzip", ""}; !reflect.DeepEqual(encodings, want) {
+		t.Errorf("Content-Encoding %q, want %q", encodings, want)
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got[0], large) || !reflect.DeepEqual(got[1], small) {
+		t.Errorf("server decoded %+v, want the payloads sent", got)
+	}
+}
@@ -0,0 +1,233 @@
+package sender
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"cricket-collector/internal/collectors"
+)
+
+// Spool stores payloads that could not be delivered as JSON lines in a file
+// so they can be replayed once the API is reachable again.
+type Spool struct {
+	path     string
+	maxBytes int64
+	maxAge   time.Duration
+
+	// Running totals since startup, reported in debug logs
+	pending  int
+	spooled  uint64
+	replayed uint64
+	dropped  uint64
+}
+
+// NewSpool opens the spool in dir. Payloads beyond maxBytes, or older than
+// maxAge by the time they are replayed, are dropped; 0 means no limit.
+func NewSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		path:     filepath.Join(dir, "spool.jsonl"),
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}
+	// Pick up whatever a previous run left behind
+	lines, err := s.readLines()
+	if err != nil {
+		return nil, err
+	}
+	s.pending = len(lines)
+	return s, nil
+}
+
+// Stats returns the spool's current size and counters since startup.
+func (s *Spool) Stats() collectors.SpoolStats {
+	return collectors.SpoolStats{
+		Pending:  s.pending,
+		Spooled:  s.spooled,
+		Replayed: s.replayed,
+		Dropped:  s.dropped,
+	}
+}
+
+// Append adds a payload to the end of the spool, dropping the oldest entries
+// if the spool grows beyond its size limit.
+func (s *Spool) Append(payload *collectors.MetricsPayload) error {
+	line, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spooled metrics: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open spool: %w", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	s.spooled++
+	s.pending++
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat spool: %w", err)
+	}
+	if s.maxBytes > 0 && info.Size() > s.maxBytes {
+		lines, err := s.readLines()
+		if err != nil {
+			return err
+		}
+		// Keep the newest entries that fit within the limit
+		var size int64
+		keep := len(lines)
+		for keep > 0 && size+int64(len(lines[keep-1]))+1 <= s.maxBytes {
+			size += int64(len(lines[keep-1])) + 1
+			keep--
+		}
+		if err := s.writeLines(lines[keep:]); err != nil {
+			return err
+		}
+		s.dropped += uint64(keep)
+		s.pending = len(lines) - keep
+	}
+
+	return nil
+}
+
+// Flush sends spooled payloads in timestamp order until one fails. Delivered
+// entries are removed from the spool, as are those the API rejected (see
+// isRejected), and the rest are kept for the next attempt.
+func (s *Spool) Flush(send func(*collectors.MetricsPayload) error) (int, error) {
+	lines, err := s.readLines()
+	if err != nil || len(lines) == 0 {
+		return 0, err
+	}
+
+	type entry struct {
+		line    []byte
+		payload *collectors.MetricsPayload
+	}
+	// Timestamps are RFC3339 in UTC, so they compare as strings
+	var cutoff string
+	if s.maxAge > 0 {
+		cutoff = time.Now().Add(-s.maxAge).UTC().Format(time.RFC3339)
+	}
+	entries := make([]entry, 0, len(lines))
+	for _, line := range lines {
+		var payload collectors.MetricsPayload
+		if err := json.Unmarshal(line, &payload); err != nil {
+			// A torn write can leave a partial line behind; it can never be sent
+			s.dropped++
+			continue
+		}
+		if payload.Timestamp < cutoff {
+			// Too old for the backend to accept, no point sending it
+			s.dropped++
+			continue
+		}
+		entries = append(entries, entry{line: line, payload: &payload})
+	}
+	// RFC3339 timestamps in UTC sort lexically
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].payload.Timestamp < entries[j].payload.Timestamp
+	})
+
+	sent := 0
+	next := 0
+	var sendErr error
+	for ; next < len(entries); next++ {
+		err := send(entries[next].payload)
+		if err == nil {
+			sent++
+			continue
+		}
+		if isRejected(err) {
+			// Retrying won't change the API's mind, and keeping it would
+			// hold up everything spooled after it
+			slog.Warn("Dropping spooled payload the API rejected", "timestamp", entries[next].payload.Timestamp, "error", err)
+			s.dropped++
+			continue
+		}
+		sendErr = err
+		break
+	}
+
+	s.replayed += uint64(sent)
+
+	remaining := make([][]byte, 0, len(entries)-next)
+	for _, e := range entries[next:] {
+		remaining = append(remaining, e.line)
+	}
+	if err := s.writeLines(remaining); err != nil {
+		return sent, err
+	}
+	s.pending = len(remaining)
+	return sent, sendErr
+}
+
+func (s *Spool) readLines() ([][]byte, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool: %w", err)
+	}
+	defer f.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read spool: %w", err)
+	}
+	return lines, nil
+}
+
+// writeLines atomically replaces the spool contents, removing the file when
+// nothing is left.
+func (s *Spool) writeLines(lines [][]byte) error {
+	if len(lines) == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to clear spool: %w", err)
+		}
+		return nil
+	}
+
+	tmpPath := s.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite spool: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rewrite spool: %w", err)
+	}
+	return os.Rename(tmpPath, s.path)
+}
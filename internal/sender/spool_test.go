@@ -0,0 +1,75 @@
+package sender
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"cricket-collector/internal/collectors"
+)
+
+func TestSpoolFlushDropsRejectedPayloads(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, timestamp := range []string{"2024-01-01T00:00:00Z", "2024-01-01T00:01:00Z", "2024-01-01T00:02:00Z", "2024-01-01T00:03:00Z", "2024-01-01T00:04:00Z"} {
+		if err := spool.Append(&collectors.MetricsPayload{Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// What the API answers per payload; the rest are accepted
+	responses := map[string]error{
+		"2024-01-01T00:01:00Z": &statusError{StatusCode: 400},
+		"2024-01-01T00:02:00Z": &statusError{StatusCode: 422},
+		"2024-01-01T00:03:00Z": &statusError{StatusCode: 503},
+	}
+	var tried []string
+	send := func(payload *collectors.MetricsPayload) error {
+		tried = append(tried, payload.Timestamp)
+		return responses[payload.Timestamp]
+	}
+
+	sent, err := spool.Flush(send)
+	if sent != 1 || !errors.Is(err, responses["2024-01-01T00:03:00Z"]) {
+		t.Fatalf("Flush() = %d, %v, want 1 sent and the 503", sent, err)
+	}
+	stats := spool.Stats()
+	if stats.Dropped != 2 || stats.Pending != 2 {
+		t.Errorf("after the 503: dropped %d, pending %d, want 2 and 2", stats.Dropped, stats.Pending)
+	}
+
+	// The 503 was transient: it and everything after it is tried again
+	delete(responses, "2024-01-01T00:03:00Z")
+	tried = nil
+	if sent, err := spool.Flush(send); sent != 2 || err != nil {
+		t.Fatalf("second Flush() = %d, %v, want 2 sent", sent, err)
+	}
+	if want := []string{"2024-01-01T00:03:00Z", "2024-01-01T00:04:00Z"}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("second Flush() sent %v, want %v", tried, want)
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&statusError{StatusCode: 400}, true},
+		{&statusError{StatusCode: 404}, true},
+		{&statusError{StatusCode: 413}, true},
+		{&statusError{StatusCode: 401}, false},
+		{&statusError{StatusCode: 403}, false},
+		{&statusError{StatusCode: 408}, false},
+		{&statusError{StatusCode: 429}, false},
+		{&statusError{StatusCode: 500}, false},
+		{newSendError("https://example.com", 1, &statusError{StatusCode: 400}), true},
+		{errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := isRejected(tt.err); got != tt.want {
+			t.Errorf("isRejected(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package sender
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cricket-collector/internal/collectors"
+)
+
+// maxStatsdPacket keeps each UDP datagram within a typical 1500 byte MTU
+// once the IP and UDP headers are added (with room for tunnels).
+const maxStatsdPacket = 1432
+
+// statsdQueueSize is how many packets can wait to be written before new
+// ones are dropped; one cycle rarely needs more than a few dozen.
+const statsdQueueSize = 512
+
+var statsdNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// StatsdSink emits every numeric field of a payload as a gauge over UDP, in
+// plain statsd or, with tags, DogStatsD format. Packets are written by a
+// background goroutine so a send never blocks; when it falls behind, new
+// packets are dropped.
+type StatsdSink struct {
+	prefix    string
+	dogstatsd bool
+	conn      net.Conn
+	packets   chan []byte
+	pending   sync.WaitGroup
+	mu        sync.Mutex
+	err       error
+	dropped   int
+}
+
+func NewStatsdSink(config Config) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", config.StatsdAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open statsd socket: %w", err)
+	}
+	s := &StatsdSink{
+		prefix:    config.StatsdPrefix,
+		dogstatsd: config.StatsdFlavor == "dogstatsd",
+		conn:      conn,
+		packets:   make(chan []byte, statsdQueueSize),
+	}
+	go s.write()
+	return s, nil
+}
+
+func (s *StatsdSink) Name() string {
+	return "statsd"
+}
+
+// Send queues the gauges of the payload: host-wide ones as <prefix><field>,
+// and per filesystem and interface ones as <prefix>disk.<field> and
+// <prefix>network.<field>. Plain statsd has no tags, so the device or
+// interface goes into the name instead (<prefix>disk.sda1.<field>). Any
+// write errors since the previous send are returned. flush waits for the
+// packets to be written, for a final send before exiting.
+func (s *StatsdSink) Send(ctx context.Context, payload *collectors.MetricsPayload, flush bool) error {
+	tags := []string{"server_name", payload.ServerName, "hostname", payload.Hostname}
+	lines := s.gauges(nil, "", reflect.ValueOf(*payload), tags)
+	for _, device := range payload.DiskDevices {
+		group := "disk."
+		if !s.dogstatsd {
+			group += statsdName(strings.TrimPrefix(device.Device, "/dev/")) + "."
+		}
+		deviceTags := append(tags[:len(tags):len(tags)], "device", device.Device, "mountpoint", device.Mountpoint, "filesystem", device.Filesystem)
+		lines = s.gauges(lines, group, reflect.ValueOf(device), deviceTags)
+	}
+	for _, iface := range payload.NetworkInterfaces {
+		group := "network."
+		if !s.dogstatsd {
+			group += statsdName(iface.Name) + "."
+		}
+		lines = s.gauges(lines, group, reflect.ValueOf(iface), append(tags[:len(tags):len(tags)], "interface", iface.Name))
+	}
+
+	dropped := 0
+	for _, packet := range statsdPackets(lines, maxStatsdPacket) {
+		s.pending.Add(1)
+		select {
+		case s.packets <- packet:
+		default:
+			s.pending.Done()
+			dropped++
+		}
+	}
+	if flush {
+		written := make(chan struct{})
+		go func() {
+			s.pending.Wait()
+			close(written)
+		}()
+		select {
+		case <-written:
+		case <-ctx.Done():
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	s.err = nil
+	dropped += s.dropped
+	s.dropped = 0
+	if dropped > 0 {
+		return fmt.Errorf("dropped %d packets, writing to %s is falling behind", dropped, s.conn.RemoteAddr())
+	}
+	return err
+}
+
+// gauges appends a gauge line for every number field of the struct v.
+func (s *StatsdSink) gauges(lines []string, group string, v reflect.Value, tags []string) []string {
+	var suffix string
+	if s.dogstatsd {
+		var pairs []string
+		for i := 0; i+1 < len(tags); i += 2 {
+			if tags[i+1] != "" {
+				pairs = append(pairs, tags[i]+":"+statsdTagValue(tags[i+1]))
+			}
+		}
+		if len(pairs) > 0 {
+			suffix = "|#" + strings.Join(pairs, ",")
+		}
+	}
+
+	eachField(v, func(name string, field reflect.Value) {
+		value, ok := numericValue(field)
+		if !ok {
+			return
+		}
+		metric := s.prefix + group + name
+		// A leading sign makes plain statsd adjust the gauge rather than
+		// set it, so a negative value has to be set from 0
+		if value < 0 && !s.dogstatsd {
+			lines = append(lines, metric+":0|g")
+		}
+		lines = append(lines, metric+":"+strconv.FormatFloat(value, 'f', -1, 64)+"|g"+suffix)
+	})
+	return lines
+}
+
+// write sends queued packets for as long as the collector runs, keeping the
+// last error for the next Send to report.
+func (s *StatsdSink) write() {
+	for packet := range s.packets {
+		if _, err := s.conn.Write(packet); err != nil {
+			s.mu.Lock()
+			s.err = fmt.Errorf("failed to write to %s: %w", s.conn.RemoteAddr(), err)
+			s.mu.Unlock()
+		}
+		s.pending.Done()
+	}
+}
+
+// statsdPackets joins lines into newline-separated packets of at most max
+// bytes. A single line longer than that gets a packet of its own.
+func statsdPackets(lines []string, max int) [][]byte {
+	var packets [][]byte
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > max {
+			packets = append(packets, packet)
+			packet = nil
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+// statsdName makes a device or interface name safe to use in a metric name.
+func statsdName(name string) string {
+	return statsdNameInvalid.ReplaceAllString(name, "_")
+}
+
+// statsdTagValue replaces the characters that delimit DogStatsD tags.
+func statsdTagValue(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(value)
+}
@@ -0,0 +1,129 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"cricket-collector/internal/collectors"
+)
+
+// readPackets returns the datagrams conn receives until none arrive for a
+// moment.
+func readPackets(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	var packets []string
+	buf := make([]byte, 65536)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return packets
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	payload := &collectors.MetricsPayload{
+		ServerName:    "web-1",
+		Hostname:      "web-1.example.com",
+		UptimeSeconds: 3600,
+	}
+	// Enough interfaces that the gauges need several packets
+	for i := 0; i < 40; i++ {
+		payload.NetworkInterfaces = append(payload.NetworkInterfaces, collectors.NetworkInterface{
+			Name:      fmt.Sprintf("eth%d", i),
+			RXBytes:   uint64(1000 + i),
+			TXBytes:   2000,
+			RXPackets: 10,
+			TXPackets: 20,
+		})
+	}
+
+	tests := []struct {
+		flavor string
+		line   *regexp.Regexp
+		want   []string
+	}{
+		{
+			flavor: "plain",
+			line:   regexp.MustCompile(`^cricket\.[a-zA-Z0-9_.-]+:[0-9.]+\|g$`),
+			want: []string{
+				"cricket.uptime_seconds:3600|g",
+				"cricket.network.eth0.rx_bytes:1000|g",
+				"cricket.network.eth39.rx_bytes:1039|g",
+			},
+		},
+		{
+			flavor: "dogstatsd",
+			line:   regexp.MustCompile(`^cricket\.[a-zA-Z0-9_.-]+:[0-9.]+\|g\|#server_name:web-1,hostname:web-1\.example\.com(,interface:eth[0-9]+)?$`),
+			want: []string{
+				"cricket.uptime_seconds:3600|g|#server_name:web-1,hostname:web-1.example.com",
+				"cricket.network.rx_bytes:1000|g|#server_name:web-1,hostname:web-1.example.com,interface:eth0",
+				"cricket.network.rx_bytes:1039|g|#server_name:web-1,hostname:web-1.example.com,interface:eth39",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			sink, err := NewStatsdSink(Config{StatsdAddr: conn.LocalAddr().String(), StatsdPrefix: "cricket.", StatsdFlavor: tt.flavor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Send(context.Background(), payload, true); err != nil {
+				t.Fatalf("Send() = %v", err)
+			}
+
+			packets := readPackets(t, conn)
+			if len(packets) < 2 {
+				t.Fatalf("got %d packets, want the gauges split over several", len(packets))
+			}
+			lines := map[string]bool{}
+			for _, packet := range packets {
+				if len(packet) > maxStatsdPacket {
+					t.Errorf("packet of %d bytes, want at most %d", len(packet), maxStatsdPacket)
+				}
+				for _, line := range strings.Split(packet, "\n") {
+					if !tt.line.MatchString(line) {
+						t.Errorf("line %q doesn't match %s", line, tt.line)
+					}
+					lines[line] = true
+				}
+			}
+			for _, line := range tt.want {
+				if !lines[line] {
+					t.Errorf("no line %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsdPackets(t *testing.T) {
+	lines := []string{"a:1|g", "b:2|g", "c:3|g", strings.Repeat("x", 20) + ":4|g", "d:5|g"}
+	got := statsdPackets(lines, 12)
+	want := []string{"a:1|g\nb:2|g", "c:3|g", strings.Repeat("x", 20) + ":4|g", "d:5|g"}
+	if len(got) != len(want) {
+		t.Fatalf("statsdPackets() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Errorf("packet %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"cricket-collector/internal/sender"
+)
+
+// setupLogging makes slog write to w as CRICKET_LOG_FORMAT asks,
+// "text" (key=value pairs) or "json" (one object per line, for log
+// pipelines), leaving out messages below level. Durations are written the
+// way Go prints them ("1.5s") rather than as nanoseconds, and secrets are
+// masked in messages, strings and errors (see sender.Redact). The log
+// package goes through it too.
+func setupLogging(w io.Writer, format string, level slog.Level) {
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			switch a.Value.Kind() {
+			case slog.KindDuration:
+				a.Value = slog.StringValue(a.Value.Duration().String())
+			case slog.KindString:
+				a.Value = slog.StringValue(sender.Redact(a.Value.String()))
+			case slog.KindAny:
+				if err, ok := a.Value.Any().(error); ok {
+					a.Value = slog.StringValue(sender.Redact(err.Error()))
+				}
+			}
+			return a
+		},
+	}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// parseLogLevel reads CRICKET_LOG_LEVEL: debug, info, warn or error.
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	switch strings.ToLower(value) {
+	case "debug":
+		level = slog.LevelDebug
+	case "info":
+		level = slog.LevelInfo
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return 0, fmt.Errorf("CRICKET_LOG_LEVEL must be debug, info, warn or error, not %q", value)
+	}
+	return level, nil
+}
+
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// secretValues are the configured secrets, for sender.SetSecrets.
+func (c *Config) secretValues() []string {
+	output := &c.Sender
+	values := []string{output.APIKey, output.InfluxToken}
+	for _, value := range output.OTLPHeaders {
+		values = append(values, value)
+	}
+	if u, err := url.Parse(output.ProxyURL); err == nil && u.User != nil {
+		password, _ := u.User.Password()
+		values = append(values, password)
+	}
+	return values
+}
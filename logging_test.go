@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cricket-collector/internal/collectors"
+	"cricket-collector/internal/sender"
+)
+
+func TestLogsNeverContainAPIKey(t *testing.T) {
+	const key = "ckt_perf_0123456789abcdef"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the key back, as a proxy dumping the request would
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid key","headers":{"Authorization":"` + r.Header.Get("Authorization") + `"}}`))
+	}))
+	defer server.Close()
+
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			defer slog.SetDefault(slog.Default())
+			config := Config{Sender: sender.Config{
+				APIBaseURL:      server.URL,
+				APIKey:          key,
+				PreflightPath:   "/api/ping",
+				IngestPath:      "/api/metrics/ingest",
+				IngestMethod:    http.MethodPost,
+				CollectInterval: 60,
+				Debug:           true,
+			}}
+			sender.SetSecrets(config.secretValues()...)
+			defer sender.SetSecrets()
+			setupLogging(&buf, format, slog.LevelDebug)
+
+			slog.Info("API key", "key", sender.MaskSecret(key))
+			slog.Debug("Request", "authorization", "Bearer "+key)
+			slog.Error("Send failed", "error", errors.New("rejected "+key))
+			log.Printf("Using key %s", key)
+
+			api, err := sender.NewAPI(&config.Sender)
+			if err != nil {
+				t.Fatal(err)
+			}
+			api.Preflight(context.Background())
+			if err := api.Send(context.Background(), &collectors.MetricsPayload{ServerName: "test"}, false); err != nil {
+				slog.Error("Error sending metrics", sinkErrorAttrs(api.Name(), err)...)
+			}
+
+			output := buf.String()
+			if strings.Contains(output, key) {
+				t.Errorf("log output contains the API key:\n%s", output)
+			}
+			if !strings.Contains(output, "...cdef") {
+				t.Errorf("log output is missing the masked key:\n%s", output)
+			}
+		})
+	}
+}
@@ -1,443 +1,953 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/host"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/mem"
-	"github.com/shirou/gopsutil/v3/net"
-	"github.com/shirou/gopsutil/v3/process"
+	"gopkg.in/yaml.v3"
+
+	"cricket-collector/internal/collectors"
+	"cricket-collector/internal/sender"
 )
 
-// Build-time variables (injected during build)
+// Build-time variables, injected with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
 var (
 	version = "dev"
 	commit  = "unknown"
 	date    = "unknown"
 )
 
-type Config struct {
-	APIBaseURL      string
-	APIKey          string
-	ServerName      string
-	CollectInterval int
-	Debug           bool
+// fillBuildInfo takes the commit and date from the VCS information Go embeds
+// in binaries built with "go build ." when they weren't injected.
+func fillBuildInfo() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && commit == "unknown":
+			commit = setting.Value
+		case setting.Key == "vcs.time" && date == "unknown":
+			date = setting.Value
+		}
+	}
 }
 
-type MetricsPayload struct {
-	// Server registration fields
-	ServerName      string            `json:"server_name"`
-	Hostname        string            `json:"hostname"`
-	IPAddress       string            `json:"ip_address,omitempty"`
-	OperatingSystem string            `json:"operating_system"`
-	Architecture    string            `json:"architecture"`
-	Tags            map[string]string `json:"tags,omitempty"`
-	
-	// System information
-	UptimeSeconds     uint64 `json:"uptime_seconds"`
-	BootTime          uint64 `json:"boot_time"`
-	KernelVersion     string `json:"kernel_version"`
-	PlatformFamily    string `json:"platform_family"`
-	PlatformVersion   string `json:"platform_version"`
-	CPUModel          string `json:"cpu_model"`
-	CPUCores          int32  `json:"cpu_cores"`
-	CPUThreads        int32  `json:"cpu_threads"`
-	TotalProcesses    uint64 `json:"total_processes"`
-	RunningProcesses  uint64 `json:"running_processes"`
-	SleepingProcesses uint64 `json:"sleeping_processes"`
-	HostID            string `json:"host_id"`
-	Virtualization    string `json:"virtualization"`
-	
-	// Metrics fields
-	Timestamp             string  `json:"timestamp"`
-	CPUUsagePercent       float64 `json:"cpu_usage_percent"`
-	CPULoad1m             float64 `json:"cpu_load_1m"`
-	CPULoad5m             float64 `json:"cpu_load_5m"`
-	CPULoad15m            float64 `json:"cpu_load_15m"`
-	MemoryUsagePercent    float64 `json:"memory_usage_percent"`
-	MemoryUsedBytes       uint64  `json:"memory_used_bytes"`
-	MemoryTotalBytes      uint64  `json:"memory_total_bytes"`
-	MemoryAvailableBytes  uint64  `json:"memory_available_bytes"`
-	SwapUsedBytes         uint64  `json:"swap_used_bytes"`
-	SwapTotalBytes        uint64  `json:"swap_total_bytes"`
-	DiskUsagePercent      float64 `json:"disk_usage_percent"`
-	DiskUsedBytes         uint64  `json:"disk_used_bytes"`
-	DiskTotalBytes        uint64  `json:"disk_total_bytes"`
-	DiskAvailableBytes    uint64  `json:"disk_available_bytes"`
-	DiskReadBytes         uint64  `json:"disk_read_bytes"`
-	DiskWriteBytes        uint64  `json:"disk_write_bytes"`
-	DiskReadOps           uint64  `json:"disk_read_ops"`
-	DiskWriteOps          uint64  `json:"disk_write_ops"`
-	DiskIOTime            uint64  `json:"disk_io_time"`
-	NetworkRXBytes        uint64  `json:"network_rx_bytes"`
-	NetworkTXBytes        uint64  `json:"network_tx_bytes"`
-	NetworkRXPackets      uint64  `json:"network_rx_packets"`
-	NetworkTXPackets      uint64  `json:"network_tx_packets"`
-	NetworkRXErrors       uint64  `json:"network_rx_errors"`
-	NetworkTXErrors       uint64  `json:"network_tx_errors"`
-	
-	// Per-disk information
-	DiskDevices           []DiskDevice `json:"disk_devices,omitempty"`
+// userAgent identifies the collector and its version in every HTTP request it
+// makes: ingest, preflight, InfluxDB writes, probes and HTTP checks.
+func userAgent() string {
+	return fmt.Sprintf("cricket-collector/%s (%s/%s)", version, runtime.GOOS, runtime.GOARCH)
 }
 
-type DiskDevice struct {
-	Device          string  `json:"device"`
-	Mountpoint      string  `json:"mountpoint"`
-	Filesystem      string  `json:"filesystem"`
-	UsagePercent    float64 `json:"usage_percent"`
-	UsedBytes       uint64  `json:"used_bytes"`
-	TotalBytes      uint64  `json:"total_bytes"`
-	AvailableBytes  uint64  `json:"available_bytes"`
-	ReadBytes       uint64  `json:"read_bytes,omitempty"`
-	WriteBytes      uint64  `json:"write_bytes,omitempty"`
-	ReadOps         uint64  `json:"read_ops,omitempty"`
-	WriteOps        uint64  `json:"write_ops,omitempty"`
+// Exit codes of run-once mode, besides 0 for success and 2 for usage errors.
+// A send failure wins over failed collectors when both happen.
+const (
+	exitSendFailed       = 1
+	exitCollectionFailed = 3
+)
+
+type Config struct {
+	CollectInterval int
+	CollectTimeout  time.Duration
+	IntervalJitter  float64
+	StartupJitter   bool
+	ShutdownTimeout time.Duration
+	RunOnce         bool
+	DryRun          bool
+	PrometheusAddr  string
+	HealthAddr      string
+	PprofAddr       string
+	CloudMetadata   string
+	Debug           bool
+	LogFormat       string
+	LogLevel        slog.Level
+	Collectors      collectors.Config
+	Sender          sender.Config
 }
 
 func main() {
-	// Check for version flag
-	if len(os.Args) > 1 && (os.Args[1] == "--version" || os.Args[1] == "-v") {
-		fmt.Printf("Cricket Monitor Collector\n")
-		fmt.Printf("Version: %s\n", version)
-		fmt.Printf("Commit: %s\n", commit)
-		fmt.Printf("Built: %s\n", date)
-		fmt.Printf("Go Version: %s\n", runtime.Version())
-		fmt.Printf("OS/Arch: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fillBuildInfo()
+	// Until the configuration is read, in the format the environment asks for
+	setupLogging(os.Stderr, strings.ToLower(os.Getenv("CRICKET_LOG_FORMAT")), slog.LevelInfo)
+
+	opts := parseFlags()
+	if opts.version {
+		printVersion()
 		return
 	}
 
+	// "cricket-collector replay <file>" sends a file written by
+	// CRICKET_FILE_SINK to the API, then exits
+	var replayPath string
+	if len(opts.args) > 0 {
+		if opts.args[0] != "replay" || len(opts.args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		replayPath = opts.args[1]
+	}
+
+	config, configPath, err := readConfig(opts)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	sender.SetSecrets(config.secretValues()...)
+	setupLogging(os.Stderr, config.LogFormat, config.LogLevel)
+	if opts.printConfig {
+		printConfig(configPath)
+		return
+	}
+	if replayPath != "" {
+		// Replays always go to the API, whatever outputs are configured
+		config.Sender.HTTPSink = true
+		if config.Sender.APIKey == "" {
+			fatal("CRICKET_API_KEY or CRICKET_API_KEY_FILE (--api-key-file) is required")
+		}
+	}
+	if err := config.validate(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	output := &config.Sender
+
+	slog.Info("Starting Cricket Performance Collector", "version", version)
+	if config.DryRun {
+		slog.Info("Dry run: payloads are printed to stdout and never sent")
+	}
+	if configPath != "" {
+		slog.Info("Config file", "path", configPath)
+	}
+	if apiURL, err := url.Parse(output.APIBaseURL); err == nil {
+		slog.Info("API URL", "url", apiURL.Redacted())
+	}
+	for _, fallback := range output.FallbackURLs {
+		if fallbackURL, err := url.Parse(fallback); err == nil {
+			slog.Info("Fallback API URL", "url", fallbackURL.Redacted())
+		}
+	}
+	if output.APIKey != "" {
+		slog.Info("API key", "key", sender.MaskSecret(output.APIKey))
+	}
+	if output.ProxyURL != "" {
+		if proxyURL, err := url.Parse(output.ProxyURL); err == nil {
+			slog.Info("Proxy", "url", proxyURL.Redacted(), "no_proxy", output.NoProxy)
+		}
+	} else {
+		// Report what the HTTPS_PROXY / NO_PROXY environment picks for the API
+		var proxyURL *url.URL
+		if apiURL, err := url.Parse(output.APIBaseURL); err == nil {
+			proxyURL, _ = http.ProxyFromEnvironment(&http.Request{URL: apiURL})
+		}
+		if proxyURL != nil {
+			slog.Info("Proxy", "url", proxyURL.Redacted(), "source", "environment")
+		} else {
+			slog.Info("Proxy", "url", "none")
+		}
+	}
+	slog.Info("Server name", "server_name", config.Collectors.ServerName)
+	slog.Info("Collection interval", "interval", time.Duration(config.CollectInterval)*time.Second,
+		"collect_timeout", config.CollectTimeout, "collector_timeout", config.Collectors.Timeout)
+	if config.IntervalJitter > 0 {
+		slog.Info("Interval jitter", "percent", config.IntervalJitter*100)
+	}
+	slog.Info("Root disk path", "path", config.Collectors.RootDiskPath)
+	slog.Debug("Filesystem types",
+		"excluded", strings.Join(config.Collectors.FSTypes.Exclude, ","), "included", strings.Join(config.Collectors.FSTypes.Include, ","))
+
+	if _, err := os.Stat(config.Collectors.RootDiskPath); err != nil {
+		slog.Warn("CRICKET_ROOT_DISK_PATH is not accessible, disk usage will not be reported", "path", config.Collectors.RootDiskPath, "error", err)
+	}
+
+	if output.InfluxURL != "" {
+		slog.Info("InfluxDB output", "url", output.InfluxURL, "bucket", output.InfluxBucket)
+	}
+	if output.StatsdAddr != "" {
+		slog.Info("StatsD output", "addr", output.StatsdAddr, "flavor", output.StatsdFlavor, "prefix", output.StatsdPrefix)
+	}
+	if output.OTLPEndpoint != "" {
+		slog.Info("OTLP output", "endpoint", output.OTLPEndpoint)
+	}
+	if output.FileSink != "" {
+		slog.Info("File output", "path", output.FileSink, "max_bytes", output.FileMaxBytes, "keep", output.FileKeep, "fsync", output.FileSync)
+	}
+
+	if output.TLSInsecure {
+		slog.Warn("CRICKET_TLS_INSECURE_SKIP_VERIFY is set, the API server certificate is NOT verified. Never use this outside a lab.")
+	}
+
+	// Probed once, finding none included, and reloads add them to the
+	// configured tags again
+	var cloudTags map[string]string
+	if config.CloudMetadata != "off" {
+		cloudTags = collectors.ProbeCloudMetadata(config.CloudMetadata)
+		if len(cloudTags) > 0 {
+			slog.Info("Cloud instance", "cloud", cloudTags["cloud"], "instance_id", cloudTags["instance_id"],
+				"instance_type", cloudTags["instance_type"], "region", cloudTags["region"], "availability_zone", cloudTags["availability_zone"])
+			config.Collectors.Tags = withCloudTags(cloudTags, config.Collectors.Tags)
+		} else {
+			slog.Info("No cloud instance metadata found", "mode", config.CloudMetadata)
+		}
+	}
+
+	agent, err := newAgent(config)
+	if err != nil {
+		fatal("Failed to initialize collector", "error", err)
+	}
+	slog.Info("Collectors", "active", strings.Join(agent.registry.Active(), ","))
+	if output.SpoolDir != "" && agent.api != nil {
+		slog.Info("Spool", "dir", output.SpoolDir, "max_bytes", output.SpoolMaxBytes, "max_age", output.SpoolMaxAge)
+	}
+	// A wrong URL or revoked key would otherwise only show up as a send
+	// error every interval. Failures are logged, not fatal: the API may just
+	// be down for now, and metrics are retried (or spooled) meanwhile
+	if !config.DryRun && agent.api != nil && output.PreflightPath != "off" {
+		agent.api.Preflight(context.Background())
+	}
+	if replayPath != "" {
+		if err := agent.api.Replay(context.Background(), replayPath); err != nil {
+			fatal("Replay failed", "error", err)
+		}
+		return
+	}
+	if config.PrometheusAddr != "" && !config.RunOnce {
+		exporter := &sender.PrometheusExporter{}
+		server, err := exporter.Serve(config.PrometheusAddr)
+		if err != nil {
+			fatal("Failed to start Prometheus endpoint", "error", err)
+		}
+		defer server.Close()
+		agent.exporter = exporter
+		slog.Info("Prometheus metrics", "url", "http://"+config.PrometheusAddr+"/metrics")
+	}
+	if config.HealthAddr != "" && !config.RunOnce {
+		server, err := agent.health.Serve(config.HealthAddr)
+		if err != nil {
+			fatal("Failed to start health endpoint", "error", err)
+		}
+		defer server.Close()
+		slog.Info("Health check", "url", "http://"+config.HealthAddr+"/healthz")
+	}
+	if config.PprofAddr != "" && !config.RunOnce {
+		server, err := servePprof(config.PprofAddr)
+		if err != nil {
+			fatal("Failed to start pprof endpoint", "error", err)
+		}
+		defer server.Close()
+		slog.Warn("Profiling endpoint enabled, keep it on a private address", "url", "http://"+config.PprofAddr+"/debug/pprof/")
+	}
+
+	// Single collection for cron and testing, the exit code reports whether
+	// it was collected and delivered
+	if config.RunOnce {
+		err := agent.collectAndSendMetrics(context.Background())
+		switch {
+		case err == nil:
+			return
+		case errors.Is(err, errSendFailed):
+			os.Exit(exitSendFailed)
+		case errors.Is(err, errCollectionFailed):
+			slog.Error("Run failed", "error", err)
+			os.Exit(exitCollectionFailed)
+		default:
+			slog.Error("Run failed", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// ctx is cancelled once the shutdown deadline passes, aborting any
+	// collection or send that is still in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shutdown := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		sig := <-signals
+		slog.Info("Shutting down", "signal", sig.String(), "timeout", config.ShutdownTimeout)
+		close(shutdown)
+		time.AfterFunc(config.ShutdownTimeout, cancel)
+	}()
+
+	interval := time.Duration(config.CollectInterval) * time.Second
+
+	// Spread out collectors that were all started at once (a fleet-wide
+	// deploy) so they don't hit the API in the same second every interval
+	if config.StartupJitter && interval > 0 {
+		delay := time.Duration(rand.Int63n(int64(interval)))
+		slog.Info("Delaying the first collection (CRICKET_STARTUP_JITTER)", "delay", delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-shutdown:
+			slog.Info("Collector stopped")
+			return
+		}
+	}
+
+	// Collect metrics immediately on startup
+	agent.collectAndSendMetrics(ctx)
+
+	// Then collect on interval until asked to stop
+	timer := time.NewTimer(jitteredInterval(interval, config.IntervalJitter))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(jitteredInterval(interval, config.IntervalJitter))
+			// In the background, so a cycle running long can't hold up
+			// shutdown; the next tick is skipped if it's still going
+			go agent.collectAndSendMetrics(ctx)
+		case interval = <-agent.reschedule:
+			// The API changed the interval, which applies from now on
+			// rather than after the old interval runs out
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(jitteredInterval(interval, config.IntervalJitter))
+		case <-hangups:
+			slog.Info("Received SIGHUP, reloading the configuration")
+			agent.reload(func() (Config, error) {
+				next, _, err := readConfig(opts)
+				if err == nil {
+					err = next.validate()
+				}
+				if err != nil {
+					return Config{}, err
+				}
+				sender.SetSecrets(next.secretValues()...)
+				setupLogging(os.Stderr, next.LogFormat, next.LogLevel)
+				next.Collectors.Tags = withCloudTags(cloudTags, next.Collectors.Tags)
+				return next, nil
+			})
+		case <-shutdown:
+			// One last collection so the final state before stopping is recorded
+			agent.collectAndSendFinal(ctx)
+			slog.Info("Collector stopped")
+			return
+		}
+	}
+}
+
+// readConfig builds the Config from the command line flags, the environment
+// (and .env) and the config file, in that order of precedence, and returns
+// the path of the config file it read. It runs at startup and again on every
+// SIGHUP, starting over from the environment the process was started with,
+// so settings taken out of .env or the config file since go back to their
+// defaults.
+func readConfig(opts options) (Config, string, error) {
+	restoreEnv()
+	readSettings = map[string]setting{}
+	fromConfigFile = map[string]bool{}
+	if err := applyFlags(opts); err != nil {
+		return Config{}, "", err
+	}
+
 	// Load environment variables
 	godotenv.Load()
 
+	// Settings from a config file fill in whatever the environment left
+	// unset. The default file is optional, one asked for has to exist
+	configPath := opts.configPath
+	if configPath == "" {
+		configPath = os.Getenv("CRICKET_CONFIG")
+	}
+	explicitConfig := configPath != ""
+	if !explicitConfig {
+		configPath = defaultConfigPath
+	}
+	fileKeys, err := loadConfigFile(configPath)
+	if err != nil {
+		if explicitConfig || !errors.Is(err, fs.ErrNotExist) {
+			return Config{}, "", fmt.Errorf("failed to load config file: %w", err)
+		}
+		configPath = ""
+	}
+
+	// CRICKET_API_URL may list fallbacks itself, for those who'd rather
+	// keep the endpoints in one place
+	var apiURLs []string
+	for _, apiURL := range append(collectors.SplitList(getEnv("CRICKET_API_URL", "https://collector.cricketmon.io")),
+		collectors.SplitList(getEnv("CRICKET_API_FALLBACK_URLS", ""))...) {
+		apiURLs = append(apiURLs, strings.TrimRight(apiURL, "/"))
+	}
+	if len(apiURLs) == 0 {
+		apiURLs = []string{"https://collector.cricketmon.io"}
+	}
+
 	config := Config{
-		APIBaseURL:      "https://collector.cricketmon.io",
-		APIKey:          getEnv("CRICKET_API_KEY", ""),
-		ServerName:      getEnv("CRICKET_SERVER_NAME", ""),
 		CollectInterval: getEnvInt("CRICKET_COLLECT_INTERVAL", 60),
+		IntervalJitter:  parseJitter(getEnv("CRICKET_INTERVAL_JITTER", "")),
+		StartupJitter:   getEnvBool("CRICKET_STARTUP_JITTER", false),
+		ShutdownTimeout: getEnvDuration("CRICKET_SHUTDOWN_TIMEOUT", 10*time.Second),
+		RunOnce:         getEnvBool("CRICKET_RUN_ONCE", false),
+		DryRun:          getEnvBool("CRICKET_DRY_RUN", false),
+		PrometheusAddr:  getEnv("CRICKET_PROMETHEUS_ADDR", ""),
+		HealthAddr:      getEnv("CRICKET_HEALTH_ADDR", ""),
+		PprofAddr:       getEnv("CRICKET_PPROF_ADDR", ""),
 		Debug:           getEnvBool("CRICKET_DEBUG", false),
+		Collectors: collectors.Config{
+			ServerName:      getEnv("CRICKET_SERVER_NAME", ""),
+			HostnameSource:  getEnv("CRICKET_HOSTNAME_SOURCE", ""),
+			IPAddress:       getEnv("CRICKET_IP_ADDRESS", ""),
+			CollectPerCPU:   getEnvBool("CRICKET_PER_CPU", getEnvBool("CRICKET_COLLECT_PERCPU", false)),
+			CPUSampleWindow: getEnvDuration("CRICKET_CPU_SAMPLE_DURATION", time.Second),
+			RawCounters:     getEnvBool("CRICKET_RAW_COUNTERS", true),
+			RootDiskPath:    getEnv("CRICKET_ROOT_DISK_PATH", collectors.DefaultRootDiskPath()),
+			NetPerInterface: getEnvBool("CRICKET_NET_PER_INTERFACE", true),
+			FSTypes:         collectors.NewFSTypeFilter(getEnv("CRICKET_FS_EXCLUDE", "")+","+getEnv("CRICKET_SKIP_FSTYPES", ""), getEnv("CRICKET_FS_INCLUDE", "")),
+			SkipReadOnly:    getEnvBool("CRICKET_SKIP_READONLY", false),
+			Mounts:          collectors.GlobFilter{Include: collectors.SplitList(getEnv("CRICKET_DISK_INCLUDE", getEnv("CRICKET_MOUNT_INCLUDE", ""))), Exclude: collectors.SplitList(getEnv("CRICKET_DISK_EXCLUDE", getEnv("CRICKET_MOUNT_EXCLUDE", "")))},
+			SelfMetrics:     getEnvBool("CRICKET_SELF_METRICS", false),
+			ProcessCounts:   getEnvBool("CRICKET_COLLECT_PROCESS_COUNTS", true),
+			TopProcesses:    getEnvInt("CRICKET_TOP_PROCESSES", getEnvInt("CRICKET_PROCESS_TOP_N", 0)),
+			TCPStats:        getEnvBool("CRICKET_TCP_STATS", getEnvBool("CRICKET_COLLECT_CONNECTIONS", false)),
+			CollectTemps:    getEnvBool("CRICKET_COLLECT_TEMPS", false),
+			TempSensors:     collectors.NewSensorFilter(getEnv("CRICKET_TEMP_SENSORS", ""), getEnv("CRICKET_TEMP_SENSORS_EXCLUDE", "")),
+			PSI:             getEnvBool("CRICKET_PSI", true),
+			SystemdUnits:    collectors.SplitList(getEnv("CRICKET_SYSTEMD_UNITS", "")),
+			SMART:           getEnvBool("CRICKET_SMART", false),
+			ZFS:             strings.ToLower(getEnv("CRICKET_ZFS", "auto")),
+			ProbeTargets:    collectors.SplitList(getEnv("CRICKET_PING_TARGETS", getEnv("CRICKET_PROBE_TARGETS", ""))),
+			ProbeTimeout:    getEnvDuration("CRICKET_PROBE_TIMEOUT", 5*time.Second),
+			CgroupAware:     strings.ToLower(getEnv("CRICKET_CGROUP_AWARE", "auto")),
+			HTTPChecks:      collectors.ParseHTTPChecks(getEnv("CRICKET_HTTP_CHECKS", ""), getEnvDuration("CRICKET_HTTP_CHECK_TIMEOUT", 10*time.Second)),
+			TLSChecks:       collectors.SplitList(getEnv("CRICKET_TLS_CHECKS", "")),
+			WatchProcesses:  collectors.ParseProcessWatches(getEnv("CRICKET_WATCH_PROCESSES", "")),
+			Enabled:         collectors.SplitList(getEnv("CRICKET_COLLECTORS_ENABLE", "")),
+			Disabled:        collectors.SplitList(getEnv("CRICKET_COLLECTORS_DISABLE", "")),
+			Timeout:         getEnvDuration("CRICKET_COLLECTOR_TIMEOUT", 5*time.Second),
+			Version:         version,
+			UserAgent:       userAgent(),
+		},
+		Sender: sender.Config{
+			APIBaseURL:      apiURLs[0],
+			FallbackURLs:    apiURLs[1:],
+			APIKey:          getEnv("CRICKET_API_KEY", ""),
+			APIKeyFile:      getEnv("CRICKET_API_KEY_FILE", ""),
+			HTTPTimeout:     getEnvDuration("CRICKET_HTTP_TIMEOUT", 30*time.Second),
+			PreflightPath:   getEnv("CRICKET_PREFLIGHT_PATH", "/api/ping"),
+			IngestPath:      "/" + strings.Trim(getEnv("CRICKET_INGEST_PATH", "/api/metrics/ingest"), "/"),
+			IngestMethod:    strings.ToUpper(getEnv("CRICKET_INGEST_METHOD", "POST")),
+			SuccessStatus:   sender.ParseStatusCodes(getEnv("CRICKET_SUCCESS_STATUS", "")),
+			SpoolDir:        getEnv("CRICKET_SPOOL_DIR", ""),
+			SpoolMaxBytes:   int64(getEnvInt("CRICKET_SPOOL_MAX_BYTES", getEnvInt("CRICKET_SPOOL_MAX_MB", 10)*1024*1024)),
+			SpoolMaxAge:     getEnvDuration("CRICKET_SPOOL_MAX_AGE", 24*time.Hour),
+			BatchSize:       getEnvInt("CRICKET_BATCH_SIZE", 1),
+			SendRetries:     getEnvInt("CRICKET_SEND_RETRIES", getEnvInt("CRICKET_MAX_RETRIES", 3)),
+			SendBackoffBase: getEnvDuration("CRICKET_SEND_BACKOFF_BASE", getEnvDuration("CRICKET_RETRY_BACKOFF", 500*time.Millisecond)),
+			Compress:        getEnvBool("CRICKET_COMPRESS", false),
+			TLSCAFile:       getEnv("CRICKET_TLS_CA_FILE", getEnv("CRICKET_TLS_CA_CERT", "")),
+			TLSCertFile:     getEnv("CRICKET_TLS_CERT_FILE", getEnv("CRICKET_TLS_CLIENT_CERT", "")),
+			TLSKeyFile:      getEnv("CRICKET_TLS_KEY_FILE", getEnv("CRICKET_TLS_CLIENT_KEY", "")),
+			TLSInsecure:     getEnvBool("CRICKET_TLS_INSECURE_SKIP_VERIFY", false),
+			ProxyURL:        getEnv("CRICKET_PROXY_URL", ""),
+			NoProxy:         getEnv("CRICKET_NO_PROXY", ""),
+			InfluxURL:       strings.TrimRight(getEnv("CRICKET_INFLUX_URL", ""), "/"),
+			InfluxToken:     getEnv("CRICKET_INFLUX_TOKEN", ""),
+			InfluxOrg:       getEnv("CRICKET_INFLUX_ORG", ""),
+			InfluxBucket:    getEnv("CRICKET_INFLUX_BUCKET", ""),
+			StatsdAddr:      getEnv("CRICKET_STATSD_ADDR", ""),
+			StatsdFlavor:    strings.ToLower(getEnv("CRICKET_STATSD_FLAVOR", "plain")),
+			StatsdPrefix:    getEnv("CRICKET_STATSD_PREFIX", "cricket."),
+			OTLPEndpoint:    strings.TrimRight(getEnv("CRICKET_OTLP_ENDPOINT", ""), "/"),
+			OTLPHeaders:     sender.ParseOTLPHeaders(getEnv("CRICKET_OTLP_HEADERS", "")),
+			FileSink:        getEnv("CRICKET_FILE_SINK", ""),
+			FileMaxBytes:    int64(getEnvInt("CRICKET_FILE_SINK_MAX_MB", 100)) * 1024 * 1024,
+			FileKeep:        getEnvInt("CRICKET_FILE_SINK_KEEP", 5),
+			FileSync:        getEnvBool("CRICKET_FILE_SINK_FSYNC", false),
+			HTTPSink:        getEnvBool("CRICKET_HTTP_SINK", true),
+			Version:         version,
+			UserAgent:       userAgent(),
+		},
+	}
+	// CRICKET_DEBUG=true (or --debug) turns on debug logs whatever the level
+	// says, and the debug level turns on the extra work done only for them
+	if config.LogLevel, err = parseLogLevel(getEnv("CRICKET_LOG_LEVEL", "info")); err != nil {
+		return Config{}, "", err
+	}
+	if config.Debug {
+		config.LogLevel = slog.LevelDebug
+	}
+	config.Debug = config.LogLevel <= slog.LevelDebug
+	config.LogFormat = strings.ToLower(getEnv("CRICKET_LOG_FORMAT", "text"))
+	if config.LogFormat != "text" && config.LogFormat != "json" {
+		return Config{}, "", fmt.Errorf("CRICKET_LOG_FORMAT must be text or json, not %q", config.LogFormat)
+	}
+	config.Collectors.Debug = config.Debug
+	config.Sender.Debug = config.Debug
+
+	// The key file wins over CRICKET_API_KEY; it's read again whenever it
+	// changes (see sender.API), so rotating it needs no restart
+	if config.Sender.APIKeyFile != "" {
+		if config.Sender.APIKey, err = sender.ReadAPIKeyFile(config.Sender.APIKeyFile); err != nil {
+			return Config{}, "", err
+		}
+	}
+
+	tags, err := configTags()
+	if err != nil {
+		return Config{}, "", err
+	}
+	config.Collectors.Tags = tags
+
+	// CRICKET_CLOUD_TAGS=true is short for CRICKET_CLOUD_METADATA=auto
+	cloudMetadata := "off"
+	if getEnvBool("CRICKET_CLOUD_TAGS", false) {
+		cloudMetadata = "auto"
+	}
+	config.CloudMetadata = strings.ToLower(getEnv("CRICKET_CLOUD_METADATA", cloudMetadata))
+	// Send retries stop before they would run into the next collection
+	config.Sender.CollectInterval = config.CollectInterval
+
+	// Interface filters: CRICKET_NET_INTERFACES ("!" prefix excludes) plus
+	// CRICKET_NET_INCLUDE / CRICKET_NET_EXCLUDE, which by default leaves out
+	// loopback and container plumbing so the totals reflect external traffic
+	filter := collectors.ParseGlobFilter(getEnv("CRICKET_NET_INTERFACES", ""))
+	filter.Include = append(filter.Include, collectors.SplitList(getEnv("CRICKET_NET_INCLUDE", ""))...)
+	filter.Exclude = append(filter.Exclude, collectors.SplitList(getEnv("CRICKET_NET_EXCLUDE", "lo,veth*,docker*,br-*"))...)
+	if getEnvBool("CRICKET_NET_FILTER", true) {
+		config.Collectors.NetInterfaces = filter
+	}
+
+	// The IP address is the one used to reach the API, unless told otherwise
+	config.Collectors.IPDetectTarget = getEnv("CRICKET_IP_DETECT_TARGET", apiHostPort(config.Sender.APIBaseURL))
+
+	// Listing units to watch turns on the systemd collector too
+	config.Collectors.Systemd = getEnvBool("CRICKET_SYSTEMD", len(config.Collectors.SystemdUnits) > 0)
+
+	// Collection must finish well within the interval, so by default it gets half of it
+	config.CollectTimeout = getEnvDuration("CRICKET_COLLECT_TIMEOUT", time.Duration(config.CollectInterval)*time.Second/2)
+	if interval := time.Duration(config.CollectInterval) * time.Second; config.CollectTimeout > interval {
+		slog.Warn("CRICKET_COLLECT_TIMEOUT is longer than the collection interval, using the interval", "collect_timeout", config.CollectTimeout, "interval", interval)
+		config.CollectTimeout = interval
 	}
 
-	if config.APIKey == "" {
-		log.Fatal("CRICKET_API_KEY environment variable is required")
+	// Keys in the config file that no setting was read for are most likely
+	// typos, or settings from a newer version
+	var unknown []string
+	for _, name := range fileKeys {
+		if _, known := readSettings[name]; !known {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		slog.Warn("Ignoring unknown settings in the config file", "path", configPath, "settings", strings.Join(unknown, ","))
 	}
 
-	if config.ServerName == "" {
-		hostname, err := os.Hostname()
+	if config.Collectors.ServerName == "" {
+		hostname, err := collectors.ServerName(config.Collectors.HostnameSource)
 		if err != nil {
-			log.Fatal("Failed to get hostname and CRICKET_SERVER_NAME not set:", err)
+			return Config{}, "", fmt.Errorf("failed to get hostname and CRICKET_SERVER_NAME not set: %w", err)
 		}
-		config.ServerName = hostname
+		config.Collectors.ServerName = hostname
 	}
 
-	log.Printf("Starting Cricket Performance Collector")
-	log.Printf("API URL: %s", config.APIBaseURL)
-	log.Printf("Server Name: %s", config.ServerName)
-	log.Printf("Collection Interval: %d seconds", config.CollectInterval)
+	return config, configPath, nil
+}
 
-	// Start metrics collection loop
-	ticker := time.NewTicker(time.Duration(config.CollectInterval) * time.Second)
-	defer ticker.Stop()
+// processEnv is the environment the collector was started with, before
+// flags, .env and the config file were added to it.
+var processEnv = os.Environ()
+
+// restoreEnv puts the environment back the way the collector was started
+// with. Only variables that differ are touched, so anything reading the
+// environment meanwhile doesn't see the others go missing.
+func restoreEnv() {
+	original := map[string]string{}
+	for _, entry := range processEnv {
+		name, value, _ := strings.Cut(entry, "=")
+		original[name] = value
+	}
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if name == "" {
+			continue
+		}
+		if originalValue, ok := original[name]; !ok {
+			os.Unsetenv(name)
+		} else if value != originalValue {
+			os.Setenv(name, originalValue)
+		}
+	}
+}
 
-	// Collect metrics immediately on startup
-	collectAndSendMetrics(config)
+// validate checks that the outputs are usable. Dry runs never talk to the
+// API, so they work on a box that isn't enrolled yet. Without an API key,
+// the other outputs can be used on their own.
+func (c *Config) validate() error {
+	if c.CollectInterval < 1 {
+		return fmt.Errorf("CRICKET_COLLECT_INTERVAL must be at least 1 second, not %d", c.CollectInterval)
+	}
+	output := &c.Sender
+	otherOutputs := output.InfluxURL != "" || output.StatsdAddr != "" || output.OTLPEndpoint != "" || output.FileSink != ""
+	if output.HTTPSink && output.APIKey == "" && !c.DryRun {
+		if !otherOutputs {
+			return errors.New("CRICKET_API_KEY or CRICKET_API_KEY_FILE (--api-key-file) is required")
+		}
+		slog.Info("CRICKET_API_KEY is not set, metrics are not sent to the Cricket API")
+		output.HTTPSink = false
+	}
+	if !output.HTTPSink && !otherOutputs && !c.DryRun {
+		return errors.New("CRICKET_HTTP_SINK is off and no other output is configured")
+	}
+	if output.InfluxURL != "" && output.InfluxBucket == "" {
+		return errors.New("CRICKET_INFLUX_BUCKET is required with CRICKET_INFLUX_URL")
+	}
+	if output.StatsdAddr != "" && output.StatsdFlavor != "plain" && output.StatsdFlavor != "dogstatsd" {
+		return fmt.Errorf("CRICKET_STATSD_FLAVOR must be plain or dogstatsd, not %q", output.StatsdFlavor)
+	}
+	return nil
+}
+
+// withCloudTags returns the cloud metadata tags with the configured tags
+// added, which can override the cloud ones.
+func withCloudTags(cloud, configured map[string]string) map[string]string {
+	if len(cloud) == 0 {
+		return configured
+	}
+	tags := make(map[string]string, len(cloud)+len(configured))
+	for key, value := range cloud {
+		tags[key] = value
+	}
+	for key, value := range configured {
+		tags[key] = value
+	}
+	return tags
+}
+
+// parseJitter reads CRICKET_INTERVAL_JITTER, a percentage of the interval
+// ("10%" or "10"), as a fraction below 1.
+func parseJitter(value string) float64 {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	if value == "" {
+		return 0
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent >= 100 {
+		slog.Warn("Invalid CRICKET_INTERVAL_JITTER, expected a percentage from 0 to 99", "value", value)
+		return 0
+	}
+	return percent / 100
+}
+
+// jitteredInterval returns interval moved by a random amount of up to
+// ±jitter of it, so the average interval stays the same.
+func jitteredInterval(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration((rand.Float64()*2-1)*jitter*float64(interval))
+}
+
+// defaultConfigPath is loaded when neither --config nor CRICKET_CONFIG names
+// a config file, if it exists.
+const defaultConfigPath = "/etc/cricket/collector.yaml"
+
+// loadConfigFile reads a YAML or JSON config file (chosen by extension) and
+// exports every setting as its CRICKET_* environment variable, unless that
+// variable is already set. Keys are the variable names without the prefix,
+// in any case, and nested sections are joined with underscores, so
+// "collect_interval: 30" and "tls: {ca_file: ...}" map to
+// CRICKET_COLLECT_INTERVAL and CRICKET_TLS_CA_FILE. Lists become
+// comma-separated values. It returns the variable names of every key in the
+// file.
+//
+// Precedence, highest first: environment (including .env), config file,
+// built-in defaults.
+func loadConfigFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-	// Then collect on interval
-	for range ticker.C {
-		collectAndSendMetrics(config)
+	var settings map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &settings)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var names []string
+	for key, value := range flattenSettings("", settings) {
+		name := strings.ToUpper(key)
+		if !strings.HasPrefix(name, "CRICKET_") {
+			name = "CRICKET_" + name
+		}
+		names = append(names, name)
+		if _, set := os.LookupEnv(name); !set {
+			os.Setenv(name, value)
+			fromConfigFile[name] = true
+		}
 	}
+	return names, nil
+}
+
+func flattenSettings(prefix string, settings map[string]interface{}) map[string]string {
+	flat := map[string]string{}
+	for key, value := range settings {
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for k, fv := range flattenSettings(key, v) {
+				flat[k] = fv
+			}
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = settingString(item)
+			}
+			flat[key] = strings.Join(items, ",")
+		default:
+			flat[key] = settingString(v)
+		}
+	}
+	return flat
+}
+
+func settingString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		// JSON numbers are float64, avoid exponent notation for large integers
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// setting is the effective value of a CRICKET_* variable and where it came
+// from: "flag", "env", "file" or "default".
+type setting struct {
+	value  string
+	source string
+}
+
+var (
+	// Every setting the getEnv functions read while building the Config,
+	// for --print-config and the unknown config file key warning
+	readSettings = map[string]setting{}
+	// The variables loadConfigFile set from the config file
+	fromConfigFile = map[string]bool{}
+)
+
+// recordSetting notes the value a setting ended up with; set is whether it
+// came from the environment (or the config file) rather than the default.
+func recordSetting(key, value string, set bool) {
+	source := "default"
+	if set {
+		source = "env"
+		if fromFlags[key] {
+			source = "flag"
+		} else if fromConfigFile[key] {
+			source = "file"
+		}
+	}
+	readSettings[key] = setting{value: value, source: source}
 }
 
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
+		recordSetting(key, value, true)
 		return value
 	}
+	recordSetting(key, defaultValue, false)
 	return defaultValue
 }
 
 func getEnvInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intVal, err := strconv.Atoi(value); err == nil {
+			recordSetting(key, value, true)
 			return intVal
 		}
 	}
+	recordSetting(key, strconv.Itoa(defaultValue), false)
+	return defaultValue
+}
+
+// getEnvDuration accepts Go duration strings ("500ms", "2m") or a bare
+// number of seconds, matching how the other interval settings are written.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			recordSetting(key, value, true)
+			return time.Duration(seconds) * time.Second
+		}
+		if duration, err := time.ParseDuration(value); err == nil {
+			recordSetting(key, value, true)
+			return duration
+		}
+	}
+	recordSetting(key, defaultValue.String(), false)
 	return defaultValue
 }
 
 func getEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolVal, err := strconv.ParseBool(value); err == nil {
+			recordSetting(key, value, true)
 			return boolVal
 		}
 	}
+	recordSetting(key, strconv.FormatBool(defaultValue), false)
 	return defaultValue
 }
 
+// secretSettings are printed by --print-config with all but their last 4
+// characters masked.
+var secretSettings = map[string]bool{
+	"CRICKET_API_KEY":      true,
+	"CRICKET_INFLUX_TOKEN": true,
+	"CRICKET_OTLP_HEADERS": true,
+}
 
-func collectAndSendMetrics(config Config) {
-	payload, err := collectSystemMetrics(config)
-	if err != nil {
-		log.Printf("Error collecting metrics: %v", err)
-		return
+// printConfig writes every setting with its effective value and where the
+// value came from, for debugging which of environment, config file and
+// defaults won. Secrets and passwords in URLs are redacted.
+func printConfig(configPath string) {
+	if configPath == "" {
+		configPath = "none"
 	}
-
-	if config.Debug {
-		log.Printf("Collected metrics: CPU=%.2f%%, Memory=%.2f%%, Disk=%.2f%%", 
-			payload.CPUUsagePercent, payload.MemoryUsagePercent, payload.DiskUsagePercent)
-		log.Printf("Memory details: Used=%d bytes (%.1f GB), Total=%d bytes (%.1f GB), Available=%d bytes (%.1f GB)",
-			payload.MemoryUsedBytes, float64(payload.MemoryUsedBytes)/(1024*1024*1024),
-			payload.MemoryTotalBytes, float64(payload.MemoryTotalBytes)/(1024*1024*1024),
-			payload.MemoryAvailableBytes, float64(payload.MemoryAvailableBytes)/(1024*1024*1024))
-		log.Printf("Swap details: Used=%d bytes (%.1f GB), Total=%d bytes (%.1f GB)",
-			payload.SwapUsedBytes, float64(payload.SwapUsedBytes)/(1024*1024*1024),
-			payload.SwapTotalBytes, float64(payload.SwapTotalBytes)/(1024*1024*1024))
+	fmt.Printf("# Config file: %s\n", configPath)
+	names := make([]string, 0, len(readSettings))
+	for name := range readSettings {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		s := readSettings[name]
+		value := s.value
+		if secretSettings[name] && value != "" {
+			value = sender.MaskSecret(value)
+		} else if u, err := url.Parse(value); err == nil && u.User != nil {
+			value = u.Redacted()
+		}
+		fmt.Fprintf(w, "%s=%s\t# %s\n", name, value, s.source)
+	}
+	w.Flush()
+}
 
-	if err := sendMetrics(config, payload); err != nil {
-		log.Printf("Error sending metrics: %v", err)
+// configTags returns the custom tags. CRICKET_TAGS sets several at once,
+// CRICKET_TAG_<KEY> one each, which wins when both name the same key.
+func configTags() (map[string]string, error) {
+	tags, err := parseTags(getEnv("CRICKET_TAGS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRICKET_TAGS: %w", err)
+	}
+	for key, value := range envTags() {
+		tags[key] = value
 	}
+	return tags, nil
 }
 
-func collectSystemMetrics(config Config) (*MetricsPayload, error) {
-	hostname, _ := os.Hostname()
-	hostInfo, _ := host.Info()
-
-	payload := &MetricsPayload{
-		// Server information for auto-registration
-		ServerName:      config.ServerName,
-		Hostname:        hostname,
-		OperatingSystem: hostInfo.OS,
-		Architecture:    runtime.GOARCH,
-		Tags: map[string]string{
-			"collector": "cricket-go-collector",
-			"version":   "1.0.0",
-		},
-		
-		// System information
-		UptimeSeconds:   hostInfo.Uptime,
-		BootTime:        hostInfo.BootTime,
-		KernelVersion:   hostInfo.KernelVersion,
-		PlatformFamily:  hostInfo.PlatformFamily,
-		PlatformVersion: hostInfo.PlatformVersion,
-		HostID:          hostInfo.HostID,
-		Virtualization:  hostInfo.VirtualizationSystem,
-		
-		// Metrics
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-	}
-
-	// CPU information
-	cpuInfo, err := cpu.Info()
-	if err == nil && len(cpuInfo) > 0 {
-		payload.CPUModel = cpuInfo[0].ModelName
-		payload.CPUCores = cpuInfo[0].Cores
-		payload.CPUThreads = int32(len(cpuInfo)) // Total logical CPUs
-	}
-
-	// CPU metrics
-	cpuPercent, err := cpu.Percent(time.Second, false)
-	if err == nil && len(cpuPercent) > 0 {
-		payload.CPUUsagePercent = cpuPercent[0]
-	}
-
-	// Load average
-	loadAvg, err := load.Avg()
-	if err == nil {
-		payload.CPULoad1m = loadAvg.Load1
-		payload.CPULoad5m = loadAvg.Load5
-		payload.CPULoad15m = loadAvg.Load15
-	}
-
-	// Memory metrics
-	memInfo, err := mem.VirtualMemory()
-	if err == nil {
-		payload.MemoryUsagePercent = memInfo.UsedPercent
-		payload.MemoryUsedBytes = memInfo.Used
-		payload.MemoryTotalBytes = memInfo.Total
-		payload.MemoryAvailableBytes = memInfo.Available
-	}
-
-	// Swap metrics
-	swapInfo, err := mem.SwapMemory()
-	if err == nil {
-		payload.SwapUsedBytes = swapInfo.Used
-		payload.SwapTotalBytes = swapInfo.Total
-	}
-
-	// Process counts
-	processes, err := process.Processes()
-	if err == nil {
-		var running, sleeping uint64
-		for _, proc := range processes {
-			status, err := proc.Status()
-			if err == nil && len(status) > 0 {
-				switch status[0] {
-				case "R", "Running":
-					running++
-				case "S", "Sleeping":
-					sleeping++
-				}
-			}
+// parseTags parses a comma-separated list of key=value tags, as in
+// CRICKET_TAGS="env=prod,team=payments". A backslash escapes a comma, equals
+// sign or backslash that is part of a key or value. Every tag needs a key
+// and a value.
+func parseTags(value string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, entry := range splitEscaped(value, ',', -1) {
+		if strings.TrimSpace(entry) == "" {
+			continue
 		}
-		payload.TotalProcesses = uint64(len(processes))
-		payload.RunningProcesses = running
-		payload.SleepingProcesses = sleeping
-	}
-
-	// Disk metrics (root filesystem)
-	diskInfo, err := disk.Usage("/")
-	if err == nil {
-		payload.DiskUsagePercent = diskInfo.UsedPercent
-		payload.DiskUsedBytes = diskInfo.Used
-		payload.DiskTotalBytes = diskInfo.Total
-		payload.DiskAvailableBytes = diskInfo.Free
-	}
-	
-	// Per-disk information
-	diskDevices := []DiskDevice{}
-	// Get I/O stats for devices (do this once, use for both per-disk and totals)
-	diskIOStats, _ := disk.IOCounters()
-	
-	partitions, err := disk.Partitions(false) // false = only physical devices
-	if err == nil {
-		
-		if config.Debug {
-			log.Printf("Found %d partitions", len(partitions))
-		}
-		
-		for _, partition := range partitions {
-			// Skip special filesystems
-			if partition.Fstype == "tmpfs" || partition.Fstype == "devtmpfs" || 
-			   partition.Fstype == "sysfs" || partition.Fstype == "proc" ||
-			   partition.Fstype == "devpts" || partition.Fstype == "securityfs" ||
-			   partition.Fstype == "cgroup" || partition.Fstype == "cgroup2" ||
-			   partition.Fstype == "overlay" {
-				continue
-			}
-			
-			// Get usage stats for this partition
-			usage, err := disk.Usage(partition.Mountpoint)
-			if err != nil {
-				if config.Debug {
-					log.Printf("Skipping %s: %v", partition.Mountpoint, err)
-				}
-				continue
-			}
-			
-			device := DiskDevice{
-				Device:         partition.Device,
-				Mountpoint:     partition.Mountpoint,
-				Filesystem:     partition.Fstype,
-				UsagePercent:   usage.UsedPercent,
-				UsedBytes:      usage.Used,
-				TotalBytes:     usage.Total,
-				AvailableBytes: usage.Free,
-			}
-			
-			// Try to match with I/O stats
-			// Clean device name for I/O stats lookup
-			deviceName := strings.TrimPrefix(partition.Device, "/dev/")
-			
-			// Try different device name variations for I/O stats
-			ioStatNames := []string{
-				deviceName,                    // e.g., "sda1"
-				strings.TrimRight(deviceName, "0123456789"), // e.g., "sda" from "sda1"
-			}
-			
-			for _, name := range ioStatNames {
-				if ioStat, exists := diskIOStats[name]; exists {
-					device.ReadBytes = ioStat.ReadBytes
-					device.WriteBytes = ioStat.WriteBytes
-					device.ReadOps = ioStat.ReadCount
-					device.WriteOps = ioStat.WriteCount
-					break
-				}
-			}
-			
-			diskDevices = append(diskDevices, device)
-			
-			if config.Debug {
-				log.Printf("Added disk: %s (%s) -> %s, %.1f%% used", 
-					device.Device, device.Filesystem, device.Mountpoint, device.UsagePercent)
-			}
+		parts := splitEscaped(entry, '=', 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("tag %q has no value, expected key=value", unescapeTag(strings.TrimSpace(entry)))
 		}
-		
-		if config.Debug {
-			log.Printf("Collected %d disk devices", len(diskDevices))
+		key, value := unescapeTag(strings.TrimSpace(parts[0])), unescapeTag(strings.TrimSpace(parts[1]))
+		if key == "" {
+			return nil, fmt.Errorf("tag with value %q has no key", value)
 		}
-	}
-	payload.DiskDevices = diskDevices
-
-	// Disk I/O metrics (aggregate totals - reuse the diskIOStats we already fetched)
-	if diskIOStats != nil {
-		// Sum up all disk devices
-		var totalReadBytes, totalWriteBytes, totalReadOps, totalWriteOps, totalIOTime uint64
-		for _, ioStat := range diskIOStats {
-			totalReadBytes += ioStat.ReadBytes
-			totalWriteBytes += ioStat.WriteBytes
-			totalReadOps += ioStat.ReadCount
-			totalWriteOps += ioStat.WriteCount
-			totalIOTime += ioStat.IoTime
+		if value == "" {
+			return nil, fmt.Errorf("tag %q has no value", key)
 		}
-		payload.DiskReadBytes = totalReadBytes
-		payload.DiskWriteBytes = totalWriteBytes
-		payload.DiskReadOps = totalReadOps
-		payload.DiskWriteOps = totalWriteOps
-		payload.DiskIOTime = totalIOTime
-	}
-
-	// Network metrics
-	netStats, err := net.IOCounters(false)
-	if err == nil && len(netStats) > 0 {
-		payload.NetworkRXBytes = netStats[0].BytesRecv
-		payload.NetworkTXBytes = netStats[0].BytesSent
-		payload.NetworkRXPackets = netStats[0].PacketsRecv
-		payload.NetworkTXPackets = netStats[0].PacketsSent
-		payload.NetworkRXErrors = netStats[0].Errin
-		payload.NetworkTXErrors = netStats[0].Errout
+		tags[key] = value
 	}
-
-	return payload, nil
+	return tags, nil
 }
 
-func sendMetrics(config Config, payload *MetricsPayload) error {
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal metrics: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", config.APIBaseURL+"/api/metrics/ingest", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// splitEscaped splits s around the separators that aren't escaped with a
+// backslash, into at most n parts (all of them when n < 0). The escapes are
+// left in place for unescapeTag.
+func splitEscaped(s string, sep byte, n int) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s) && (n < 0 || len(parts) < n-1); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
 	}
+	return append(parts, s[start:])
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.APIKey)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send metrics: %w", err)
+// unescapeTag removes the backslashes escaping the next character.
+func unescapeTag(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
 	}
-	defer resp.Body.Close()
+	return b.String()
+}
 
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("metrics submission failed with status %d: %s", resp.StatusCode, string(body))
+// envTags collects custom tags from CRICKET_TAG_<KEY>=<value> variables. The
+// key is the rest of the variable name lowercased, underscores kept as they
+// are: CRICKET_TAG_COST_CENTER=42 becomes tags["cost_center"]="42". Empty
+// values are ignored.
+func envTags() map[string]string {
+	tags := map[string]string{}
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		key := strings.ToLower(strings.TrimPrefix(name, "CRICKET_TAG_"))
+		if !strings.HasPrefix(name, "CRICKET_TAG_") || key == "" || value == "" {
+			continue
+		}
+		tags[key] = value
+		recordSetting(name, value, true)
 	}
-
-	return nil
-}
\ No newline at end of file
+	return tags
+}
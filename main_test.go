@@ -0,0 +1,333 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: map[string]string{}},
+		{name: "pairs", value: "env=prod,team=payments", want: map[string]string{"env": "prod", "team": "payments"}},
+		{name: "spaces and trailing comma", value: " env = prod , team=payments,", want: map[string]string{"env": "prod", "team": "payments"}},
+		{name: "escaped comma", value: `note=a\,b,env=prod`, want: map[string]string{"note": "a,b", "env": "prod"}},
+		{name: "escaped equals", value: `a\=b=c=d`, want: map[string]string{"a=b": "c=d"}},
+		{name: "escaped backslash", value: `path=C:\\temp`, want: map[string]string{"path": `C:\temp`}},
+		{name: "later key wins", value: "env=dev,env=prod", want: map[string]string{"env": "prod"}},
+		{name: "missing value", value: "env", wantErr: true},
+		{name: "empty value", value: "env=", wantErr: true},
+		{name: "missing key", value: "=prod", wantErr: true},
+		{name: "escaped separator is not one", value: `env\=prod`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTags(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTags(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTags(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigTagsEnvTagOverridesTags(t *testing.T) {
+	t.Setenv("CRICKET_TAGS", "env=staging,team=payments")
+	t.Setenv("CRICKET_TAG_ENV", "prod")
+
+	got, err := configTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"env": "prod", "team": "payments"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("configTags() = %v, want %v", got, want)
+	}
+}
+
+func TestEnvTags(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "key lowercased",
+			env:  map[string]string{"CRICKET_TAG_FOO_BAR": "baz"},
+			want: map[string]string{"foo_bar": "baz"},
+		},
+		{
+			name: "mixed case",
+			env:  map[string]string{"CRICKET_TAG_Rack_Row": "r1", "CRICKET_TAG_DATACENTER": "Fra1"},
+			want: map[string]string{"rack_row": "r1", "datacenter": "Fra1"},
+		},
+		{
+			name: "empty value",
+			env:  map[string]string{"CRICKET_TAG_ROLE": "", "CRICKET_TAG_TEAM": "payments"},
+			want: map[string]string{"team": "payments"},
+		},
+		{
+			name: "empty key",
+			env:  map[string]string{"CRICKET_TAG_": "orphan"},
+			want: map[string]string{},
+		},
+		{
+			name: "prefix is case-sensitive",
+			env:  map[string]string{"cricket_tag_env": "prod", "CRICKET_TAGS": "env=staging"},
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if got := envTags(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("envTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithCloudTagsKeepsConfiguredTags(t *testing.T) {
+	cloud := map[string]string{"cloud": "aws", "region": "eu-west-1"}
+	configured := map[string]string{"region": "eu-central-1", "env": "prod"}
+
+	got := withCloudTags(cloud, configured)
+	want := map[string]string{"cloud": "aws", "region": "eu-central-1", "env": "prod"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withCloudTags() = %v, want %v", got, want)
+	}
+}
+
+// readTestConfig runs readConfig as if the collector was started with env
+// added to the environment.
+func readTestConfig(t *testing.T, opts options, env map[string]string) (Config, error) {
+	t.Helper()
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	started := processEnv
+	processEnv = os.Environ()
+	t.Cleanup(func() {
+		// Drop whatever flags and config files exported before t.Setenv
+		// puts the environment back
+		restoreEnv()
+		processEnv = started
+	})
+	config, _, err := readConfig(opts)
+	return config, err
+}
+
+func TestTLSSettingAliases(t *testing.T) {
+	tests := []struct {
+		name                      string
+		env                       map[string]string
+		wantCA, wantCert, wantKey string
+	}{
+		{
+			name:     "file names",
+			env:      map[string]string{"CRICKET_TLS_CA_FILE": "ca.pem", "CRICKET_TLS_CERT_FILE": "cert.pem", "CRICKET_TLS_KEY_FILE": "key.pem"},
+			wantCA:   "ca.pem",
+			wantCert: "cert.pem",
+			wantKey:  "key.pem",
+		},
+		{
+			name:     "aliases",
+			env:      map[string]string{"CRICKET_TLS_CA_CERT": "ca.pem", "CRICKET_TLS_CLIENT_CERT": "cert.pem", "CRICKET_TLS_CLIENT_KEY": "key.pem"},
+			wantCA:   "ca.pem",
+			wantCert: "cert.pem",
+			wantKey:  "key.pem",
+		},
+		{
+			name:     "file names win",
+			env:      map[string]string{"CRICKET_TLS_CERT_FILE": "cert.pem", "CRICKET_TLS_CLIENT_CERT": "other.pem", "CRICKET_TLS_KEY_FILE": "key.pem", "CRICKET_TLS_CLIENT_KEY": "other-key.pem"},
+			wantCert: "cert.pem",
+			wantKey:  "key.pem",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := readTestConfig(t, options{}, tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := config.Sender
+			if output.TLSCAFile != tt.wantCA || output.TLSCertFile != tt.wantCert || output.TLSKeyFile != tt.wantKey {
+				t.Errorf("CA %q, cert %q, key %q, want %q, %q, %q",
+					output.TLSCAFile, output.TLSCertFile, output.TLSKeyFile, tt.wantCA, tt.wantCert, tt.wantKey)
+			}
+		})
+	}
+}
+
+// writeConfigFile writes a config file named name to a temporary directory.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		check   func(t *testing.T, config Config)
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			file: "collector.yaml",
+			content: `api_url: https://ingest.example.com/
+collect_interval: 30
+server_name: web-1
+ping_targets: [10.0.0.1, gateway]
+tls:
+  ca_file: /etc/ssl/ca.pem
+`,
+			check: func(t *testing.T, config Config) {
+				if config.CollectInterval != 30 || config.Sender.APIBaseURL != "https://ingest.example.com" || config.Collectors.ServerName != "web-1" {
+					t.Errorf("interval %d, API URL %q, server name %q", config.CollectInterval, config.Sender.APIBaseURL, config.Collectors.ServerName)
+				}
+				if !reflect.DeepEqual(config.Collectors.ProbeTargets, []string{"10.0.0.1", "gateway"}) {
+					t.Errorf("lists: ping targets %v", config.Collectors.ProbeTargets)
+				}
+				if config.Sender.TLSCAFile != "/etc/ssl/ca.pem" {
+					t.Errorf("nested keys: CA file %q", config.Sender.TLSCAFile)
+				}
+			},
+		},
+		{
+			name:    "json with CRICKET_ keys",
+			file:    "collector.json",
+			content: `{"CRICKET_COLLECT_INTERVAL": 120, "spool_max_bytes": 50000000}`,
+			check: func(t *testing.T, config Config) {
+				if config.CollectInterval != 120 || config.Sender.SpoolMaxBytes != 50000000 {
+					t.Errorf("interval %d, spool max bytes %d", config.CollectInterval, config.Sender.SpoolMaxBytes)
+				}
+			},
+		},
+		{
+			name:    "environment wins",
+			file:    "collector.yml",
+			content: "collect_interval: 30\nserver_name: web-1\n",
+			env:     map[string]string{"CRICKET_COLLECT_INTERVAL": "15"},
+			check: func(t *testing.T, config Config) {
+				if config.CollectInterval != 15 || config.Collectors.ServerName != "web-1" {
+					t.Errorf("interval %d, server name %q, want 15 from the environment and web-1 from the file", config.CollectInterval, config.Collectors.ServerName)
+				}
+			},
+		},
+		{name: "unsupported type", file: "collector.toml", content: "collect_interval = 30", wantErr: "unsupported config file type"},
+		{name: "invalid yaml", file: "collector.yaml", content: "collect_interval: [30", wantErr: "failed to parse"},
+		{name: "invalid json", file: "collector.json", content: `{"collect_interval": }`, wantErr: "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.file, tt.content)
+			config, err := readTestConfig(t, options{configPath: path}, tt.env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readConfig() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestMissingConfigFile(t *testing.T) {
+	_, err := readTestConfig(t, options{configPath: filepath.Join(t.TempDir(), "missing.yaml")}, nil)
+	if err == nil {
+		t.Error("readConfig() succeeded, want an error for a config file that was asked for but doesn't exist")
+	}
+}
+
+func TestFlagPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		env        map[string]string
+		flags      map[string]string
+		want       int
+		wantSource string
+	}{
+		{name: "default", want: 60, wantSource: "default"},
+		{name: "file", file: "collect_interval: 30\n", want: 30, wantSource: "file"},
+		{name: "environment over file", file: "collect_interval: 30\n", env: map[string]string{"CRICKET_COLLECT_INTERVAL": "20"}, want: 20, wantSource: "env"},
+		{
+			name:       "flag over environment and file",
+			file:       "collect_interval: 30\n",
+			env:        map[string]string{"CRICKET_COLLECT_INTERVAL": "20"},
+			flags:      map[string]string{"CRICKET_COLLECT_INTERVAL": "10"},
+			want:       10,
+			wantSource: "flag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := options{settings: tt.flags}
+			if tt.file != "" {
+				opts.configPath = writeConfigFile(t, "collector.yaml", tt.file)
+			}
+			config, err := readTestConfig(t, opts, tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.CollectInterval != tt.want {
+				t.Errorf("interval = %d, want %d", config.CollectInterval, tt.want)
+			}
+			if source := readSettings["CRICKET_COLLECT_INTERVAL"].source; source != tt.wantSource {
+				t.Errorf("source = %q, want %q", source, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestFlagsDontOutliveReload(t *testing.T) {
+	// A reload reads the environment the collector was started with again,
+	// plus the same flags, not what the previous read exported
+	opts := options{settings: map[string]string{"CRICKET_SERVER_NAME": "from-flag"}}
+	if _, err := readTestConfig(t, opts, nil); err != nil {
+		t.Fatal(err)
+	}
+	config, _, err := readConfig(options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Collectors.ServerName == "from-flag" {
+		t.Error("server name set by a previous read's flag")
+	}
+}
+
+func TestValidateCollectInterval(t *testing.T) {
+	for _, interval := range []string{"0", "-5"} {
+		t.Run(interval, func(t *testing.T) {
+			config, err := readTestConfig(t, options{}, map[string]string{"CRICKET_COLLECT_INTERVAL": interval, "CRICKET_API_KEY": "key"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := config.validate(); err == nil || !strings.Contains(err.Error(), "CRICKET_COLLECT_INTERVAL") {
+				t.Errorf("validate() = %v, want an error about CRICKET_COLLECT_INTERVAL", err)
+			}
+		})
+	}
+}